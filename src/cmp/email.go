@@ -0,0 +1,111 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package cmp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Domains that ignore dots and plus suffixes in the local part of an email.
+var gmailDomains = []string{"gmail.com", "googlemail.com"}
+
+// sidSaltVariable is the environment variable that contains the salt used when
+// hashing emails. The salt must be kept secret and be the same for every
+// instance of the CMP so the same email always has the same hash.
+const sidSaltVariable = "SWAN_SID_SALT"
+
+// hashedEmailPrefix marks the values stored in SWAN that are emails hashed by
+// this CMP. Values without the marker are never treated as hashed emails.
+const hashedEmailPrefix = "sha256:"
+
+// sidSalt is the salt used when hashing emails.
+var sidSalt struct {
+	once  sync.Once
+	value string
+}
+
+// normalizeEmail validates the email address and returns it in a standard
+// form so that the same person always gets the same signed in identifier. If
+// gmail is true then dots and plus suffixes are removed for Gmail addresses.
+func normalizeEmail(e string, gmail bool) (string, error) {
+	e = strings.TrimSpace(e)
+	a, err := mail.ParseAddress(e)
+	if err != nil || a.Address != e || a.Name != "" {
+		return "", fmt.Errorf("'%s' is not a valid email address", e)
+	}
+	e = strings.ToLower(a.Address)
+	i := strings.LastIndex(e, "@")
+	local, domain := e[:i], e[i+1:]
+	if strings.Contains(domain, ".") == false {
+		return "", fmt.Errorf("'%s' is not a valid email address", e)
+	}
+	if gmail {
+		for _, g := range gmailDomains {
+			if domain == g {
+				if p := strings.Index(local, "+"); p >= 0 {
+					local = local[:p]
+				}
+				local = strings.ReplaceAll(local, ".", "")
+				domain = gmailDomains[0]
+				break
+			}
+		}
+	}
+	return local + "@" + domain, nil
+}
+
+// hashEmail returns the salted SHA-256 of the normalized email as a hex string
+// with the hashed email marker. This is used as the signed in identifier so
+// that the raw email address is never passed to SWAN or to bidders.
+func hashEmail(e string) string {
+	h := sha256.Sum256([]byte(getSIDSalt() + e))
+	return fmt.Sprintf("%s%x", hashedEmailPrefix, h)
+}
+
+// isHashedEmail returns true if the value was hashed by hashEmail.
+func isHashedEmail(v string) bool {
+	return strings.HasPrefix(v, hashedEmailPrefix) &&
+		len(v) == len(hashedEmailPrefix)+sha256.Size*2
+}
+
+// getSIDSalt returns the salt from the environment. If the salt is not set
+// then a random salt is used until the CMP is restarted and a warning is
+// output as hashed emails will not match those from other instances.
+func getSIDSalt() string {
+	sidSalt.once.Do(func() {
+		sidSalt.value = os.Getenv(sidSaltVariable)
+		if sidSalt.value == "" {
+			b := make([]byte, 32)
+			_, err := rand.Read(b)
+			if err != nil {
+				panic(err)
+			}
+			sidSalt.value = fmt.Sprintf("%x", b)
+			fmt.Printf(
+				"Environment variable '%s' not set. Using a random salt for "+
+					"hashed emails.\n",
+				sidSaltVariable)
+		}
+	})
+	return sidSalt.value
+}
@@ -0,0 +1,127 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package cmp
+
+import (
+	"demotest"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	for _, v := range []struct {
+		email    string
+		gmail    bool
+		expected string
+	}{
+		{" Jane.Doe@Example.COM ", false, "jane.doe@example.com"},
+		{"jane.doe+news@example.com", true, "jane.doe+news@example.com"},
+		{"Jane.Doe+news@gmail.com", true, "janedoe@gmail.com"},
+		{"j.a.n.e@googlemail.com", true, "jane@gmail.com"},
+		{"jane.doe+news@gmail.com", false, "jane.doe+news@gmail.com"},
+	} {
+		e, err := normalizeEmail(v.email, v.gmail)
+		if err != nil {
+			t.Errorf("'%s' %s", v.email, err)
+		} else if e != v.expected {
+			t.Errorf("'%s' normalized to '%s', expected '%s'",
+				v.email,
+				e,
+				v.expected)
+		}
+	}
+}
+
+func TestNormalizeEmailInvalid(t *testing.T) {
+	for _, e := range []string{
+		"",
+		"jane",
+		"jane@localhost",
+		"Jane <jane@example.com>",
+		"jane@example.com, john@example.com",
+		strings.Repeat("a", 64),
+	} {
+		if _, err := normalizeEmail(e, true); err == nil {
+			t.Errorf("'%s' should be invalid", e)
+		}
+	}
+}
+
+func TestHashEmail(t *testing.T) {
+	h := hashEmail("jane@example.com")
+	if isHashedEmail(h) == false {
+		t.Fatalf("'%s' should be a hashed email", h)
+	}
+	if hashEmail("jane@example.com") != h {
+		t.Fatal("the same email should have the same hash")
+	}
+	if hashEmail("john@example.com") == h {
+		t.Fatal("different emails should have different hashes")
+	}
+	if isHashedEmail(strings.TrimPrefix(h, hashedEmailPrefix)) {
+		t.Fatal("a hash without the marker should not be a hashed email")
+	}
+}
+
+// TestDialogHashesFormEmail checks that a value entered in the form is always
+// hashed, even if it looks like a hash, and that a stored hash is retained if
+// no email is entered.
+func TestDialogHashesFormEmail(t *testing.T) {
+	d := demotest.NewDomain(t, demotest.NewConfig(), "cmp.com", "")
+	for _, v := range []struct {
+		form     string
+		stored   string
+		expected string
+		invalid  bool
+	}{
+		{"Jane@Example.com", "", hashEmail("jane@example.com"), false},
+		{strings.Repeat("a", 64), "", "", true},
+		{hashEmail("jane@example.com"), "", "", true},
+		{"", hashEmail("john@example.com"), hashEmail("john@example.com"), false},
+	} {
+		f := url.Values{}
+		f.Set("cbid", "cbid")
+		f.Set("email", v.form)
+		r := httptest.NewRequest(
+			"POST",
+			"/dialog",
+			strings.NewReader(f.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		err := r.ParseForm()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := dialogModel{Values: url.Values{}, domain: d}
+		m.Set("email", v.stored)
+		err = dialogUpdateModel(d, r, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.invalid {
+			if m.EmailError() == "" || m.update {
+				t.Errorf("'%s' should not be accepted", v.form)
+			}
+		} else if m.Get("email") != v.expected || m.EmailProvided() == false {
+			t.Errorf("'%s' stored as '%s', expected '%s'",
+				v.form,
+				m.Get("email"),
+				v.expected)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package cmp
+
+import (
+	"common"
+	"fmt"
+	"net/http"
+	"owid"
+	"swan"
+)
+
+// cbidCookie is the name of the cookie the CMP uses to remember the CBID the
+// user last saved via the dialog.
+const cbidCookie = "cbid"
+
+// verifyEvidence checks that the processor OWID was signed over the offer by
+// its creator, that the offer was signed by its creator, and that the CBID in
+// the offer is the complainant's current CBID. A description of the checks is
+// returned for inclusion in the complaint, or an error if any check fails.
+func verifyEvidence(
+	d *common.Domain,
+	r *http.Request,
+	offerID *owid.OWID,
+	swanOWID *owid.OWID) (string, error) {
+
+	// The offer must be valid on its own.
+	v, err := d.Config.VerifyOWID(offerID, nil)
+	if err != nil {
+		return "", err
+	}
+	if v == false {
+		return "", fmt.Errorf("Offer ID signature is not valid for '%s'",
+			offerID.Domain)
+	}
+
+	// The processor OWID must be signed over the offer unless it is the offer.
+	if swanOWID.AsString() != offerID.AsString() {
+		v, err = d.Config.VerifyOWID(swanOWID, offerID)
+		if err != nil {
+			return "", err
+		}
+		if v == false {
+			return "", fmt.Errorf(
+				"'%s' did not sign the offer provided", swanOWID.Domain)
+		}
+	}
+
+	// The CBID in the offer must be the complainant's current CBID.
+	o, err := swan.OfferFromOWID(offerID)
+	if err != nil {
+		return "", err
+	}
+	c, err := getCurrentCBID(d, r)
+	if err != nil {
+		return "", err
+	}
+	if c == "" {
+		return "", fmt.Errorf("Current CBID is needed to verify the " +
+			"complaint. Make the complaint from the advert information page.")
+	}
+	if c != o.CBIDAsString() {
+		return "", fmt.Errorf("Offer CBID does not match the current CBID")
+	}
+
+	return fmt.Sprintf(
+		"Offer signature by '%s' valid. Signature by '%s' over the offer "+
+			"valid. Offer CBID matches complainant's current CBID.",
+		offerID.Domain,
+		swanOWID.Domain), nil
+}
+
+// getCurrentCBID returns the complainant's current CBID from the CBID OWID in
+// the form, which the publisher adds to the advert information link, after
+// verifying it was signed by its creator. If there is no CBID OWID then the
+// CMP's cookie, which is set when the user saves their choices with the CMP
+// dialog, is used. An empty string is returned if neither is present.
+func getCurrentCBID(d *common.Domain, r *http.Request) (string, error) {
+	if r.Form.Get("cbid") != "" {
+		c, err := owid.FromBase64(r.Form.Get("cbid"))
+		if err != nil {
+			return "", fmt.Errorf("'cbid' not a valid OWID")
+		}
+		v, err := d.Config.VerifyOWID(c, nil)
+		if err != nil {
+			return "", err
+		}
+		if v == false {
+			return "", fmt.Errorf("'cbid' signature is not valid for '%s'",
+				c.Domain)
+		}
+		return c.PayloadAsString(), nil
+	}
+	k, err := r.Cookie(cbidCookie)
+	if err == nil && k.Value != "" {
+		return k.Value, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,141 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package cmp
+
+import (
+	"common"
+	"demotest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"owid"
+	"strings"
+	"swan"
+	"testing"
+	"time"
+)
+
+// newSignedOWID returns an OWID created and signed by the domain over the
+// others.
+func newSignedOWID(
+	t *testing.T,
+	d *common.Domain,
+	payload []byte,
+	date time.Time,
+	others ...*owid.OWID) *owid.OWID {
+	t.Helper()
+	o := d.OWID.CreateOWID(payload)
+	o.Date = date
+	err := d.OWID.Sign(o, others...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestVerifyEvidenceCBID(t *testing.T) {
+	c := demotest.NewConfig()
+	pub := demotest.NewDomain(t, c, "pub.com", "")
+	dsp := demotest.NewDomain(t, c, "dsp.com", "")
+	d := demotest.NewDomain(t, c, "cmp.com", "")
+	op := demotest.NewDomain(t, c, "swan.com", "")
+	n := time.Now().UTC().Add(-time.Hour)
+	p, err := (&swan.Offer{CBID: []byte("cbid-1")}).AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newSignedOWID(t, pub, p, n)
+	s := newSignedOWID(t, dsp, nil, n, o)
+	cbid := func(v string, date time.Time) *owid.OWID {
+		return newSignedOWID(t, op, []byte(v), date)
+	}
+	forged := cbid("cbid-1", n.Add(-time.Hour))
+	forged.Signature[0]++
+	for _, v := range []struct {
+		name   string
+		cookie string
+		form   string
+		valid  bool
+	}{
+		{"cookie", "cbid-1", "", true},
+		{"signed by operator before offer",
+			"", cbid("cbid-1", n.Add(-time.Hour)).AsString(), true},
+		{"signed by operator after offer",
+			"", cbid("cbid-1", n.Add(time.Minute)).AsString(), true},
+		{"signed preferred to cookie",
+			"cbid-1", cbid("cbid-2", n).AsString(), false},
+		{"different cookie", "cbid-2", "", false},
+		{"different signed CBID", "", cbid("cbid-2", n).AsString(), false},
+		{"invalid signature", "", forged.AsString(), false},
+		{"not an OWID", "", "cbid-1", false},
+		{"none", "", "", false},
+	} {
+		f := url.Values{}
+		if v.form != "" {
+			f.Set("cbid", v.form)
+		}
+		r := httptest.NewRequest(
+			"POST",
+			"/complain",
+			strings.NewReader(f.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if v.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: cbidCookie, Value: v.cookie})
+		}
+		err := r.ParseForm()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = verifyEvidence(d, r, o, s)
+		if v.valid && err != nil {
+			t.Errorf("%s: %s", v.name, err)
+		} else if v.valid == false && err == nil {
+			t.Errorf("%s: should not be valid", v.name)
+		}
+	}
+}
+
+func TestVerifyEvidenceSignatures(t *testing.T) {
+	c := demotest.NewConfig()
+	pub := demotest.NewDomain(t, c, "pub.com", "")
+	dsp := demotest.NewDomain(t, c, "dsp.com", "")
+	d := demotest.NewDomain(t, c, "cmp.com", "")
+	n := time.Now().UTC()
+	p, err := (&swan.Offer{CBID: []byte("cbid-1")}).AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newSignedOWID(t, pub, p, n)
+	other := newSignedOWID(t, pub, p, n.Add(time.Second))
+	r := httptest.NewRequest("GET", "/complain", nil)
+	r.AddCookie(&http.Cookie{Name: cbidCookie, Value: "cbid-1"})
+	r.ParseForm()
+
+	// Signed over a different offer.
+	_, err = verifyEvidence(d, r, o, newSignedOWID(t, dsp, nil, n, other))
+	if err == nil {
+		t.Error("OWID signed over another offer should not be valid")
+	}
+
+	// Offer changed after it was signed.
+	f := *o
+	f.Payload, _ = (&swan.Offer{CBID: []byte("cbid-1"), PubDomain: "x.com"}).AsByteArray()
+	_, err = verifyEvidence(d, r, &f, newSignedOWID(t, dsp, nil, n, &f))
+	if err == nil {
+		t.Error("changed offer should not be valid")
+	}
+}
@@ -28,6 +28,10 @@ func Handler(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 		handlerDialog(d, w, r)
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, "/stopped") {
+		handlerStopped(d, w, r)
+		return
+	}
 	if strings.HasPrefix(r.URL.Path, "/stop") {
 		handlerStop(d, w, r)
 		return
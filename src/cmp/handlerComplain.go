@@ -21,7 +21,6 @@ import (
 	"common"
 	"fmt"
 	"net/http"
-	"net/url"
 	"owid"
 	"strings"
 	"swan"
@@ -47,13 +46,15 @@ I provided you the following permissions for use of this data.
 You cryptographically signed this information. We therefore agree that you were
 in posession of the information.
 
+	Evidence: {{ .Verification }}
+
 As an organization operating in '{{ .Country }}' you are bound by the following 
 rules.
 
 	{{ .DPRURL }}
 
-I would be grateful if you can respond by email to this address within 7 
-working days.
+I would be grateful if you can respond to this complaint within 7 working 
+days.
 
 Regards,
 
@@ -63,16 +64,27 @@ Regards,
 {{ .OfferID }} {{ .SWANOWID }}
 --- DO NOT CHANGE THE TEXT ABOVE THIS LINE ---`)
 
-// Complaint used to format an email template.
+// Complaint used to format the complaint templates.
 type Complaint struct {
 	Offer        *swan.Offer // The offer that the complaint relates to
-	DPRURL       string
-	Organization string
-	Country      string
+	DPRURL       string      // URL of the data protection regulator
+	Organization string      // Name of the organization complained about
+	Country      string      // Jurisdiction of the organization
+	Contact      string      // Email address of the organization
+	Verification string      // Result of verifying the evidence
 	offerID      *owid.OWID
 	swanOWID     *owid.OWID
 }
 
+// complainModel is used with the complain.html template.
+type complainModel struct {
+	*Complaint
+	Subject string // Subject of the complaint
+	Body    string // Text of the complaint
+	Ticket  string // Ticket ID once the complaint has been stored
+	CBID    string // Base 64 CBID OWID of the complainant if provided
+}
+
 // Date to use in the email template.
 func (c *Complaint) Date() string {
 	return c.swanOWID.Date.Format("2006-01-02")
@@ -117,10 +129,12 @@ func newComplaint(
 	swanID *owid.OWID) (*Complaint, error) {
 	var err error
 
-	// Set the static information associated with the complaint. These are
+	// Set the default information associated with the complaint. These are
+	// replaced with the accused organization's details if they are known.
 	var c Complaint
-	c.DPRURL = "URL of the DPR"
-	c.Country = "Region of the CMP"
+	c.DPRURL = "Unknown"
+	c.Country = "Unknown"
+	c.Contact = "info@" + swanID.Domain
 
 	// Work out the offer ID from the OWID provided.
 	c.Offer, err = swan.OfferFromOWID(offerID)
@@ -132,10 +146,21 @@ func newComplaint(
 	c.offerID = offerID
 	c.swanOWID = swanID
 
-	// Set the organization as the domain for the moment.
+	// Set the organization details from the accused domain's configuration.
 	c.Organization = swanID.Domain
+	a := cfg.FindDomain(swanID.Domain)
+	if a != nil {
+		c.Organization = a.Name
+		c.Contact = a.Contact()
+		if a.Jurisdiction != "" {
+			c.Country = a.Jurisdiction
+		}
+		if a.RegulatorURL != "" {
+			c.DPRURL = a.RegulatorURL
+		}
+	}
 
-	// Return the complain data structure ready for the template email.
+	// Return the complaint data structure ready for the templates.
 	return &c, nil
 }
 
@@ -189,40 +214,61 @@ func handlerComplain(
 		return
 	}
 
+	// Verify the OWIDs provided as evidence before creating the complaint.
+	v, err := verifyEvidence(d, r, offerID, swanOWID)
+	if err != nil {
+		common.ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+		return
+	}
+
 	// Create the complaint object.
 	c, err := newComplaint(d.Config, offerID, swanOWID)
 	if err != nil {
 		common.ReturnServerError(d.Config, w, err)
 		return
 	}
+	c.Verification = v
 
 	// Get the strings for the subject and the body.
+	var m complainModel
+	m.Complaint = c
+	m.CBID = r.Form.Get("cbid")
 	var subject bytes.Buffer
 	err = complaintSubjectTemplate.Execute(&subject, c)
 	if err != nil {
 		common.ReturnServerError(d.Config, w, err)
 		return
 	}
+	m.Subject = subject.String()
 	var body bytes.Buffer
 	err = complaintBodyTemplate.Execute(&body, c)
 	if err != nil {
 		common.ReturnServerError(d.Config, w, err)
 		return
 	}
+	m.Body = body.String()
+
+	// If the method is POST then store the complaint and set the ticket so
+	// the user has a reference for it.
+	if r.Method == "POST" {
+		var t common.Complaint
+		t.Accused = swanOWID.Domain
+		t.CMP = d.Host
+		t.OfferID = r.Form.Get("offerid")
+		t.SWANOWID = r.Form.Get("swanowid")
+		t.Subject = m.Subject
+		t.Body = m.Body
+		err = d.Config.Complaints().Add(&t)
+		if err != nil {
+			common.ReturnServerError(d.Config, w, err)
+			return
+		}
+		m.Ticket = t.Ticket
+	}
 
-	// Create the URL for the email.
-	u := fmt.Sprintf("mailto:info@%s?subject=%s&body=%s",
-		c.swanOWID.Domain,
-		url.PathEscape(subject.String()),
-		url.PathEscape(body.String()))
-
-	// Return the URL as a text string.
-	b := []byte(u)
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// Display the complaint for review, or the ticket if stored.
 	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(b)))
-	_, err = w.Write(b)
+	err = d.LookupHTML("complain.html").Execute(w, &m)
 	if err != nil {
 		common.ReturnServerError(d.Config, w, err)
 		return
@@ -23,20 +23,52 @@ import (
 	"net/http"
 	"net/url"
 	"swift"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type dialogModel struct {
-	url.Values      // Key value pairs
-	update     bool // True if the update should be performed
+	url.Values                // Key value pairs
+	update     bool           // True if the update should be performed
+	domain     *common.Domain // The CMP domain displaying the dialog
+}
+
+// purposeModel is a consent purpose along with the user's current choice.
+type purposeModel struct {
+	common.Purpose
+	Allowed bool // True if the user has allowed the purpose
 }
 
 func (m *dialogModel) Title() string           { return m.Get("title") }
 func (m *dialogModel) CBID() string            { return m.Get("cbid") }
-func (m *dialogModel) Email() string           { return m.Get("email") }
+func (m *dialogModel) EmailError() string      { return m.Get("emailError") }
 func (m *dialogModel) Allow() string           { return m.Get("allow") }
 func (m *dialogModel) BackgroundColor() string { return m.Get("backgroundColor") }
+
+// Email returns the email address entered by the user. The hashed value held
+// in SWAN is never displayed.
+func (m *dialogModel) Email() string {
+	if isHashedEmail(m.Get("email")) {
+		return ""
+	}
+	return m.Get("email")
+}
+
+// EmailProvided returns true if SWAN already holds a hashed email.
+func (m *dialogModel) EmailProvided() bool { return isHashedEmail(m.Get("email")) }
+
+// Purposes returns the consent purposes offered by the CMP with the user's
+// current choice for each.
+func (m *dialogModel) Purposes() []*purposeModel {
+	var p []*purposeModel
+	c := common.ParseConsent(m.Allow())
+	for _, i := range m.domain.ConsentPurposes() {
+		p = append(p, &purposeModel{i, c.Has(i.ID)})
+	}
+	return p
+}
+
 func (m *dialogModel) PublisherHost() string {
 	u, _ := url.Parse(m.Get("returnUrl"))
 	if u != nil {
@@ -89,7 +121,18 @@ func handlerDialog(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 		u, err := getRedirectUpdateURL(d, r, m.Values)
 		if err != nil {
 			common.ReturnProxyError(d.Config, w, err)
+			return
 		}
+
+		// Remember the CBID so that complaints made via this CMP can be
+		// checked against it.
+		http.SetCookie(w, &http.Cookie{
+			Name:     cbidCookie,
+			Value:    m.CBID(),
+			SameSite: http.SameSiteLaxMode,
+			HttpOnly: true,
+			Secure:   d.Config.Scheme == "https",
+			Expires:  time.Now().AddDate(1, 0, 0)})
 		http.Redirect(w, r, u, 303)
 
 	} else {
@@ -108,6 +151,7 @@ func dialogGetModel(d *common.Domain,
 	r *http.Request,
 	m *dialogModel) error {
 	m.Values = make(url.Values)
+	m.domain = d
 
 	// Get the SWAN data from the request path.
 	s := common.GetSWANDataFromRequest(r)
@@ -142,6 +186,9 @@ func dialogGetModel(d *common.Domain,
 	if op.Get("allow") != nil {
 		m.Set("allow", op.Get("allow").Value)
 	}
+	if op.Get("stop") != nil {
+		m.Set("stop", op.Get("stop").Value)
+	}
 
 	return nil
 }
@@ -152,10 +199,14 @@ func dialogUpdateModel(
 	m *dialogModel) error {
 	var err error
 
-	// Copy the field values from the form.
+	// Copy the field values from the form. Only the purposes offered by this
+	// CMP are retained from the ticked check boxes. If no email is entered
+	// then any hashed email already held in SWAN is retained.
 	m.Values.Set("cbid", r.Form.Get("cbid"))
-	m.Values.Set("email", r.Form.Get("email"))
-	m.Values.Set("allow", r.Form.Get("allow"))
+	m.Values.Set("allow", getConsent(d, r).String())
+	if r.Form.Get("email") != "" {
+		m.Values.Set("email", r.Form.Get("email"))
+	}
 
 	// Check to see if the post is as a result of the CBID reset.
 	if r.Form.Get("reset-cbid") != "" {
@@ -175,12 +226,40 @@ func dialogUpdateModel(
 		return nil
 	}
 
+	// Validate and hash any email entered so that the raw address is never
+	// sent to SWAN. Only the CMP hashes emails so values from the form are
+	// always hashed. If the email is invalid then display the dialog with the
+	// error.
+	if r.Form.Get("email") != "" {
+		e, err := normalizeEmail(r.Form.Get("email"), d.NormalizeGmail)
+		if err != nil {
+			m.Set("emailError", err.Error())
+			return nil
+		}
+		m.Set("email", hashEmail(e))
+	}
+
 	// The data should be updated in the SWAN network.
 	m.update = true
 
 	return err
 }
 
+// getConsent returns the purposes ticked in the dialog form that are offered by
+// the CMP domain.
+func getConsent(d *common.Domain, r *http.Request) common.Consent {
+	var c common.Consent
+	for _, p := range d.ConsentPurposes() {
+		for _, v := range r.Form["purpose"] {
+			if v == p.ID {
+				c = append(c, p.ID)
+				break
+			}
+		}
+	}
+	return c
+}
+
 func getRedirectUpdateURL(
 	d *common.Domain,
 	r *http.Request,
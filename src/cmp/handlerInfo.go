@@ -20,6 +20,7 @@ import (
 	"common"
 	"html/template"
 	"net/http"
+	"net/url"
 	"owid"
 	"swan"
 )
@@ -27,10 +28,21 @@ import (
 // infoModel data needed for the advert information interface.
 type infoModel struct {
 	OWIDs     map[*owid.OWID]interface{}
-	Bid       *swan.Bid
+	Bid       *common.Bid
 	Offer     *swan.Offer
 	Root      *owid.OWID
 	ReturnURL template.HTML
+	CBID      string // Base 64 CBID OWID of the user if provided
+}
+
+// Personalized returns true if the user allowed personalized adverts when the
+// offer was created.
+func (m *infoModel) Personalized() bool {
+	if m.Offer == nil {
+		return false
+	}
+	return common.ParseConsent(m.Offer.PreferencesAsString()).Has(
+		common.PurposePersonalizedAds)
 }
 
 func (m *infoModel) findOffer() (*owid.OWID, *swan.Offer) {
@@ -42,15 +54,54 @@ func (m *infoModel) findOffer() (*owid.OWID, *swan.Offer) {
 	return nil, nil
 }
 
-func (m *infoModel) findBid() *swan.Bid {
+// findBid returns the bid of the DSP rather than the bids passed on by the
+// processors between it and the publisher.
+func (m *infoModel) findBid() *common.Bid {
 	for _, v := range m.OWIDs {
-		if b, ok := v.(*swan.Bid); ok {
+		if b, ok := v.(*common.Bid); ok && b.Hop == nil {
 			return b
 		}
 	}
 	return nil
 }
 
+// addTransactionOWIDs adds the OWIDs from the winning bid to the Offer to the
+// form values if the transaction is identified by a reference or Offer ID
+// rather than the OWIDs. The transaction is fetched from the publisher.
+func addTransactionOWIDs(d *common.Domain, v url.Values) error {
+	var t *owid.Node
+	if v.Get("reference") != "" {
+		f, err := common.ParseTransactionRef(d.Config, v.Get("reference"))
+		if err != nil {
+			return err
+		}
+		t, err = f.Resolve(d.Config)
+		if err != nil {
+			return err
+		}
+	} else if v.Get("offerid") != "" {
+		var err error
+		t, err = common.FetchTransaction(d.Config, v.Get("offerid"))
+		if err != nil {
+			return err
+		}
+	} else {
+		return nil
+	}
+	n, err := swan.WinningNode(t)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		n = t
+	}
+	for n != nil {
+		v.Add("owid", n.GetOWIDAsString())
+		n = n.GetParent()
+	}
+	return nil
+}
+
 func handlerInfo(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 
 	// Get the SWAN OWIDs from the form parameters.
@@ -61,14 +112,23 @@ func handlerInfo(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 	}
 	var m infoModel
 	m.OWIDs = make(map[*owid.OWID]interface{})
-	for _, vs := range r.Form {
+	m.CBID = r.Form.Get("cbid")
+	err = addTransactionOWIDs(d, r.Form)
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+	for k, vs := range r.Form {
+		if k == "cbid" || k == "reference" || k == "offerid" {
+			continue
+		}
 		for _, v := range vs {
 			o, err := owid.FromBase64(v)
 			if err != nil {
 				common.ReturnServerError(d.Config, w, err)
 				return
 			}
-			m.OWIDs[o], err = swan.FromOWID(o)
+			m.OWIDs[o], err = common.PayloadFromOWID(o)
 			if err != nil {
 				common.ReturnServerError(d.Config, w, err)
 				return
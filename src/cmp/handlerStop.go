@@ -43,12 +43,28 @@ func handlerStop(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The scope defaults to the advertiser if not provided.
+	scope := r.Form.Get("scope")
+	if scope == "" {
+		scope = common.StopScopeAdvertiser
+	}
+	if common.IsStopScope(scope) == false {
+		common.ReturnStatusCodeError(
+			d.Config,
+			w,
+			fmt.Errorf("Scope '%s' invalid", scope),
+			http.StatusBadRequest)
+		return
+	}
+
 	u, e := d.CreateSWANURL(
 		r,
 		r.Form.Get("returnUrl"),
 		"stop",
 		func(q *url.Values) {
-			q.Set("host", r.Form.Get("host"))
+			q.Set("host", common.NewStopEntry(
+				r.Form.Get("host"),
+				scope).String())
 
 			// Demonstrate the CMP can control the nodes used.
 			q.Set("bounces", "30")
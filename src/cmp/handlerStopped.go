@@ -0,0 +1,117 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package cmp
+
+import (
+	"common"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// stoppedModel is used with the stopped.html template.
+type stoppedModel struct {
+	dialogModel
+}
+
+// Stopped returns the entries in the user's stop list.
+func (m *stoppedModel) Stopped() common.StopList {
+	return common.ParseStopList(m.Get("stop"))
+}
+
+// Scopes returns the scopes the user can choose from when adding a stop.
+func (m *stoppedModel) Scopes() []string { return common.StopScopes }
+
+// handlerStopped displays the hosts the user has stopped and lets them remove
+// hosts individually or all together. Changes are sent to SWAN using the
+// update action along with the rest of the user's current data.
+func handlerStopped(d *common.Domain, w http.ResponseWriter, r *http.Request) {
+	var m stoppedModel
+
+	// Parse the form variables.
+	err := r.ParseForm()
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+
+	// Set the SWAN data from the URL in the model.
+	err = dialogGetModel(d, r, &m.dialogModel)
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+
+	// If this is a close request then return to the publisher.
+	if r.Form.Get("close") != "" {
+		http.Redirect(w, r, m.Get("returnUrl"), 303)
+		return
+	}
+
+	// If the method is POST then add or remove the hosts requested from the
+	// list and update SWAN.
+	if r.Method == "POST" {
+		l, err := updateStopList(r, m.Stopped())
+		if err != nil {
+			common.ReturnStatusCodeError(
+				d.Config,
+				w,
+				err,
+				http.StatusBadRequest)
+			return
+		}
+		m.Set("stop", l.String())
+		u, e := getRedirectUpdateURL(d, r, m.Values)
+		if e != nil {
+			common.ReturnProxyError(d.Config, w, e)
+			return
+		}
+		http.Redirect(w, r, u, 303)
+		return
+	}
+
+	// Display the stop list.
+	err = d.LookupHTML("stopped.html").Execute(w, &m)
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+}
+
+// updateStopList returns the stop list with the change requested in the form
+// applied. A host can be added with a scope, or the selected hosts, a single
+// host, or all the hosts removed.
+func updateStopList(
+	r *http.Request,
+	l common.StopList) (common.StopList, error) {
+	if r.Form.Get("add") != "" {
+		if strings.TrimSpace(r.Form.Get("host")) == "" ||
+			common.IsStopScope(r.Form.Get("scope")) == false {
+			return nil, fmt.Errorf("Host and a valid scope must be provided")
+		}
+		return l.Add(common.NewStopEntry(
+			strings.TrimSpace(r.Form.Get("host")),
+			r.Form.Get("scope"))), nil
+	}
+	if r.Form.Get("unstop-all") != "" {
+		return nil, nil
+	}
+	if r.Form.Get("unstop") != "" {
+		return l.Remove(r.Form.Get("unstop")), nil
+	}
+	return l.Remove(r.Form["selected"]...), nil
+}
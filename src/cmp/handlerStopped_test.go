@@ -0,0 +1,77 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package cmp
+
+import (
+	"common"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUpdateStopList(t *testing.T) {
+	l := common.ParseStopList("a.com\r\nb.com|supplier\r\nc.com")
+	for _, v := range []struct {
+		form     url.Values
+		expected []string
+	}{
+		{url.Values{"add": {"1"}, "host": {" d.com "}, "scope": {"category"}},
+			[]string{"a.com", "b.com", "c.com", "d.com"}},
+		{url.Values{"unstop": {"supplier|b.com"}},
+			[]string{"a.com", "c.com"}},
+		{url.Values{"selected": {"advertiser|a.com", "advertiser|c.com"}},
+			[]string{"b.com"}},
+		{url.Values{"unstop-all": {"1"}}, nil},
+	} {
+		r := httptest.NewRequest(
+			"POST",
+			"/stopped",
+			strings.NewReader(v.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+		u, err := updateStopList(r, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var h []string
+		for _, e := range u {
+			h = append(h, e.Host)
+		}
+		if strings.Join(h, ",") != strings.Join(v.expected, ",") {
+			t.Errorf("%v gave %v, expected %v", v.form, h, v.expected)
+		}
+	}
+}
+
+func TestUpdateStopListInvalid(t *testing.T) {
+	for _, f := range []url.Values{
+		{"add": {"1"}, "host": {" "}, "scope": {"advertiser"}},
+		{"add": {"1"}, "host": {"d.com"}, "scope": {"everything"}},
+	} {
+		r := httptest.NewRequest(
+			"POST",
+			"/stopped",
+			strings.NewReader(f.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+		_, err := updateStopList(r, nil)
+		if err == nil {
+			t.Errorf("%v should be invalid", f)
+		}
+	}
+}
@@ -16,8 +16,89 @@
 
 package common
 
+import (
+	"fmt"
+	"time"
+)
+
 // Advert represents an advert to display on a publishers web page.
 type Advert struct {
+	ID            string // Identifier of the creative within the advertiser
 	MediaURL      string // The URL of the content of the advert provided in response
 	AdvertiserURL string // The URL to direct the browser to if the advert is selected
+	Category      string // Category of the advert such as gambling, if any
+	// Purposes the user must allow for the advert to be used. Adverts chosen
+	// using the user's preferences need the personalized-ads purpose.
+	Purposes []string
+	Caps     []FrequencyCap // Frequency caps that apply to each CBID
+	Format   string         // Format of the advert, banner if not set
+	Width    int            // Width of the advert if it can't be scaled
+	Height   int            // Height of the advert if it can't be scaled
+	CPM      float64        // Price per thousand impressions outside campaigns
+	// The video or native creative for adverts of those formats. The media URL
+	// of the advert is the VAST document or native assets.
+	Video  *VideoCreative
+	Native *NativeCreative
+}
+
+// FormatOrDefault returns the format of the advert.
+func (a *Advert) FormatOrDefault() string {
+	if a.Format == "" {
+		return FormatBanner
+	}
+	return a.Format
+}
+
+// maxCapPeriod returns the longest frequency cap period of the adverts.
+func maxCapPeriod(adverts []Advert) time.Duration {
+	var m time.Duration
+	for _, a := range adverts {
+		for _, f := range a.Caps {
+			if f.Duration() > m {
+				m = f.Duration()
+			}
+		}
+	}
+	return m
+}
+
+// frequencyKey returns the key impressions of the advert are counted against.
+// The media URL is not used as it can change without the advert changing.
+func (a *Advert) frequencyKey() string {
+	return a.AdvertiserURL + "/" + a.ID
+}
+
+// validateCaps checks that adverts, including those of campaigns, with
+// frequency caps have an ID to count impressions against and that the caps
+// have a count and a valid period.
+func (d *Domain) validateCaps() error {
+	l := d.Adverts[:len(d.Adverts):len(d.Adverts)]
+	for i := range d.Campaigns {
+		l = append(l, d.Campaigns[i].Adverts...)
+	}
+	for _, a := range l {
+		if len(a.Caps) > 0 && a.ID == "" {
+			return fmt.Errorf(
+				"Domain '%s' advert '%s' has caps but no ID",
+				d.Host,
+				a.MediaURL)
+		}
+		for _, f := range a.Caps {
+			if f.Count <= 0 {
+				return fmt.Errorf(
+					"Domain '%s' advert '%s' cap count %d not positive",
+					d.Host,
+					a.ID,
+					f.Count)
+			}
+			if f.Duration() <= 0 {
+				return fmt.Errorf(
+					"Domain '%s' advert '%s' cap period '%s' invalid",
+					d.Host,
+					a.ID,
+					f.Period)
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,185 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OpenRTB loss reason codes used in loss notices.
+const (
+	LossInvalidBid = 3   // The bid could not be used
+	LossBelowFloor = 100 // The bid was below the placement floor
+	LossOutbid     = 102 // Another bid had a higher price
+	LossStopped    = 205 // The advertiser has been stopped by the user
+)
+
+// LossReasons are the descriptions of the loss reason codes.
+var LossReasons = map[int]string{
+	LossInvalidBid: "failed",
+	LossBelowFloor: "below floor",
+	LossOutbid:     "outbid",
+	LossStopped:    "stopped"}
+
+// AuctionStats counts the bids made by a domain and the win and loss notices
+// received for them.
+type AuctionStats struct {
+	mutex  sync.Mutex
+	bids   int
+	wins   int
+	billed int
+	spend  float64     // Sum of the prices of the won bids per impression
+	losses map[int]int // Loss reason code to count
+	paths  map[string]*SupplyPath
+}
+
+// SupplyPath is the wins delivered through a chain of processors.
+type SupplyPath struct {
+	Path  string  // Processors from the publisher to the bidder's caller
+	Wins  int     // Number of won bids delivered through the path
+	Spend float64 // Sum of the prices of the won bids per impression
+}
+
+// CPM returns the average price per thousand impressions of the wins.
+func (p *SupplyPath) CPM() float64 {
+	if p.Wins == 0 {
+		return 0
+	}
+	return p.Spend * 1000 / float64(p.Wins)
+}
+
+// LossCount is the number of losses for a reason.
+type LossCount struct {
+	Code   int    // OpenRTB loss reason code
+	Reason string // Description of the reason
+	Count  int    // Number of losses
+}
+
+// NewAuctionStats creates a new empty set of statistics.
+func NewAuctionStats() *AuctionStats {
+	return &AuctionStats{
+		losses: make(map[int]int),
+		paths:  make(map[string]*SupplyPath)}
+}
+
+// RecordBid counts a bid.
+func (s *AuctionStats) RecordBid() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.bids++
+}
+
+// RecordWin counts a win at the clearing price per thousand impressions for
+// the bid request delivered through the path of processors.
+func (s *AuctionStats) RecordWin(price float64, path []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.wins++
+	s.spend += price / 1000
+	k := strings.Join(path, " > ")
+	p := s.paths[k]
+	if p == nil {
+		p = &SupplyPath{Path: k}
+		s.paths[k] = p
+	}
+	p.Wins++
+	p.Spend += price / 1000
+}
+
+// RecordBilled counts a billing notice.
+func (s *AuctionStats) RecordBilled() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.billed++
+}
+
+// RecordLoss counts a loss for the reason code.
+func (s *AuctionStats) RecordLoss(reason int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.losses[reason]++
+}
+
+// Bids returns the number of bids made.
+func (s *AuctionStats) Bids() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.bids
+}
+
+// Wins returns the number of win notices received.
+func (s *AuctionStats) Wins() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.wins
+}
+
+// Billed returns the number of billing notices received.
+func (s *AuctionStats) Billed() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.billed
+}
+
+// Spend returns the total of the clearing prices of the won bids.
+func (s *AuctionStats) Spend() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.spend
+}
+
+// WinRate returns the percentage of bids that won, or zero if there are no
+// bids.
+func (s *AuctionStats) WinRate() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.bids == 0 {
+		return 0
+	}
+	return float64(s.wins) * 100 / float64(s.bids)
+}
+
+// SupplyPaths returns the wins for each path ordered by the most wins.
+func (s *AuctionStats) SupplyPaths() []SupplyPath {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var l []SupplyPath
+	for _, p := range s.paths {
+		l = append(l, *p)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Wins == l[j].Wins {
+			return l[i].Path < l[j].Path
+		}
+		return l[i].Wins > l[j].Wins
+	})
+	return l
+}
+
+// Losses returns the number of losses for each reason ordered by code.
+func (s *AuctionStats) Losses() []LossCount {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var l []LossCount
+	for c, n := range s.losses {
+		l = append(l, LossCount{c, LossReasons[c], n})
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Code < l[j].Code })
+	return l
+}
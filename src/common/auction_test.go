@@ -0,0 +1,75 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestAuctionStats checks the counts, spend and win rate.
+func TestAuctionStats(t *testing.T) {
+	s := NewAuctionStats()
+	for i := 0; i < 4; i++ {
+		s.RecordBid()
+	}
+	s.RecordWin(2, []string{"pub.com"})
+	s.RecordBilled()
+	s.RecordLoss(LossOutbid)
+	s.RecordLoss(LossOutbid)
+	s.RecordLoss(LossStopped)
+	if s.Bids() != 4 || s.Wins() != 1 || s.Billed() != 1 || s.Spend() != 0.002 {
+		t.Fatalf("unexpected counts %d %d %d %v",
+			s.Bids(),
+			s.Wins(),
+			s.Billed(),
+			s.Spend())
+	}
+	if s.WinRate() != 25 {
+		t.Fatalf("expected a 25%% win rate, got %v", s.WinRate())
+	}
+	l := s.Losses()
+	if len(l) != 2 ||
+		l[0].Code != LossOutbid || l[0].Count != 2 ||
+		l[1].Code != LossStopped || l[1].Count != 1 {
+		t.Fatalf("unexpected losses %+v", l)
+	}
+}
+
+// TestSupplyPaths checks that wins are grouped by the path of processors
+// that delivered the bid request, with the paths with most wins first.
+func TestSupplyPaths(t *testing.T) {
+	s := NewAuctionStats()
+	if len(s.SupplyPaths()) != 0 {
+		t.Fatal("expected no paths")
+	}
+	s.RecordWin(1, []string{"pub.com", "ssp-b.com"})
+	s.RecordWin(2, []string{"pub.com", "ssp-a.com"})
+	s.RecordWin(4, []string{"pub.com", "ssp-b.com"})
+	s.RecordWin(3, []string{"pub.com", "ssp-c.com"})
+	p := s.SupplyPaths()
+	e := []SupplyPath{
+		{"pub.com > ssp-b.com", 2, 0.005},
+		{"pub.com > ssp-a.com", 1, 0.002},
+		{"pub.com > ssp-c.com", 1, 0.003}}
+	if reflect.DeepEqual(p, e) == false {
+		t.Fatalf("expected %+v, got %+v", e, p)
+	}
+	if c := p[0].CPM(); c != 2.5 {
+		t.Fatalf("expected an average CPM of 2.5, got %v", c)
+	}
+}
@@ -0,0 +1,87 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import "fmt"
+
+// Behaviours of bad actors that demo domains can be configured with to show
+// how the SWAN audit catches them.
+const (
+	// Change the publisher domain in the Offer to a more valuable one.
+	BehaviourSpoofPubDomain = "spoof-pub-domain"
+	// Remove the first supplier bid from the tree before the auction.
+	BehaviourDropBid = "drop-bid"
+	// Claim a higher price was received from the winning supplier.
+	BehaviourInflatePrice = "inflate-price"
+	// Add a hop for an intermediary that was not involved.
+	BehaviourFakeHop = "fake-hop"
+	// Replace a supplier's OWID with one signed by this domain.
+	BehaviourForgeSignature = "forge-signature"
+	// Remove the CBID from the Offer before passing it on.
+	BehaviourStripCBID = "strip-cbid"
+	// Call stopped suppliers and bid for stopped advertisers.
+	BehaviourIgnoreStops = "ignore-stops"
+	// Delay the response to the caller.
+	BehaviourSlowResponse = "slow-response"
+)
+
+// behaviours are all the valid behaviour names.
+var behaviours = []string{
+	BehaviourSpoofPubDomain,
+	BehaviourDropBid,
+	BehaviourInflatePrice,
+	BehaviourFakeHop,
+	BehaviourForgeSignature,
+	BehaviourStripCBID,
+	BehaviourIgnoreStops,
+	BehaviourSlowResponse}
+
+// HasBehaviour returns true if the domain is configured with the behaviour.
+func (d *Domain) HasBehaviour(b string) bool {
+	for _, e := range d.Behaviours {
+		if e == b {
+			return true
+		}
+	}
+	return false
+}
+
+// validateBehaviours checks the behaviours are known. Domains marked as Bad
+// spoof the publisher domain.
+func (d *Domain) validateBehaviours() error {
+	for _, e := range d.Behaviours {
+		if isBehaviour(e) == false {
+			return fmt.Errorf(
+				"Behaviour '%s' invalid for domain '%s'",
+				e,
+				d.Host)
+		}
+	}
+	if d.Bad && d.HasBehaviour(BehaviourSpoofPubDomain) == false {
+		d.Behaviours = append(d.Behaviours, BehaviourSpoofPubDomain)
+	}
+	return nil
+}
+
+func isBehaviour(b string) bool {
+	for _, e := range behaviours {
+		if e == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"owid"
+	"swan"
+)
+
+// bidPayloadPrefix starts the payload of a Bid so that it can be told apart
+// from the SWAN payloads in the tree.
+var bidPayloadPrefix = []byte("swan-demo-bid:1:")
+
+// Bid is the payload of the OWID of a processor that bids with its own advert
+// or passes on the bid of a supplier. It carries the SWAN bid fields along with
+// the format of the advert, the prices of the hop, and the OpenRTB price and
+// notice URLs of the processor so that they are all covered by the
+// processor's signature.
+type Bid struct {
+	MediaURL      string `json:"mediaURL"`         // URL of the advert content
+	AdvertiserURL string `json:"advertiserURL"`    // URL to direct the browser to
+	Format        string `json:"format,omitempty"` // Format, banner if not set
+	// Prices of the supplier's bid received and passed on by the processor,
+	// or nil if the bid is the processor's own
+	Hop   *Hop    `json:"hop,omitempty"`
+	Price float64 `json:"price"`          // CPM offered to the parent
+	NURL  string  `json:"nurl,omitempty"` // Win notice URL of the processor
+	BURL  string  `json:"burl,omitempty"` // Billing notice URL
+	LURL  string  `json:"lurl,omitempty"` // Loss notice URL
+}
+
+// FormatOrDefault returns the format of the advert, or banner if none is set.
+func (b *Bid) FormatOrDefault() string {
+	if b.Format == "" {
+		return FormatBanner
+	}
+	return b.Format
+}
+
+// AsByteArray returns the bid as the payload of an OWID.
+func (b *Bid) AsByteArray() ([]byte, error) {
+	j, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, bidPayloadPrefix...), j...), nil
+}
+
+// PayloadFromOWID returns the payload of the OWID as either a Bid or one of
+// the SWAN payload types.
+func PayloadFromOWID(o *owid.OWID) (interface{}, error) {
+	if bytes.HasPrefix(o.Payload, bidPayloadPrefix) {
+		var b Bid
+		err := json.Unmarshal(o.Payload[len(bidPayloadPrefix):], &b)
+		if err != nil {
+			return nil, fmt.Errorf("Bid payload invalid: %s", err.Error())
+		}
+		return &b, nil
+	}
+	return swan.FromOWID(o)
+}
+
+// PayloadFromNode returns the payload of the node's OWID as either a Bid or
+// one of the SWAN payload types.
+func PayloadFromNode(n *owid.Node) (interface{}, error) {
+	o, err := n.GetOWID()
+	if err != nil {
+		return nil, err
+	}
+	return PayloadFromOWID(o)
+}
+
+// WinningBid returns the bid at the end of the winning path of the tree, or
+// nil if there isn't one.
+func WinningBid(n *owid.Node) (*Bid, error) {
+	w, err := swan.WinningNode(n)
+	if err != nil || w == nil {
+		return nil, err
+	}
+	p, err := PayloadFromNode(w)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := p.(*Bid)
+	return b, nil
+}
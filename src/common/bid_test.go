@@ -0,0 +1,99 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"owid"
+	"reflect"
+	"swan"
+	"testing"
+	"time"
+)
+
+// newBidOWID returns an OWID with the payload provided.
+func newBidOWID(p []byte) *owid.OWID {
+	return &owid.OWID{
+		Version: 1,
+		Domain:  "dsp.com",
+		Date:    time.Now().UTC(),
+		Payload: p}
+}
+
+func TestBidPayload(t *testing.T) {
+	b := Bid{
+		MediaURL:      "adv.com/vast/a.xml",
+		AdvertiserURL: "adv.com",
+		Format:        FormatVideo,
+		Hop:           &Hop{Received: 2, Passed: 1.8},
+		Price:         1.8,
+		NURL:          "http://exchange.com/win?id=${AUCTION_ID}",
+		BURL:          "http://exchange.com/bill?id=${AUCTION_ID}",
+		LURL:          "http://exchange.com/loss?id=${AUCTION_ID}"}
+	p, err := b.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := PayloadFromOWID(newBidOWID(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := v.(*Bid)
+	if ok == false || reflect.DeepEqual(*r, b) == false {
+		t.Fatalf("expected %+v, got %+v", b, v)
+	}
+}
+
+func TestBidPayloadDefaults(t *testing.T) {
+	b := Bid{MediaURL: "adv.com/a.png?campaign=1", AdvertiserURL: "adv.com"}
+	p, err := b.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := PayloadFromOWID(newBidOWID(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := v.(*Bid)
+	if r.FormatOrDefault() != FormatBanner || r.Hop != nil {
+		t.Fatalf("expected an own banner bid, got %+v", r)
+	}
+	if r.MediaURL != b.MediaURL {
+		t.Fatalf("media URL changed to '%s'", r.MediaURL)
+	}
+}
+
+func TestBidPayloadInvalid(t *testing.T) {
+	p := append(append([]byte{}, bidPayloadPrefix...), []byte("{")...)
+	if _, err := PayloadFromOWID(newBidOWID(p)); err == nil {
+		t.Fatal("truncated bid should be an error")
+	}
+}
+
+func TestSWANPayload(t *testing.T) {
+	f := swan.Failed{Host: "dsp.com", Error: "timeout"}
+	p, err := f.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := PayloadFromOWID(newBidOWID(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := v.(*swan.Failed); ok == false || r.Host != f.Host {
+		t.Fatalf("expected the failed payload, got %+v", v)
+	}
+}
@@ -0,0 +1,304 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Campaign states returned from Status.
+const (
+	CampaignScheduled = "Scheduled" // The start date has not been reached
+	CampaignActive    = "Active"    // Bids can be made
+	CampaignPaced     = "Paced"     // Spend is ahead of the even pace for today
+	CampaignExhausted = "Exhausted" // The total or daily budget has been spent
+	CampaignEnded     = "Ended"     // The end date has passed
+)
+
+// Consent states used in campaign targeting.
+const (
+	TargetPersonalized = "personalized" // Only when personalized ads allowed
+	TargetContextual   = "contextual"   // Only when personalized ads are not
+)
+
+const campaignDate = "2006-01-02" // Format of the campaign start and end
+
+// reservationTimeout is how long the spend of a bid is held if the bid is not
+// known to have won or lost.
+const reservationTimeout = time.Minute
+
+// Campaign is a set of adverts from an advertiser with a budget and targeting.
+// Campaigns are set in the advertiser's config.json and used by the DSPs that
+// represent the advertiser. Spend is held by the campaign so that all DSPs
+// share the same budget.
+type Campaign struct {
+	Name        string    // Name of the campaign for display
+	CPM         float64   // Price bid per thousand impressions
+	TotalBudget float64   // Maximum spend over the campaign, 0 for no limit
+	DailyBudget float64   // Maximum spend in a UTC day, 0 for no limit
+	Start       string    // First day of the campaign as YYYY-MM-DD if any
+	End         string    // Last day of the campaign as YYYY-MM-DD if any
+	Targeting   Targeting // The impressions the campaign can bid on
+	Adverts     []Advert  // The creatives of the campaign
+	// The clock used for spend and pacing
+	Now      func() time.Time `json:"-"`
+	mutex    sync.Mutex
+	total    float64               // Spend over the campaign
+	days     map[string]float64    // Spend for each day
+	reserved map[*Reservation]bool // Spend held for bids not yet won or lost
+}
+
+// Reservation is the spend held for a bid until the bid wins or loses.
+type Reservation struct {
+	campaign *Campaign
+	amount   float64   // The spend held
+	expires  time.Time // When the spend stops being held
+}
+
+// Targeting restricts the impressions a campaign bids on. Empty fields do not
+// restrict the impressions.
+type Targeting struct {
+	PubDomains  []string // Publisher domains
+	Placements  []string // Placements on the publishers' pages
+	DeviceTypes []string // Device types such as desktop, mobile or tablet
+	Consent     string   // Either personalized or contextual
+}
+
+// init sets the private members of the campaign for the advertiser. Adverts
+// that do not have an advertiser URL use the advertiser's host. Video and
+// native adverts without a media URL use the documents served by the
+// advertiser.
+func (c *Campaign) init(advertiser string) error {
+	for _, s := range []string{c.Start, c.End} {
+		if _, ok := parseCampaignDate(s); s != "" && ok == false {
+			return fmt.Errorf(
+				"Campaign '%s' of '%s' has invalid date '%s'",
+				c.Name,
+				advertiser,
+				s)
+		}
+	}
+	c.Now = time.Now
+	c.days = make(map[string]float64)
+	c.reserved = make(map[*Reservation]bool)
+	for i := range c.Adverts {
+		a := &c.Adverts[i]
+		if a.AdvertiserURL == "" {
+			a.AdvertiserURL = advertiser
+		}
+		switch a.FormatOrDefault() {
+		case FormatVideo:
+			if a.Video == nil || a.ID == "" {
+				return fmt.Errorf(
+					"Video advert in campaign '%s' of '%s' needs ID and Video",
+					c.Name,
+					advertiser)
+			}
+			if a.MediaURL == "" {
+				a.MediaURL = advertiser + "/vast/" + a.ID + ".xml"
+			}
+		case FormatNative:
+			if a.Native == nil || a.ID == "" {
+				return fmt.Errorf(
+					"Native advert in campaign '%s' of '%s' needs ID and Native",
+					c.Name,
+					advertiser)
+			}
+			if a.MediaURL == "" {
+				a.MediaURL = advertiser + "/native/" + a.ID + ".json"
+			}
+		}
+	}
+	return nil
+}
+
+// Price returns the cost of a single impression.
+func (c *Campaign) Price() float64 { return c.CPM / 1000 }
+
+// IsTargeted returns true if the impression matches the campaign's targeting.
+func (c *Campaign) IsTargeted(
+	pubDomain string,
+	placement string,
+	deviceType string,
+	personalized bool) bool {
+	t := &c.Targeting
+	if t.Consent == TargetPersonalized && personalized == false {
+		return false
+	}
+	if t.Consent == TargetContextual && personalized {
+		return false
+	}
+	return targets(t.PubDomains, pubDomain) &&
+		targets(t.Placements, placement) &&
+		targets(t.DeviceTypes, deviceType)
+}
+
+// CanSpend returns true if the campaign is active and a bid at the campaign's
+// price would not exceed the budgets or get ahead of the even pace. Spend held
+// for other bids counts towards the budgets.
+func (c *Campaign) CanSpend() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.status(c.Now().UTC()) == CampaignActive
+}
+
+// Reserve holds the spend of a bid at the campaign's price if the campaign can
+// spend. Returns nil if the budget is no longer available. The reservation
+// must be committed if the bid wins or released if it loses. If neither
+// happens the spend stops being held after reservationTimeout.
+func (c *Campaign) Reserve() *Reservation {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := c.Now().UTC()
+	if c.status(n) != CampaignActive {
+		return nil
+	}
+	r := &Reservation{c, c.Price(), n.Add(reservationTimeout)}
+	c.reserved[r] = true
+	return r
+}
+
+// Commit replaces the spend held with the spend of the impression won at the
+// clearing price per thousand impressions. The impression has been won so the
+// spend is recorded even if the reservation has expired.
+func (r *Reservation) Commit(cpm float64) {
+	c := r.campaign
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.reserved, r)
+	p := cpm / 1000
+	c.total += p
+	c.days[c.Now().UTC().Format(campaignDate)] += p
+}
+
+// Release stops holding the spend of a bid that lost.
+func (r *Reservation) Release() {
+	c := r.campaign
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.reserved, r)
+}
+
+// TotalSpend returns the spend over the campaign.
+func (c *Campaign) TotalSpend() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.total
+}
+
+// TodaySpend returns the spend for the current UTC day.
+func (c *Campaign) TodaySpend() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.days[c.Now().UTC().Format(campaignDate)]
+}
+
+// Status returns the state of the campaign.
+func (c *Campaign) Status() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.status(c.Now().UTC())
+}
+
+func (c *Campaign) status(n time.Time) string {
+	d := n.Truncate(24 * time.Hour)
+	if s, ok := parseCampaignDate(c.Start); ok && d.Before(s) {
+		return CampaignScheduled
+	}
+	if e, ok := parseCampaignDate(c.End); ok && d.After(e) {
+		return CampaignEnded
+	}
+	p := c.Price()
+	h := c.held(n)
+	if c.TotalBudget > 0 && c.total+h+p > c.TotalBudget {
+		return CampaignExhausted
+	}
+	t := c.dailyTarget(d)
+	if t >= 0 {
+		s := c.days[d.Format(campaignDate)] + h
+		if s+p > t {
+			return CampaignExhausted
+		}
+
+		// Spend evenly through the day. If more of the target has been spent
+		// than the proportion of the day that has elapsed then wait.
+		if s > t*float64(n.Sub(d))/float64(24*time.Hour) {
+			return CampaignPaced
+		}
+	}
+	return CampaignActive
+}
+
+// held returns the spend held for bids. Reservations that have expired are
+// removed.
+func (c *Campaign) held(n time.Time) float64 {
+	var h float64
+	for r := range c.reserved {
+		if n.Before(r.expires) {
+			h += r.amount
+		} else {
+			delete(c.reserved, r)
+		}
+	}
+	return h
+}
+
+// dailyTarget returns the amount the campaign should spend on the day, or -1
+// if there is no limit. If the campaign has an end date then the remaining
+// total budget is spread evenly over the remaining days.
+func (c *Campaign) dailyTarget(d time.Time) float64 {
+	t := -1.0
+	if c.TotalBudget > 0 {
+		if e, ok := parseCampaignDate(c.End); ok {
+			r := c.TotalBudget - c.total + c.days[d.Format(campaignDate)]
+			t = r / float64(int(e.Sub(d).Hours()/24)+1)
+		}
+	}
+	if c.DailyBudget > 0 && (t < 0 || c.DailyBudget < t) {
+		t = c.DailyBudget
+	}
+	return t
+}
+
+// parseCampaignDate returns the date and true if the string is a valid date,
+// otherwise false.
+func parseCampaignDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(campaignDate, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// targets returns true if the list is empty or contains the value.
+func targets(l []string, v string) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, i := range l {
+		if strings.EqualFold(i, v) {
+			return true
+		}
+	}
+	return false
+}
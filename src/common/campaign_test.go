@@ -0,0 +1,126 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestCampaign returns the campaign initialised for the advertiser with a
+// clock that the test controls by changing the time returned.
+func newTestCampaign(t *testing.T, c *Campaign) *time.Time {
+	t.Helper()
+	err := c.init("advertiser.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	c.Now = func() time.Time { return n }
+	return &n
+}
+
+func TestCampaignTotalBudgetExhausted(t *testing.T) {
+	c := Campaign{CPM: 100, TotalBudget: 0.25}
+	newTestCampaign(t, &c)
+	for i := 0; i < 2; i++ {
+		if c.CanSpend() == false {
+			t.Fatalf("can't spend after %d impressions", i)
+		}
+		c.Reserve().Commit(c.CPM)
+	}
+	if c.Status() != CampaignExhausted || c.CanSpend() {
+		t.Fatalf("expected exhausted with %v spent, got %s",
+			c.TotalSpend(),
+			c.Status())
+	}
+}
+
+func TestCampaignDailyBudgetExhausted(t *testing.T) {
+	c := Campaign{CPM: 100, DailyBudget: 0.2}
+	n := newTestCampaign(t, &c)
+	*n = n.Add(23 * time.Hour)
+	c.Reserve().Commit(c.CPM)
+	c.Reserve().Commit(c.CPM)
+	if c.Status() != CampaignExhausted {
+		t.Fatalf("expected exhausted, got %s", c.Status())
+	}
+
+	// The daily budget is available again the next day.
+	*n = n.Add(2 * time.Hour)
+	if c.TodaySpend() != 0 || c.Status() != CampaignActive {
+		t.Fatalf("expected active the next day, got %s", c.Status())
+	}
+}
+
+func TestCampaignPacing(t *testing.T) {
+	c := Campaign{CPM: 100, DailyBudget: 1}
+	n := newTestCampaign(t, &c)
+
+	// A quarter of the way through the day a quarter of the budget can be
+	// spent.
+	*n = n.Add(6 * time.Hour)
+	c.Reserve().Commit(c.CPM)
+	c.Reserve().Commit(c.CPM)
+	if c.Status() != CampaignActive {
+		t.Fatalf("expected active, got %s", c.Status())
+	}
+	c.Reserve().Commit(c.CPM)
+	if c.Status() != CampaignPaced || c.CanSpend() {
+		t.Fatalf("expected paced, got %s", c.Status())
+	}
+
+	// Later in the day the campaign catches up with the pace.
+	*n = n.Add(6 * time.Hour)
+	if c.Status() != CampaignActive {
+		t.Fatalf("expected active, got %s", c.Status())
+	}
+}
+
+// TestCampaignReservedSpend checks that spend held for bids counts towards
+// the budget until the bid is released or the reservation expires.
+func TestCampaignReservedSpend(t *testing.T) {
+	c := Campaign{CPM: 100, TotalBudget: 0.25}
+	n := newTestCampaign(t, &c)
+	a := c.Reserve()
+	b := c.Reserve()
+	if a == nil || b == nil {
+		t.Fatal("budget not reserved")
+	}
+	if c.Reserve() != nil || c.CanSpend() {
+		t.Fatal("reserved more than the budget")
+	}
+	a.Release()
+	if c.CanSpend() == false || c.TotalSpend() != 0 {
+		t.Fatal("released spend still held")
+	}
+	*n = n.Add(reservationTimeout)
+	if c.Reserve() == nil || c.Reserve() == nil {
+		t.Fatal("expired reservation still held")
+	}
+}
+
+// TestCampaignCommitClearingPrice checks that a won bid is charged the
+// clearing price rather than the price reserved.
+func TestCampaignCommitClearingPrice(t *testing.T) {
+	c := Campaign{CPM: 100}
+	newTestCampaign(t, &c)
+	c.Reserve().Commit(40)
+	if c.TotalSpend() != 0.04 || c.TodaySpend() != 0.04 {
+		t.Fatalf("expected 0.04 spent, got %v", c.TotalSpend())
+	}
+}
@@ -0,0 +1,194 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Status values for a complaint.
+const (
+	ComplaintOpen         = "Open"
+	ComplaintAcknowledged = "Acknowledged"
+	ComplaintResponded    = "Responded"
+)
+
+// Complaint is a record of a user's complaint against an organization that
+// took part in the supply of an advert.
+type Complaint struct {
+	Ticket   string    // Unique reference returned to the user
+	Created  time.Time // When the complaint was made
+	Updated  time.Time // When the complaint was last changed
+	Accused  string    // Host of the organization complained about
+	CMP      string    // Host of the CMP that recorded the complaint
+	OfferID  string    // Base 64 Offer OWID provided as evidence
+	SWANOWID string    // Base 64 processor OWID provided as evidence
+	Subject  string    // Subject of the complaint
+	Body     string    // Text of the complaint
+	Status   string    // One of the complaint status values
+	Response string    // The accused organization's response if any
+}
+
+// complaintsLimit is the most complaints kept. The oldest complaint is removed
+// when a new one is added to a full store.
+const complaintsLimit = 10000
+
+// ComplaintStore holds the complaints made via the CMPs in the demo. If a file
+// is provided the complaints are also persisted so that they survive a
+// restart.
+type ComplaintStore struct {
+	mutex      sync.Mutex
+	file       string // File to persist the complaints to if any
+	complaints map[string]*Complaint
+}
+
+// NewComplaintStore creates a new store for complaints. If the file is not
+// empty then any complaints already in the file are loaded.
+func NewComplaintStore(file string) *ComplaintStore {
+	s := ComplaintStore{
+		file:       file,
+		complaints: make(map[string]*Complaint)}
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err == nil {
+			json.Unmarshal(b, &s.complaints)
+		}
+	}
+	return &s
+}
+
+// Add stores the complaint setting the ticket, dates and status. If the store
+// is full then the oldest complaint is removed.
+func (s *ComplaintStore) Add(c *Complaint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c.Ticket = uuid.New().String()
+	c.Created = time.Now().UTC()
+	c.Updated = c.Created
+	c.Status = ComplaintOpen
+	if len(s.complaints) >= complaintsLimit {
+		s.removeOldest()
+	}
+	s.complaints[c.Ticket] = c
+	return s.save()
+}
+
+// Get returns a copy of the complaint with the ticket, or nil if there is no
+// such complaint.
+func (s *ComplaintStore) Get(ticket string) *Complaint {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.complaints[ticket]
+	if ok == false {
+		return nil
+	}
+	r := *c
+	return &r
+}
+
+// ForAccused returns copies of the complaints against the host, newest first.
+func (s *ComplaintStore) ForAccused(host string) []*Complaint {
+	var r []*Complaint
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.complaints {
+		if c.Accused == host {
+			i := *c
+			r = append(r, &i)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Created.After(r[j].Created)
+	})
+	return r
+}
+
+// Acknowledge marks the complaint against the host as acknowledged.
+func (s *ComplaintStore) Acknowledge(host string, ticket string) error {
+	return s.update(host, ticket, func(c *Complaint) {
+		if c.Status == ComplaintOpen {
+			c.Status = ComplaintAcknowledged
+		}
+	})
+}
+
+// Respond records the host's response to the complaint.
+func (s *ComplaintStore) Respond(
+	host string,
+	ticket string,
+	response string) error {
+	return s.update(host, ticket, func(c *Complaint) {
+		c.Status = ComplaintResponded
+		c.Response = response
+	})
+}
+
+func (s *ComplaintStore) update(
+	host string,
+	ticket string,
+	fn func(c *Complaint)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.complaints[ticket]
+	if ok == false || c.Accused != host {
+		return fmt.Errorf("Complaint '%s' not found", ticket)
+	}
+	fn(c)
+	c.Updated = time.Now().UTC()
+	return s.save()
+}
+
+// removeOldest removes the complaint created first.
+func (s *ComplaintStore) removeOldest() {
+	var o *Complaint
+	for _, c := range s.complaints {
+		if o == nil || c.Created.Before(o.Created) {
+			o = c
+		}
+	}
+	if o != nil {
+		delete(s.complaints, o.Ticket)
+	}
+}
+
+// save writes the complaints to the file if one was provided. The caller must
+// hold the mutex.
+func (s *ComplaintStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+	b, err := json.Marshal(s.complaints)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and replace the existing one so that a
+	// partial write never corrupts the complaints.
+	t := s.file + ".tmp"
+	err = ioutil.WriteFile(t, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(t, s.file)
+}
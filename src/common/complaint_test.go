@@ -0,0 +1,81 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestComplaintsPersisted checks that complaints and changes to them are
+// loaded by a new store using the same file.
+func TestComplaintsPersisted(t *testing.T) {
+	f, err := ioutil.TempDir("", "complaints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(f) })
+	p := filepath.Join(f, "complaints.json")
+	s := NewComplaintStore(p)
+	c := Complaint{Accused: "dsp.com", Subject: "Unwanted advert"}
+	err = s.Add(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Respond("dsp.com", c.Ticket, "Stopped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewComplaintStore(p).Get(c.Ticket)
+	if r == nil || r.Subject != c.Subject || r.Status != ComplaintResponded {
+		t.Fatalf("complaint not loaded %+v", r)
+	}
+	i, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Mode().Perm() != 0600 {
+		t.Fatalf("file permissions %v", i.Mode().Perm())
+	}
+}
+
+// TestComplaintsLimit checks that the oldest complaint is removed when the
+// store is full.
+func TestComplaintsLimit(t *testing.T) {
+	s := NewComplaintStore("")
+	var f Complaint
+	for i := 0; i <= complaintsLimit; i++ {
+		c := Complaint{Accused: "dsp.com"}
+		err := s.Add(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			f = c
+			s.complaints[c.Ticket].Created = c.Created.Add(-time.Hour)
+		}
+	}
+	if len(s.complaints) != complaintsLimit {
+		t.Fatalf("store has %d complaints", len(s.complaints))
+	}
+	if s.Get(f.Ticket) != nil {
+		t.Fatal("oldest complaint should have been removed")
+	}
+}
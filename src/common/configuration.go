@@ -21,6 +21,14 @@ import (
 	"fmt"
 	"os"
 	"owid"
+	"path/filepath"
+	"strings"
+)
+
+// Encodings of the OWID trees sent to suppliers.
+const (
+	BidEncodingJSON = "json" // JSON with base 64 OWIDs
+	BidEncodingCBOR = "cbor" // CBOR with binary OWIDs
 )
 
 // Configuration maps to the appsettings.json settings file.
@@ -28,8 +36,19 @@ type Configuration struct {
 	AccessKeys []string   `json:"accessKeys"` // Array of valid keys for SWAN access
 	Scheme     string     `json:"scheme"`     // The scheme to use for requests
 	Debug      bool       `json:"debug"`      // True if debug HTML output should be provided
+	DataFolder string     `json:"dataFolder"` // Folder for persisted data if any
 	Domains    []*Domain  // All the domains that form the demo
 	owid       owid.Store // The OWID store for use with domains
+	// Complaints made via the CMPs in the demo
+	complaints *ComplaintStore
+	// Where transaction trees are kept, memory (the default) or file
+	TransactionStore string `json:"transactionStore"`
+	// How long transaction trees are kept, for example 24h (the default)
+	TransactionRetention string `json:"transactionRetention"`
+	// Encoding of OWID trees sent to suppliers, json (the default) or cbor
+	BidEncoding string `json:"bidEncoding"`
+	// How long after creation offers are accepted, for example 10m (default)
+	OfferExpiry string `json:"offerExpiry"`
 }
 
 // NewConfig creates a new instance of configuration from the file provided.
@@ -42,10 +61,95 @@ func NewConfig(settingsFile string) Configuration {
 	}
 	jsonParser := json.NewDecoder(configFile)
 	jsonParser.Decode(&c)
+	err = c.validateBidEncoding()
+	if err != nil {
+		panic(err)
+	}
 	c.owid = getOWIDStore(settingsFile)
+	c.complaints = NewComplaintStore(c.dataFile("complaints.json"))
 	return c
 }
 
+// validateBidEncoding checks the bid encoding is one the processors support.
+func (c *Configuration) validateBidEncoding() error {
+	switch c.BidEncoding {
+	case "", BidEncodingJSON, BidEncodingCBOR:
+		return nil
+	}
+	return fmt.Errorf("Bid encoding '%s' invalid", c.BidEncoding)
+}
+
+// dataFile returns the path of the file with the name provided in the data
+// folder, or an empty string if data is not persisted. The folder is created
+// if it doesn't exist.
+func (c *Configuration) dataFile(name string) string {
+	if c.DataFolder == "" {
+		return ""
+	}
+	err := os.MkdirAll(c.DataFolder, 0700)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	return filepath.Join(c.DataFolder, name)
+}
+
+// Complaints returns the store for complaints made via the CMPs.
+func (c *Configuration) Complaints() *ComplaintStore { return c.complaints }
+
+// FindDomain returns the domain with the host name provided, or nil if the
+// host is not part of the demo.
+func (c *Configuration) FindDomain(host string) *Domain {
+	for _, d := range c.Domains {
+		if strings.EqualFold(d.Host, host) {
+			return d
+		}
+	}
+	return nil
+}
+
+// TCFVendorIDs returns the IAB vendor IDs of all the domains that have one.
+func (c *Configuration) TCFVendorIDs() []int {
+	var v []int
+	for _, d := range c.Domains {
+		if d.TCFVendorID > 0 {
+			v = append(v, d.TCFVendorID)
+		}
+	}
+	return v
+}
+
+// VerifyOWID returns true if the OWID was signed by its creator. If the parent
+// is provided then the signature must also be over the parent OWID. The
+// creator of domains in the demo is the domain's own creator, otherwise the
+// creator's public key is obtained from the OWID store.
+func (c *Configuration) VerifyOWID(o *owid.OWID, parent *owid.OWID) (bool, error) {
+	r, err := c.getCreator(o.Domain)
+	if err != nil {
+		return false, err
+	}
+	if parent == nil {
+		return r.Verify(o)
+	}
+	return r.Verify(o, parent)
+}
+
+// getCreator returns the creator for the domain.
+func (c *Configuration) getCreator(host string) (Creator, error) {
+	if d := c.FindDomain(host); d != nil {
+		return d.GetOWIDCreator()
+	}
+	if c.owid != nil {
+		r, err := c.owid.GetCreator(host)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("Domain '%s' is not a registered OWID creator", host)
+}
+
 func getOWIDStore(settingsFile string) owid.Store {
 	owidConfig := owid.NewConfig(settingsFile)
 	err := owidConfig.Validate()
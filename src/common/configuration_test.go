@@ -0,0 +1,36 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import "testing"
+
+// TestBidEncodingValidated checks that only the encodings the processors
+// support are accepted.
+func TestBidEncodingValidated(t *testing.T) {
+	for _, e := range []string{"", BidEncodingJSON, BidEncodingCBOR} {
+		c := &Configuration{BidEncoding: e}
+		if err := c.validateBidEncoding(); err != nil {
+			t.Fatalf("bid encoding '%s' refused: %s", e, err)
+		}
+	}
+	for _, e := range []string{"CBOR", "xml", "gzip"} {
+		c := &Configuration{BidEncoding: e}
+		if c.validateBidEncoding() == nil {
+			t.Fatalf("bid encoding '%s' accepted", e)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+// VideoCreative is the media of a video advert. The advertiser serves a VAST
+// document for the creative that references the media.
+type VideoCreative struct {
+	MediaFile string // URL of the video file
+	MIMEType  string // MIME type of the video file, video/mp4 if not set
+	Duration  int    // Length of the video in seconds
+}
+
+// NativeCreative contains the assets of a native advert that the publisher
+// renders in the style of its own content. The advertiser serves the assets
+// as JSON.
+type NativeCreative struct {
+	Title string `json:"title"` // Headline of the advert
+	Body  string `json:"body"`  // Description of the advertised product
+	Image string `json:"image"` // URL of the main image
+	CTA   string `json:"cta"`   // Call to action text for the link
+}
+
+// MIMETypeOrDefault returns the MIME type of the video file.
+func (v *VideoCreative) MIMETypeOrDefault() string {
+	if v.MIMEType == "" {
+		return "video/mp4"
+	}
+	return v.MIMEType
+}
@@ -0,0 +1,34 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import "owid"
+
+// Creator creates and signs OWIDs for a domain and verifies the OWIDs the
+// domain created. The creators from the OWID store implement the interface.
+type Creator interface {
+
+	// CreateOWID returns a new unsigned OWID for the domain with the payload.
+	CreateOWID(payload []byte) *owid.OWID
+
+	// Sign signs the OWID, including the other OWIDs in the signature.
+	Sign(o *owid.OWID, others ...*owid.OWID) error
+
+	// Verify returns true if the OWID was signed by the domain, including the
+	// other OWIDs in the signature.
+	Verify(o *owid.OWID, others ...*owid.OWID) (bool, error)
+}
@@ -0,0 +1,49 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Device types used in campaign targeting.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+)
+
+// DeviceType returns the type of device that made the request based on the
+// User-Agent header. Requests without a User-Agent return an empty string.
+func DeviceType(r *http.Request) string {
+	u := strings.ToLower(r.UserAgent())
+	if u == "" {
+		return ""
+	}
+	if strings.Contains(u, "ipad") ||
+		strings.Contains(u, "tablet") ||
+		(strings.Contains(u, "android") && !strings.Contains(u, "mobile")) {
+		return DeviceTablet
+	}
+	if strings.Contains(u, "mobile") ||
+		strings.Contains(u, "iphone") ||
+		strings.Contains(u, "ipod") {
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
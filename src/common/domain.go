@@ -17,6 +17,7 @@
 package common
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -29,14 +30,23 @@ import (
 	"strings"
 	"swan"
 	"swift"
+	"sync"
 )
 
+// sharedTemplates are the templates shared by all the domains in a folder
+// keyed by the folder.
+var sharedTemplates sync.Map
+
+// accessKeyParameter is the query string or form parameter that contains the
+// access key for pages that need one.
+const accessKeyParameter = "accessKey"
+
 // Domain represents the information held in the domain configuration file
 // commonly represented in the demo in config.json.
 type Domain struct {
 	Category            string // Category of the domain
 	Name                string // Common name for the domain
-	Bad                 bool   // True if this domain is a bad actor for the demo
+	Bad                 bool   // True to spoof the publisher domain in the demo
 	Host                string // The host name for the domain
 	SwanMessage         string // Message if used with SWAN
 	SwanBackgroundColor string // Background color if used with SWAN
@@ -45,15 +55,48 @@ type Domain struct {
 	// The domain of the access node used with SWAN (only set for CMPs)
 	SWANAccessNode string
 	SWANAccessKey  string // The access key to use when communicating with SWAN.
+	// IAB TCF CMP ID and version used in TC strings (only set for CMPs)
+	TCFCmpID      int
+	TCFCmpVersion int
+	// Version of the IAB Global Vendor List used by the CMP's dialog
+	TCFVendorListVersion int
+	// ISO 3166-1 country code of the publisher (only set for publishers)
+	TCFPublisherCC string
+	// True if the CMP removes dots and plus suffixes from Gmail addresses
+	NormalizeGmail bool
+	// Contact details and jurisdiction of the organization used for complaints
+	ContactEmail string // Email address for privacy complaints
+	Jurisdiction string // Country or region whose rules the organization follows
+	RegulatorURL string // URL of the data protection regulator
+	// IAB Global Vendor List ID of the domain operator if any
+	TCFVendorID int
+	// Campaigns of the advertiser (only set for advertisers)
+	Campaigns []Campaign
+	// Advertisers whose campaigns the domain bids for (only set for DSPs)
+	Advertisers []string
+	// Placements on the publisher's pages (only set for publishers)
+	Placements []Placement
+	// Fraction of the price of a supplier's bid kept when passing it on
+	TakeRate float64
+	// Bad actor behaviours for the demo (only set for processors)
+	Behaviours []string
 	// The domain of the CMP that will in turn access the SWAN Network via an Operator
 	CMP       string
+	Purposes  []Purpose          // Consent purposes offered (only set for CMPs)
 	Suppliers []string           // Suppliers used by the domain operator
 	Adverts   []Advert           // Adverts the domain can serve
 	Config    *Configuration     // Configuration for the server
 	folder    string             // Location of the directory
 	templates *template.Template // HTML templates
-	OWID      *owid.Creator      // The OWID creator associated with the domain if any
+	OWID      Creator            // The OWID creator associated with the domain if any
 	owidStore owid.Store         // The connection to the OWID store
+	frequency *FrequencyStore    // Impressions per CBID for frequency caps
+	events    *EventLog          // Delivery events recorded by the domain
+	auctions  *AuctionStats      // Bids and notices for the domain's bids
+	// Transaction trees the domain took part in by Offer ID
+	transactions TransactionStore
+	// Offers recently seen used to refuse duplicate and replayed requests
+	seen *SeenOffers
 	// The HTTP handler to use for this domain
 	handler func(d *Domain, w http.ResponseWriter, r *http.Request)
 }
@@ -80,7 +123,39 @@ func NewDomain(c *Configuration, folder string) (*Domain, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = d.validateCaps()
+	if err != nil {
+		return nil, err
+	}
+	err = d.validatePlacements()
+	if err != nil {
+		return nil, err
+	}
+	err = d.validateTakeRate()
+	if err != nil {
+		return nil, err
+	}
+	err = d.validateBehaviours()
+	if err != nil {
+		return nil, err
+	}
 	d.owidStore = c.owid
+	for i := range d.Campaigns {
+		err = d.Campaigns[i].init(d.Host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.transactions, err = d.newTransactionStore()
+	if err != nil {
+		return nil, err
+	}
+	d.seen = NewSeenOffers(c.OfferWindow())
+	d.events = NewEventLog()
+	d.auctions = NewAuctionStats()
+	d.frequency = NewFrequencyStore(
+		maxCapPeriod(d.Adverts),
+		d.dataFile("frequency.json"))
 
 	return &d, nil
 }
@@ -94,14 +169,20 @@ func (d *Domain) SetHandler(fn func(
 }
 
 func (d *Domain) parseHTML() (*template.Template, error) {
+	return parseHTMLFolder(d.folder)
+}
+
+// parseHTMLFolder returns the templates in the folder, or nil if there are
+// none.
+func parseHTMLFolder(folder string) (*template.Template, error) {
 	var t *template.Template
-	files, err := ioutil.ReadDir(d.folder)
+	files, err := ioutil.ReadDir(folder)
 	if err != nil {
 		return nil, err
 	}
 	for _, file := range files {
 		if filepath.Ext(file.Name()) == ".html" {
-			s, err := ioutil.ReadFile(filepath.Join(d.folder, file.Name()))
+			s, err := ioutil.ReadFile(filepath.Join(folder, file.Name()))
 			if err != nil {
 				return nil, err
 			}
@@ -148,6 +229,33 @@ func (d *Domain) LookupHTML(p string) *template.Template {
 	return t
 }
 
+// LookupSharedHTML returns the template with the name from the domain's
+// folder if it has one, otherwise the template from the parent folder that is
+// shared by all the domains. Shared templates are only used by the handlers
+// that own them so they are never served as the pages of a domain.
+func (d *Domain) LookupSharedHTML(name string) (*template.Template, error) {
+	if d.templates != nil {
+		if t := d.templates.Lookup(name); t != nil {
+			return t, nil
+		}
+	}
+	f := filepath.Dir(d.folder)
+	v, ok := sharedTemplates.Load(f)
+	if ok == false {
+		t, err := parseHTMLFolder(f)
+		if err != nil {
+			return nil, err
+		}
+		v, _ = sharedTemplates.LoadOrStore(f, t)
+	}
+	if t, ok := v.(*template.Template); ok && t != nil {
+		if s := t.Lookup(name); s != nil {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("Template '%s' not found for '%s'", name, d.Host)
+}
+
 func (d *Domain) setCommon(r *http.Request, q *url.Values) {
 
 	// Set the access key
@@ -244,29 +352,128 @@ func (d *Domain) CreateSWANURL(
 	return string(b), nil
 }
 
+// AdvertiserCampaigns returns the campaigns of the advertisers the domain bids
+// for. Advertisers that are not part of the demo are ignored.
+func (d *Domain) AdvertiserCampaigns() []*Campaign {
+	var c []*Campaign
+	for _, h := range d.Advertisers {
+		a := d.Config.FindDomain(h)
+		if a != nil {
+			for i := range a.Campaigns {
+				c = append(c, &a.Campaigns[i])
+			}
+		}
+	}
+	return c
+}
+
+// Creative returns the advert from the domain's campaigns with the ID, or nil
+// if there is no such advert.
+func (d *Domain) Creative(id string) *Advert {
+	for i := range d.Campaigns {
+		for j := range d.Campaigns[i].Adverts {
+			if d.Campaigns[i].Adverts[j].ID == id {
+				return &d.Campaigns[i].Adverts[j]
+			}
+		}
+	}
+	return nil
+}
+
+// Placement returns the placement with the ID, or nil if the domain does not
+// have the placement.
+func (d *Domain) Placement(id string) *Placement {
+	for i := range d.Placements {
+		if d.Placements[i].ID == id {
+			return &d.Placements[i]
+		}
+	}
+	return nil
+}
+
+// Auctions returns the statistics for the bids made by the domain.
+func (d *Domain) Auctions() *AuctionStats { return d.auctions }
+
+// Events returns the delivery events recorded by the domain.
+func (d *Domain) Events() *EventLog { return d.events }
+
+// Seen returns the offers the domain has recently been asked to take part in.
+func (d *Domain) Seen() *SeenOffers { return d.seen }
+
+// Transactions returns the store of the transaction trees the domain took
+// part in.
+func (d *Domain) Transactions() TransactionStore { return d.transactions }
+
+// SetTransactionStore replaces the store used for transaction trees.
+func (d *Domain) SetTransactionStore(s TransactionStore) {
+	d.transactions = s
+}
+
+// Frequency returns the impressions per CBID used for frequency caps.
+func (d *Domain) Frequency() *FrequencyStore { return d.frequency }
+
+// dataFile returns the path of the file with the name provided in the data
+// folder for the domain, or an empty string if data is not persisted.
+func (d *Domain) dataFile(name string) string {
+	return d.Config.dataFile(d.Host + "-" + name)
+}
+
+// Contact returns the email address for complaints to the organization.
+func (d *Domain) Contact() string {
+	if d.ContactEmail != "" {
+		return d.ContactEmail
+	}
+	return "info@" + d.Host
+}
+
+// ConsentPurposes returns the purposes the user can allow or deny when using
+// the CMP dialog for the domain.
+func (d *Domain) ConsentPurposes() []Purpose {
+	if len(d.Purposes) > 0 {
+		return d.Purposes
+	}
+	return DefaultPurposes
+}
+
+// IsAccessAllowed returns true if the request includes one of the access keys
+// from the configuration. Used for pages that only the operator of the domain
+// should be able to use.
+func (d *Domain) IsAccessAllowed(r *http.Request) bool {
+	k := r.FormValue(accessKeyParameter)
+	if k == "" {
+		return false
+	}
+	for _, a := range d.Config.AccessKeys {
+		if subtle.ConstantTimeCompare([]byte(a), []byte(k)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
 // GetOWIDCreator returns the OWID creator from the OWID store for the the
 // domain.
-func (d *Domain) GetOWIDCreator() (*owid.Creator, error) {
-	var err error
+func (d *Domain) GetOWIDCreator() (Creator, error) {
 	if d.OWID == nil {
-		d.OWID, err = d.owidStore.GetCreator(d.Host)
+		c, err := d.owidStore.GetCreator(d.Host)
 		if err != nil {
 			return nil, err
 		}
-		if d.OWID == nil {
+		if c == nil {
 			return nil, fmt.Errorf(
 				"Domain '%s' is not a registered OWID creator. Register the "+
 					"domain for the SWAN demo using http[s]://%s/owid/register",
 				d.Host,
 				d.Host)
 		}
+		d.OWID = c
 	}
 	return d.OWID, nil
 }
 
 func infoRole(s interface{}) string {
 	_, fok := s.(*swan.Failed)
-	_, bok := s.(*swan.Bid)
+	_, bok := s.(*Bid)
 	_, eok := s.(*swan.Empty)
 	_, ook := s.(*swan.Offer)
 	if fok {
@@ -0,0 +1,109 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDomain creates the domain for the host from the JSON configuration
+// in a temporary folder. The shared templates named are copied from the www
+// folder to the parent of the domain's folder.
+func newTestDomain(
+	t *testing.T,
+	c *Configuration,
+	host string,
+	config string,
+	shared ...string) *Domain {
+	t.Helper()
+	p, err := ioutil.TempDir("", "common")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(p) })
+	for _, s := range shared {
+		b, err := ioutil.ReadFile(filepath.Join("..", "..", "www", s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(p, s), b)
+	}
+	f := filepath.Join(p, host)
+	err = os.Mkdir(f, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == "" {
+		config = "{}"
+	}
+	writeTestFile(t, filepath.Join(f, "config.json"), []byte(config))
+	d, err := NewDomain(c, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Domains = append(c.Domains, d)
+	return d
+}
+
+func writeTestFile(t *testing.T, f string, b []byte) {
+	t.Helper()
+	err := ioutil.WriteFile(f, b, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSharedTemplates checks that the templates in the parent folder are
+// available to the handlers that own them unless the domain has its own, and
+// are not served as pages of the domain.
+func TestSharedTemplates(t *testing.T) {
+	d := newTestDomain(t, &Configuration{}, "pub.com", "", "complaints.html")
+	s, err := d.LookupSharedHTML("complaints.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name() != "complaints.html" {
+		t.Fatal("shared template not found")
+	}
+	if d.LookupHTML("/complaints.html") != nil {
+		t.Fatal("shared template should not be a page of the domain")
+	}
+	if _, err = d.LookupSharedHTML("missing.html"); err == nil {
+		t.Fatal("missing template should be an error")
+	}
+	writeTestFile(t, filepath.Join(d.folder, "complaints.html"), []byte("own"))
+	d.templates, err = d.parseHTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = d.LookupSharedHTML("complaints.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	err = s.Execute(w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "own" {
+		t.Fatalf("expected the domain's own template, got '%s'", w.Body)
+	}
+}
@@ -0,0 +1,77 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// Types of delivery event recorded against an offer.
+const (
+	EventImpression = "impression" // The advert was rendered
+	EventViewable   = "viewable"   // At least 50% was visible for a second
+	EventClick      = "click"      // The advert was selected
+)
+
+// eventLogOffers is the number of offers each domain keeps events for.
+const eventLogOffers = 10000
+
+// Event is a delivery event recorded by a domain. The OWID is created by the
+// recording domain with the event type as the payload and is signed over the
+// Offer ID so the event can be verified as relating to the offer.
+type Event struct {
+	Type    string    // One of the event types
+	Host    string    // Host of the domain that recorded the event
+	OfferID string    // Base 64 Offer OWID the event relates to
+	OWID    string    // Base 64 OWID signed by the recording domain
+	Date    time.Time // When the event was recorded
+}
+
+// EventLog holds the events recorded by a domain for the most recent offers.
+type EventLog struct {
+	mutex  sync.Mutex
+	events map[string][]*Event // Offer ID to the events for the offer
+	order  []string            // Offer IDs in the order first recorded
+}
+
+// NewEventLog creates a new empty event log.
+func NewEventLog() *EventLog {
+	return &EventLog{events: make(map[string][]*Event)}
+}
+
+// Add records the event. If the log is full the events of the oldest offer
+// are removed.
+func (l *EventLog) Add(e *Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.events[e.OfferID]; ok == false {
+		if len(l.order) >= eventLogOffers {
+			delete(l.events, l.order[0])
+			l.order = l.order[1:]
+		}
+		l.order = append(l.order, e.OfferID)
+	}
+	l.events[e.OfferID] = append(l.events[e.OfferID], e)
+}
+
+// ForOffer returns the events recorded for the Offer ID.
+func (l *EventLog) ForOffer(offerID string) []*Event {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]*Event(nil), l.events[offerID]...)
+}
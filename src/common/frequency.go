@@ -0,0 +1,190 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// FrequencyCap limits the number of times an advert is shown to the same
+// CBID within a period.
+type FrequencyCap struct {
+	Count  int    // Maximum number of impressions in the period
+	Period string // Duration of the period, for example 1h or 24h
+}
+
+// Duration returns the period of the cap, or zero if the period is invalid.
+func (f *FrequencyCap) Duration() time.Duration {
+	d, err := time.ParseDuration(f.Period)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// frequencySaveDelay is how long changes to the counts are batched before
+// they are written to the file.
+const frequencySaveDelay = time.Second
+
+// FrequencyStore counts impressions of adverts per CBID. Counts are held in
+// memory and expire after the TTL. If a file is provided the counts are also
+// persisted so that they survive a restart. Writes to the file are batched so
+// that a busy store writes at most once every frequencySaveDelay.
+type FrequencyStore struct {
+	Now     func() time.Time // The clock used for impressions and expiry
+	ttl     time.Duration    // How long impressions are retained
+	file    string           // File to persist the counts to if any
+	mutex   sync.Mutex
+	counts  map[string]map[string][]time.Time // CBID to advert key impressions
+	pruned  time.Time                         // When expired counts were removed
+	pending bool                              // True if a save is scheduled
+}
+
+// NewFrequencyStore creates a store that retains impressions for the TTL. If
+// the file is not empty then any counts already in the file are loaded.
+func NewFrequencyStore(ttl time.Duration, file string) *FrequencyStore {
+	s := FrequencyStore{
+		Now:    time.Now,
+		ttl:    ttl,
+		file:   file,
+		counts: make(map[string]map[string][]time.Time)}
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err == nil {
+			json.Unmarshal(b, &s.counts)
+		}
+	}
+	return &s
+}
+
+// IsCapped returns true if the advert has reached any of its caps for the
+// CBID.
+func (s *FrequencyStore) IsCapped(cbid string, a *Advert) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i := s.impressions(cbid, a)
+	n := s.Now()
+	for _, f := range a.Caps {
+		c := 0
+		for _, t := range i {
+			if n.Sub(t) < f.Duration() {
+				c++
+			}
+		}
+		if c >= f.Count {
+			return true
+		}
+	}
+	return false
+}
+
+// Record adds an impression of the advert for the CBID. Impressions that have
+// expired for any CBID are removed at most once per TTL, and the counts are
+// written to the file after frequencySaveDelay.
+func (s *FrequencyStore) Record(cbid string, a *Advert) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if p := maxCapPeriod([]Advert{*a}); p > s.ttl {
+		s.ttl = p
+	}
+	n := s.Now()
+	if n.Sub(s.pruned) >= s.ttl {
+		s.prune(n)
+	}
+	if s.counts[cbid] == nil {
+		s.counts[cbid] = make(map[string][]time.Time)
+	}
+	s.counts[cbid][a.frequencyKey()] = append(s.impressions(cbid, a), n)
+	if s.file != "" && s.pending == false {
+		s.pending = true
+		time.AfterFunc(frequencySaveDelay, func() {
+			err := s.Flush()
+			if err != nil {
+				fmt.Println(err.Error())
+			}
+		})
+	}
+	return nil
+}
+
+// Flush writes the counts to the file if one was provided.
+func (s *FrequencyStore) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending = false
+	if s.file == "" {
+		return nil
+	}
+	b, err := json.Marshal(s.counts)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and replace the existing one so that a
+	// partial write never corrupts the counts and the permissions of the
+	// file are always 0600.
+	t := s.file + ".tmp"
+	err = ioutil.WriteFile(t, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(t, s.file)
+}
+
+// impressions returns the impressions of the advert for the CBID that have
+// not expired.
+func (s *FrequencyStore) impressions(cbid string, a *Advert) []time.Time {
+	var r []time.Time
+	c := s.counts[cbid]
+	if c == nil {
+		return nil
+	}
+	n := s.Now()
+	for _, t := range c[a.frequencyKey()] {
+		if n.Sub(t) < s.ttl {
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+// prune removes the impressions older than the TTL for all CBIDs.
+func (s *FrequencyStore) prune(n time.Time) {
+	for cbid, c := range s.counts {
+		for k, i := range c {
+			var r []time.Time
+			for _, t := range i {
+				if n.Sub(t) < s.ttl {
+					r = append(r, t)
+				}
+			}
+			if len(r) > 0 {
+				c[k] = r
+			} else {
+				delete(c, k)
+			}
+		}
+		if len(c) == 0 {
+			delete(s.counts, cbid)
+		}
+	}
+	s.pruned = n
+}
@@ -0,0 +1,168 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestFrequency returns a store with a clock that the test controls by
+// changing the time returned.
+func newTestFrequency(file string) (*FrequencyStore, *time.Time) {
+	n := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewFrequencyStore(time.Hour, file)
+	s.Now = func() time.Time { return n }
+	return s, &n
+}
+
+func TestFrequencyWindowExpiry(t *testing.T) {
+	s, n := newTestFrequency("")
+	a := &Advert{
+		ID:       "a",
+		MediaURL: "https://cdn.example/a.png",
+		Caps:     []FrequencyCap{{Count: 2, Period: "1h"}}}
+	for i := 0; i < 2; i++ {
+		if s.IsCapped("cbid", a) {
+			t.Fatalf("capped after %d impressions", i)
+		}
+		s.Record("cbid", a)
+		*n = n.Add(10 * time.Minute)
+	}
+	if s.IsCapped("cbid", a) == false {
+		t.Fatal("not capped after 2 impressions in the window")
+	}
+	if s.IsCapped("other", a) {
+		t.Fatal("cap applied to another CBID")
+	}
+
+	// The first impression leaves the window after an hour.
+	*n = n.Add(40 * time.Minute)
+	if s.IsCapped("cbid", a) {
+		t.Fatal("capped after the first impression expired")
+	}
+}
+
+func TestFrequencyPrunesOnWrite(t *testing.T) {
+	s, n := newTestFrequency("")
+	a := &Advert{
+		ID:       "a",
+		MediaURL: "https://cdn.example/a.png",
+		Caps:     []FrequencyCap{{Count: 1, Period: "1h"}}}
+	s.Record("old", a)
+	*n = n.Add(2 * time.Hour)
+	s.Record("new", a)
+	if _, ok := s.counts["old"]; ok {
+		t.Fatal("expired impressions of another CBID not removed")
+	}
+	if len(s.counts["new"][a.frequencyKey()]) != 1 {
+		t.Fatal("impression not recorded")
+	}
+}
+
+func TestFrequencyCampaignCapExtendsTTL(t *testing.T) {
+	s, n := newTestFrequency("")
+	a := &Advert{
+		ID:       "a",
+		MediaURL: "https://cdn.example/a.png",
+		Caps:     []FrequencyCap{{Count: 1, Period: "24h"}}}
+	s.Record("cbid", a)
+	*n = n.Add(12 * time.Hour)
+	s.Record("other", a)
+	if s.IsCapped("cbid", a) == false {
+		t.Fatal("impression removed before the advert's cap period")
+	}
+}
+
+func TestFrequencyFlush(t *testing.T) {
+	p, err := ioutil.TempDir("", "frequency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+	f := filepath.Join(p, "frequency.json")
+	s, _ := newTestFrequency(f)
+	a := &Advert{
+		ID:       "a",
+		MediaURL: "https://cdn.example/a.png",
+		Caps:     []FrequencyCap{{Count: 1, Period: "1h"}}}
+	s.Record("cbid", a)
+	if _, err := os.Stat(f); os.IsNotExist(err) == false {
+		t.Fatal("counts written before the save delay")
+	}
+	err = s.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Mode().Perm() != 0600 {
+		t.Fatalf("file mode %v", i.Mode().Perm())
+	}
+	r, _ := newTestFrequency(f)
+	if r.IsCapped("cbid", a) == false {
+		t.Fatal("counts not loaded from the file")
+	}
+}
+
+// TestFrequencyKeyedByAdvert checks that impressions count against the advert
+// even if its media URL changes.
+func TestFrequencyKeyedByAdvert(t *testing.T) {
+	s, _ := newTestFrequency("")
+	a := &Advert{
+		ID:            "a",
+		AdvertiserURL: "cool-bikes.uk",
+		MediaURL:      "https://cdn.example/a.png",
+		Caps:          []FrequencyCap{{Count: 1, Period: "1h"}}}
+	s.Record("cbid", a)
+	b := *a
+	b.MediaURL = "https://cdn.example/a.png?format=banner"
+	if s.IsCapped("cbid", &b) == false {
+		t.Fatal("cap not applied when the media URL changed")
+	}
+	c := *a
+	c.ID = "c"
+	if s.IsCapped("cbid", &c) {
+		t.Fatal("cap applied to another advert")
+	}
+}
+
+// TestValidateCaps checks that invalid caps are refused at config load.
+func TestValidateCaps(t *testing.T) {
+	valid := Advert{ID: "a", Caps: []FrequencyCap{{Count: 1, Period: "1h"}}}
+	d := &Domain{Host: "dsp.com", Adverts: []Advert{valid, {}}}
+	if err := d.validateCaps(); err != nil {
+		t.Fatalf("valid caps refused: %s", err)
+	}
+	for _, a := range []Advert{
+		{Caps: []FrequencyCap{{Count: 1, Period: "1h"}}},
+		{ID: "a", Caps: []FrequencyCap{{Count: 0, Period: "1h"}}},
+		{ID: "a", Caps: []FrequencyCap{{Count: -1, Period: "1h"}}},
+		{ID: "a", Caps: []FrequencyCap{{Count: 1, Period: "hour"}}},
+		{ID: "a", Caps: []FrequencyCap{{Count: 1, Period: ""}}},
+		{ID: "a", Caps: []FrequencyCap{{Count: 1, Period: "-1h"}}}} {
+		d := &Domain{Host: "dsp.com", Adverts: []Advert{a}}
+		if d.validateCaps() == nil {
+			t.Fatalf("caps %v accepted", a)
+		}
+	}
+}
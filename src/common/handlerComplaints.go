@@ -0,0 +1,91 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// complaintsModel is used with the complaints inbox template.
+type complaintsModel struct {
+	Domain     *Domain
+	Complaints []*Complaint
+	AccessKey  string // Included in the forms to acknowledge and respond
+}
+
+// handlerComplaints displays the complaints made against the domain and
+// records acknowledgements and responses. Only requests with a valid access
+// key can see the complaints or change them.
+func handlerComplaints(d *Domain, w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		ReturnServerError(d.Config, w, err)
+		return
+	}
+	if d.IsAccessAllowed(r) == false {
+		ReturnStatusCodeError(
+			d.Config,
+			w,
+			fmt.Errorf("Access key missing or invalid"),
+			http.StatusUnauthorized)
+		return
+	}
+
+	// If the method is POST then update the complaint and redirect back to
+	// the inbox.
+	if r.Method == "POST" {
+		s := d.Config.Complaints()
+		switch r.Form.Get("action") {
+		case "acknowledge":
+			err = s.Acknowledge(d.Host, r.Form.Get("ticket"))
+		case "respond":
+			err = s.Respond(d.Host, r.Form.Get("ticket"), r.Form.Get("response"))
+		default:
+			err = fmt.Errorf("Action '%s' invalid", r.Form.Get("action"))
+		}
+		if err != nil {
+			ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+			return
+		}
+		q := url.Values{}
+		q.Set(accessKeyParameter, r.Form.Get(accessKeyParameter))
+		http.Redirect(w, r, r.URL.Path+"?"+q.Encode(), 303)
+		return
+	}
+
+	t, err := d.LookupSharedHTML("complaints.html")
+	if err != nil {
+		ReturnServerError(d.Config, w, err)
+		return
+	}
+	var m complaintsModel
+	m.Domain = d
+	m.Complaints = d.Config.Complaints().ForAccused(d.Host)
+	m.AccessKey = r.Form.Get(accessKeyParameter)
+	g := gzip.NewWriter(w)
+	defer g.Close()
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	err = t.Execute(g, &m)
+	if err != nil {
+		ReturnServerError(d.Config, w, err)
+	}
+}
@@ -0,0 +1,116 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// complaintsRequest returns the response to the complaints inbox for the
+// form values provided. If the values include an action then the request is
+// a POST.
+func complaintsRequest(d *Domain, f url.Values) *httptest.ResponseRecorder {
+	var r *http.Request
+	if f.Get("action") != "" {
+		r = httptest.NewRequest(
+			"POST",
+			"/complaints",
+			strings.NewReader(f.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest("GET", "/complaints?"+f.Encode(), nil)
+	}
+	w := httptest.NewRecorder()
+	handlerComplaints(d, w, r)
+	return w
+}
+
+func TestComplaintsAccess(t *testing.T) {
+	c := &Configuration{AccessKeys: []string{"key1"}, complaints: NewComplaintStore("")}
+	d := newTestDomain(t, c, "dsp.com", "", "complaints.html")
+	p := Complaint{Accused: d.Host, Subject: "Unwanted advert"}
+	c.Complaints().Add(&p)
+	for _, v := range []url.Values{
+		{},
+		{"accessKey": {"wrong"}},
+		{"action": {"acknowledge"}, "ticket": {p.Ticket}},
+		{"action": {"respond"}, "ticket": {p.Ticket}, "response": {"No"}},
+		{"action": {"respond"}, "ticket": {p.Ticket}, "accessKey": {"key2"}},
+	} {
+		w := complaintsRequest(d, v)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%v returned status %d", v, w.Code)
+		}
+		if strings.Contains(w.Body.String(), p.Subject) {
+			t.Errorf("%v returned the complaint", v)
+		}
+	}
+	if c.Complaints().Get(p.Ticket).Status != ComplaintOpen {
+		t.Fatal("complaint changed without an access key")
+	}
+}
+
+func TestComplaintsInbox(t *testing.T) {
+	c := &Configuration{AccessKeys: []string{"key1"}, complaints: NewComplaintStore("")}
+	d := newTestDomain(t, c, "dsp.com", "", "complaints.html")
+	p := Complaint{Accused: d.Host, Subject: "Unwanted advert"}
+	c.Complaints().Add(&p)
+
+	w := complaintsRequest(d, url.Values{"accessKey": {"key1"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	g, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), p.Subject) == false ||
+		strings.Contains(string(b), `name="accessKey" value="key1"`) == false {
+		t.Fatalf("inbox missing complaint or access key\n%s", b)
+	}
+
+	w = complaintsRequest(d, url.Values{
+		"action":    {"acknowledge"},
+		"ticket":    {p.Ticket},
+		"accessKey": {"key1"}})
+	if w.Code != http.StatusSeeOther ||
+		w.Header().Get("Location") != "/complaints?accessKey=key1" {
+		t.Fatalf("status %d location '%s'", w.Code, w.Header().Get("Location"))
+	}
+	if c.Complaints().Get(p.Ticket).Status != ComplaintAcknowledged {
+		t.Fatal("complaint not acknowledged")
+	}
+	complaintsRequest(d, url.Values{
+		"action":    {"respond"},
+		"ticket":    {p.Ticket},
+		"response":  {"Stopped"},
+		"accessKey": {"key1"}})
+	if r := c.Complaints().Get(p.Ticket); r.Status != ComplaintResponded ||
+		r.Response != "Stopped" {
+		t.Fatalf("complaint not responded to %+v", r)
+	}
+}
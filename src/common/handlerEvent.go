@@ -0,0 +1,165 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"owid"
+	"path"
+	"swan"
+	"time"
+)
+
+// transparentGIF is returned for impression and viewable beacons.
+var transparentGIF, _ = base64.StdEncoding.DecodeString(
+	"R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7")
+
+// handlerEvent records a delivery event for an offer. Impression and viewable
+// beacons provide the Offer ID in the query string and are returned a
+// transparent image. Clicks are form POSTs containing the transaction reference
+// which are redirected from the publisher, to the winning DSP, and then to the
+// advertiser. Each domain works out the next step from the transaction so the
+// redirect can't be used to send the browser elsewhere.
+func handlerEvent(d *Domain, w http.ResponseWriter, r *http.Request) {
+	t := path.Base(r.URL.Path)
+	switch t {
+	case EventImpression, EventViewable:
+		o, err := owid.FromBase64(r.URL.Query().Get("offer"))
+		if err != nil {
+			ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+			return
+		}
+		err = d.recordEvent(t, o)
+		if err != nil {
+			ReturnServerError(d.Config, w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(transparentGIF)
+	case EventClick:
+		n, err := transactionFromForm(d.Config, r)
+		if err != nil {
+			ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+			return
+		}
+		o, err := n.GetRoot().GetOWID()
+		if err != nil {
+			ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+			return
+		}
+		err = d.recordEvent(t, o)
+		if err != nil {
+			ReturnServerError(d.Config, w, err)
+			return
+		}
+		u, err := d.nextClick(n)
+		if err != nil {
+			ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+			return
+		}
+
+		// Use a temporary redirect so that the browser POSTs the transaction
+		// to the next step.
+		http.Redirect(w, r, u, http.StatusTemporaryRedirect)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// recordEvent creates an OWID for the event signed over the offer and adds it
+// to the domain's event log.
+func (d *Domain) recordEvent(t string, o *owid.OWID) error {
+	_, err := d.GetOWIDCreator()
+	if err != nil {
+		return err
+	}
+	e := d.OWID.CreateOWID([]byte(t))
+	if e == nil {
+		return fmt.Errorf("Could not create new OWID")
+	}
+	err = d.OWID.Sign(e, o)
+	if err != nil {
+		return err
+	}
+	d.events.Add(&Event{
+		Type:    t,
+		Host:    d.Host,
+		OfferID: o.AsString(),
+		OWID:    e.AsString(),
+		Date:    time.Now().UTC()})
+	return nil
+}
+
+// nextClick returns the URL for the next step of a click. The winning DSP
+// sends the browser to the advertiser, and any other domain sends it to the
+// winning DSP.
+func (d *Domain) nextClick(n *owid.Node) (string, error) {
+	w, err := swan.WinningNode(n)
+	if err != nil {
+		return "", err
+	}
+	b, err := WinningBid(n)
+	if err != nil {
+		return "", err
+	}
+	if w == nil || b == nil {
+		return "", fmt.Errorf("Transaction has no winning bid")
+	}
+	o, err := w.GetOWID()
+	if err != nil {
+		return "", err
+	}
+	if o.Domain == d.Host {
+		u, err := url.Parse(d.Config.Scheme + "://" + b.AdvertiserURL)
+		if err != nil {
+			return "", err
+		}
+		return u.String(), nil
+	}
+	var u url.URL
+	u.Scheme = d.Config.Scheme
+	u.Host = o.Domain
+	u.Path = "/event/" + EventClick
+	return u.String(), nil
+}
+
+// transactionFromForm returns the OWID tree for the reference form field, or
+// in the transaction form field if there is no reference.
+func transactionFromForm(
+	c *Configuration,
+	r *http.Request) (*owid.Node, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	if r.Form.Get("reference") != "" {
+		f, err := ParseTransactionRef(c, r.Form.Get("reference"))
+		if err != nil {
+			return nil, err
+		}
+		return f.Resolve(c)
+	}
+	b, err := base64.StdEncoding.DecodeString(r.Form.Get("transaction"))
+	if err != nil {
+		return nil, err
+	}
+	return owid.NodeFromJSON(b)
+}
@@ -0,0 +1,198 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common_test
+
+import (
+	"common"
+	"demotest"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"openrtb"
+	"owid"
+	"strings"
+	"swan"
+	"testing"
+)
+
+// newEventNetwork returns a configuration with a publisher whose supplier is
+// a DSP bidding for an advertiser.
+func newEventNetwork(t *testing.T) *common.Configuration {
+	c := demotest.NewConfig()
+	demotest.NewDomain(t, c, "pub.com", `{"suppliers": ["dsp.com"]}`).
+		SetHandler(openrtb.Handler)
+	demotest.NewDomain(t, c, "dsp.com", `{"adverts": [{
+		"advertiserURL": "adv.com",
+		"mediaURL": "adv.com/a.png",
+		"cpm": 1}]}`).SetHandler(openrtb.Handler)
+	demotest.Serve(t, c)
+	return c
+}
+
+// newEventTransaction runs the transaction for a new offer from the publisher
+// and returns the tree.
+func newEventTransaction(t *testing.T, c *common.Configuration) *owid.Node {
+	t.Helper()
+	p := c.FindDomain("pub.com")
+	o := swan.Offer{PubDomain: p.Host, UUID: []byte("uuid")}
+	b, err := o.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := p.OWID.CreateOWID(b)
+	err = p.OWID.Sign(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n owid.Node
+	n.OWID, err = r.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = openrtb.HandleTransaction(p, &n, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &n
+}
+
+// serveEvent sends the request to the domains of the configuration.
+func serveEvent(c *common.Configuration, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	common.Handler(c.Domains)(w, r)
+	return w
+}
+
+// checkEvent checks that the domain recorded one event of the type for the
+// offer, signed by the domain over the offer.
+func checkEvent(
+	t *testing.T,
+	c *common.Configuration,
+	host string,
+	eventType string,
+	offer *owid.OWID) {
+	t.Helper()
+	e := c.FindDomain(host).Events().ForOffer(offer.AsString())
+	if len(e) != 1 || e[0].Type != eventType || e[0].Host != host {
+		t.Fatalf("expected one %s event from '%s', got %v", eventType, host, e)
+	}
+	o, err := owid.FromBase64(e[0].OWID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Domain != host || string(o.Payload) != eventType {
+		t.Fatalf("event OWID from '%s' for '%s'", o.Domain, o.Payload)
+	}
+	v, err := c.VerifyOWID(o, offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == false {
+		t.Fatal("event not signed over the offer")
+	}
+}
+
+func TestEventBeacons(t *testing.T) {
+	c := newEventNetwork(t)
+	o, err := newEventTransaction(t, c).GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []string{common.EventImpression, common.EventViewable} {
+		w := serveEvent(c, httptest.NewRequest(
+			"GET",
+			"http://dsp.com/event/"+e+"?offer="+url.QueryEscape(o.AsString()),
+			nil))
+		if w.Code != http.StatusOK ||
+			w.Header().Get("Content-Type") != "image/gif" {
+			t.Fatalf("%s beacon returned %d", e, w.Code)
+		}
+	}
+	e := c.FindDomain("dsp.com").Events().ForOffer(o.AsString())
+	if len(e) != 2 ||
+		e[0].Type != common.EventImpression ||
+		e[1].Type != common.EventViewable {
+		t.Fatalf("expected impression and viewable events, got %v", e)
+	}
+}
+
+func TestEventBeaconInvalidOffer(t *testing.T) {
+	c := newEventNetwork(t)
+	w := serveEvent(c, httptest.NewRequest(
+		"GET",
+		"http://dsp.com/event/impression?offer=invalid",
+		nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %d", w.Code)
+	}
+}
+
+// TestEventClick checks that a click is recorded by the publisher and the
+// winning DSP before the browser is sent to the advertiser.
+func TestEventClick(t *testing.T) {
+	c := newEventNetwork(t)
+	n := newEventTransaction(t, c)
+	o, err := n.GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := n.AsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := url.Values{"transaction": {base64.StdEncoding.EncodeToString(j)}}
+	u := "http://pub.com/event/click"
+	for _, next := range []string{
+		"http://dsp.com/event/click",
+		"http://adv.com"} {
+		r := httptest.NewRequest("POST", u, strings.NewReader(f.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := serveEvent(c, r)
+		if w.Code != http.StatusTemporaryRedirect ||
+			w.Header().Get("Location") != next {
+			t.Fatalf("expected redirect to '%s', got %d '%s'",
+				next,
+				w.Code,
+				w.Header().Get("Location"))
+		}
+		u = next
+	}
+	checkEvent(t, c, "pub.com", common.EventClick, o)
+	checkEvent(t, c, "dsp.com", common.EventClick, o)
+}
+
+// TestEventClickNoBid checks that a click for a transaction without a bid is
+// not redirected.
+func TestEventClickNoBid(t *testing.T) {
+	c := newEventNetwork(t)
+	n := newEventTransaction(t, c)
+	n.Children = nil
+	j, err := n.AsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := url.Values{"transaction": {base64.StdEncoding.EncodeToString(j)}}
+	r := httptest.NewRequest(
+		"POST",
+		"http://pub.com/event/click",
+		strings.NewReader(f.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serveEvent(c, r); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %d", w.Code)
+	}
+}
@@ -0,0 +1,123 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"owid"
+	"strings"
+	"swan"
+)
+
+// transactionsPath is the path prefix for the transactions stored by a domain.
+// The rest of the path is the base 64 Offer OWID.
+const transactionsPath = "/transactions/"
+
+// handlerTransaction returns the winning path of the OWID tree as JSON for
+// the Offer ID in the path if the domain took part in the transaction. Only
+// the path is returned so the other bids are not revealed.
+func handlerTransaction(d *Domain, w http.ResponseWriter, r *http.Request) {
+	b, err := d.Transactions().Get(
+		strings.TrimPrefix(r.URL.Path, transactionsPath))
+	if err != nil {
+		ReturnServerError(d.Config, w, err)
+		return
+	}
+	if b == nil {
+		http.NotFound(w, r)
+		return
+	}
+	t, err := owid.NodeFromJSON(b)
+	if err != nil {
+		ReturnServerError(d.Config, w, err)
+		return
+	}
+	n, err := swan.WinningNode(t)
+	if err != nil {
+		ReturnServerError(d.Config, w, err)
+		return
+	}
+	if n == nil {
+		n = t
+	}
+	b, err = winningPath(n).AsJSON()
+	if err != nil {
+		ReturnServerError(d.Config, w, err)
+		return
+	}
+	g := gzip.NewWriter(w)
+	defer g.Close()
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, err = g.Write(b)
+	if err != nil {
+		ReturnServerError(d.Config, w, err)
+	}
+}
+
+// winningPath returns a copy of the tree containing only the nodes from the
+// root to the winning node.
+func winningPath(w *owid.Node) *owid.Node {
+	var c *owid.Node
+	for n := w; n != nil; n = n.GetParent() {
+		p := &owid.Node{OWID: n.OWID}
+		if c != nil {
+			p.Value = 0
+			p.AddChild(c)
+		}
+		c = p
+	}
+	return c
+}
+
+// FetchTransaction returns the winning path of the OWID tree for the base 64
+// Offer OWID from the publisher that created the offer.
+func FetchTransaction(c *Configuration, offerID string) (*owid.Node, error) {
+	o, err := owid.FromBase64(offerID)
+	if err != nil {
+		return nil, err
+	}
+	f, err := swan.OfferFromOWID(o)
+	if err != nil {
+		return nil, err
+	}
+	var u url.URL
+	u.Scheme = c.Scheme
+	u.Host = f.PubDomain
+	u.Path = transactionsPath + offerID
+	res, err := http.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"Transaction '%s' not available from '%s'",
+			offerID,
+			f.PubDomain)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return owid.NodeFromJSON(b)
+}
@@ -0,0 +1,210 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common_test
+
+import (
+	"common"
+	"compress/gzip"
+	"demotest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"openrtb"
+	"owid"
+	"swan"
+	"testing"
+)
+
+// newTwoBidderNetwork returns a configuration with a publisher whose
+// suppliers are two DSPs bidding different prices.
+func newTwoBidderNetwork(t *testing.T) *common.Configuration {
+	c := demotest.NewConfig()
+	for h, j := range map[string]string{
+		"pub.com":   `{"suppliers": ["dsp-a.com", "dsp-b.com"]}`,
+		"dsp-a.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 2}]}`,
+		"dsp-b.com": `{"adverts": [{"advertiserURL": "b.com", "cpm": 1}]}`} {
+		demotest.NewDomain(t, c, h, j).SetHandler(openrtb.Handler)
+	}
+	demotest.Serve(t, c)
+	return c
+}
+
+// newStoredTransaction runs a transaction where dsp-a.com outbids dsp-b.com,
+// stores the tree with the publisher and returns the tree and a reference
+// for the winning bid.
+func newStoredTransaction(
+	t *testing.T,
+	c *common.Configuration) (*owid.Node, *common.TransactionRef) {
+	t.Helper()
+	n := newEventTransaction(t, c)
+	p := c.FindDomain("pub.com")
+	r, err := n.GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.AsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Transactions().Add(r.AsString(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := swan.WinningNode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := common.NewTransactionRef(p, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, f
+}
+
+// getTransaction requests the transaction for the Offer ID from the
+// publisher and returns the response and the tree if there is one.
+func getTransaction(
+	t *testing.T,
+	c *common.Configuration,
+	offerID string) (*httptest.ResponseRecorder, *owid.Node) {
+	t.Helper()
+	u := url.URL{
+		Scheme: "http",
+		Host:   "pub.com",
+		Path:   "/transactions/" + offerID}
+	w := serveEvent(c, httptest.NewRequest("GET", u.String(), nil))
+	if w.Code != http.StatusOK {
+		return w, nil
+	}
+	g, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := owid.NodeFromJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, n
+}
+
+// domains returns the domains of the OWIDs in the tree.
+func domains(t *testing.T, n *owid.Node) map[string]bool {
+	t.Helper()
+	m := make(map[string]bool)
+	var add func(n *owid.Node)
+	add = func(n *owid.Node) {
+		o, err := n.GetOWID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m[o.Domain] = true
+		for _, c := range n.Children {
+			add(c)
+		}
+	}
+	add(n)
+	return m
+}
+
+// TestTransactionWinningPath checks that the transaction for an Offer ID only
+// contains the path from the offer to the winning bid.
+func TestTransactionWinningPath(t *testing.T) {
+	c := newTwoBidderNetwork(t)
+	n, f := newStoredTransaction(t, c)
+	if domains(t, n)["dsp-b.com"] == false {
+		t.Fatal("expected a bid from dsp-b.com in the full tree")
+	}
+	w, p := getTransaction(t, c, f.OfferID())
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the transaction, got %d", w.Code)
+	}
+	if d := domains(t, p); d["dsp-b.com"] || d["dsp-a.com"] == false {
+		t.Fatalf("expected only the winning path, got %v", d)
+	}
+	b, err := common.WinningBid(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil || b.AdvertiserURL != "a.com" {
+		t.Fatalf("expected the winning bid, got %+v", b)
+	}
+}
+
+// TestTransactionNotFound checks that an Offer ID the domain has not stored
+// is not found.
+func TestTransactionNotFound(t *testing.T) {
+	c := newTwoBidderNetwork(t)
+	w, _ := getTransaction(t, c, "AQ/+abc/def==")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown Offer ID, got %d", w.Code)
+	}
+}
+
+// TestFetchTransaction checks that the winning path of a transaction can be
+// fetched from the publisher with the Offer ID.
+func TestFetchTransaction(t *testing.T) {
+	c := newTwoBidderNetwork(t)
+	_, f := newStoredTransaction(t, c)
+	p, err := common.FetchTransaction(c, f.OfferID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := domains(t, p); len(d) != 2 || d["dsp-a.com"] == false {
+		t.Fatalf("expected the offer and winning bid, got %v", d)
+	}
+}
+
+// TestTransactionResolve checks that a reference can be resolved to the
+// winning path by fetching the transaction from the publisher, and that a
+// reference for another path is refused.
+func TestTransactionResolve(t *testing.T) {
+	c := newTwoBidderNetwork(t)
+	n, f := newStoredTransaction(t, c)
+	p, err := f.Resolve(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := domains(t, p); len(d) != 2 || d["dsp-a.com"] == false {
+		t.Fatalf("expected the offer and winning bid, got %v", d)
+	}
+	var l *owid.Node
+	var find func(n *owid.Node)
+	find = func(n *owid.Node) {
+		if o, err := n.GetOWID(); err == nil && o.Domain == "dsp-b.com" {
+			l = n
+		}
+		for _, i := range n.Children {
+			find(i)
+		}
+	}
+	find(n)
+	if l == nil {
+		t.Fatal("dsp-b.com missing")
+	}
+	g, err := common.NewTransactionRef(c.FindDomain("pub.com"), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Resolve(c); err == nil {
+		t.Fatal("reference for the losing path resolved")
+	}
+}
@@ -0,0 +1,50 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import "fmt"
+
+// Hop is the price of a bid received by a processor from a supplier and the
+// price passed on after the processor's fee.
+type Hop struct {
+	Received float64 `json:"received"` // CPM from the supplier
+	Passed   float64 `json:"passed"`   // CPM passed on
+}
+
+// NewHop returns the hop for the price received and the take rate.
+func NewHop(received float64, takeRate float64) *Hop {
+	return &Hop{received, received * (1 - takeRate)}
+}
+
+// Fee returns the percentage of the price received that the processor kept.
+func (h *Hop) Fee() float64 {
+	if h.Received == 0 {
+		return 0
+	}
+	return (h.Received - h.Passed) * 100 / h.Received
+}
+
+// validateTakeRate checks the take rate is a fraction of the price.
+func (d *Domain) validateTakeRate() error {
+	if d.TakeRate < 0 || d.TakeRate >= 1 {
+		return fmt.Errorf(
+			"Domain '%s' take rate %f not between 0 and 1",
+			d.Host,
+			d.TakeRate)
+	}
+	return nil
+}
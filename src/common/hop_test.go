@@ -0,0 +1,53 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import "testing"
+
+// TestHopFee checks the price passed on and the fee for the take rate.
+func TestHopFee(t *testing.T) {
+	h := NewHop(4, 0.25)
+	if h.Received != 4 || h.Passed != 3 {
+		t.Fatalf("expected 4 received and 3 passed, got %+v", h)
+	}
+	if h.Fee() != 25 {
+		t.Fatalf("expected a 25%% fee, got %v", h.Fee())
+	}
+	if f := NewHop(4, 0).Fee(); f != 0 {
+		t.Fatalf("expected no fee, got %v", f)
+	}
+	if f := NewHop(0, 0.5).Fee(); f != 0 {
+		t.Fatalf("expected no fee for a zero price, got %v", f)
+	}
+}
+
+// TestTakeRateValidated checks that a domain can't take a negative fee or
+// all of the price.
+func TestTakeRateValidated(t *testing.T) {
+	for _, r := range []float64{0, 0.2, 0.99} {
+		d := &Domain{Host: "ssp.com", TakeRate: r}
+		if err := d.validateTakeRate(); err != nil {
+			t.Fatalf("take rate %v refused: %s", r, err)
+		}
+	}
+	for _, r := range []float64{-0.1, 1, 1.5} {
+		d := &Domain{Host: "ssp.com", TakeRate: r}
+		if d.validateTakeRate() == nil {
+			t.Fatalf("take rate %v accepted", r)
+		}
+	}
+}
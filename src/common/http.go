@@ -56,9 +56,20 @@ func Handler(d []*Domain) http.HandlerFunc {
 					return
 				}
 
-				// If not found then use the domain handler.
+				// If not found then use the complaints inbox or event recording
+				// which all domains support, or the domain handler.
 				if f == false {
-					domain.handler(domain, w, r)
+					if r.URL.Path == "/complaints" {
+						handlerComplaints(domain, w, r)
+					} else if strings.HasPrefix(r.URL.Path, "/event/") {
+						handlerEvent(domain, w, r)
+					} else if strings.HasPrefix(
+						r.URL.Path,
+						transactionsPath) {
+						handlerTransaction(domain, w, r)
+					} else {
+						domain.handler(domain, w, r)
+					}
 				}
 
 				// Mark as the domain being found and then break.
@@ -0,0 +1,227 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"text/template/parse"
+)
+
+// Advert formats that placements can allow.
+const (
+	FormatBanner = "banner"
+	FormatVideo  = "video"
+	FormatNative = "native"
+)
+
+// Size of an advert or placement in CSS pixels.
+type Size struct {
+	Width  int
+	Height int
+}
+
+// Placement is a location on a publisher's web page where adverts are shown.
+// Placements are set in the publisher's config.json and referenced by ID from
+// the page templates.
+type Placement struct {
+	ID                string   // Identifier used by the page templates
+	Sizes             []Size   // Sizes of the adverts, the first is rendered
+	Formats           []string // Formats allowed, banner if none
+	Floor             float64  // Minimum price per thousand impressions
+	BlockedCategories []string // Advertiser categories that are not allowed
+}
+
+// AllowedFormats returns the formats allowed at the placement.
+func (p *Placement) AllowedFormats() []string {
+	if len(p.Formats) == 0 {
+		return []string{FormatBanner}
+	}
+	return p.Formats
+}
+
+// AllowsFormat returns true if the format is allowed at the placement.
+func (p *Placement) AllowsFormat(f string) bool {
+	for _, i := range p.AllowedFormats() {
+		if strings.EqualFold(i, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// Size returns the size the advert is rendered at.
+func (p *Placement) Size() Size {
+	if len(p.Sizes) == 0 {
+		return Size{}
+	}
+	return p.Sizes[0]
+}
+
+// Accepts returns true if the advert at the price per thousand impressions
+// can be shown at the placement. Adverts without a size can be scaled to fit
+// any of the placement's sizes.
+func (p *Placement) Accepts(a *Advert, cpm float64) bool {
+	if p.AllowsFormat(a.FormatOrDefault()) == false || cpm < p.Floor {
+		return false
+	}
+	for _, c := range p.BlockedCategories {
+		if strings.EqualFold(c, a.Category) {
+			return false
+		}
+	}
+	if a.Width == 0 && a.Height == 0 {
+		return true
+	}
+	for _, s := range p.Sizes {
+		if s.Width == a.Width && s.Height == a.Height {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns an error if the placement is misconfigured.
+func (p *Placement) validate(host string) error {
+	if p.ID == "" {
+		return fmt.Errorf("Placement in '%s' has no ID", host)
+	}
+	if len(p.Sizes) == 0 {
+		return fmt.Errorf("Placement '%s' in '%s' has no sizes", p.ID, host)
+	}
+	for _, s := range p.Sizes {
+		if s.Width <= 0 || s.Height <= 0 {
+			return fmt.Errorf(
+				"Placement '%s' in '%s' has invalid size %dx%d",
+				p.ID,
+				host,
+				s.Width,
+				s.Height)
+		}
+	}
+	for _, f := range p.AllowedFormats() {
+		if f != FormatBanner && f != FormatVideo && f != FormatNative {
+			return fmt.Errorf(
+				"Placement '%s' in '%s' has unknown format '%s'",
+				p.ID,
+				host,
+				f)
+		}
+	}
+	if p.Floor < 0 {
+		return fmt.Errorf(
+			"Placement '%s' in '%s' has negative floor",
+			p.ID,
+			host)
+	}
+	return nil
+}
+
+// placementMethods are the template methods whose argument is a placement ID.
+var placementMethods = map[string]bool{
+	"NewAdvertHTML": true,
+	"AdvertTag":     true}
+
+// validatePlacements checks the placements of the domain are valid and that
+// every placement the templates reference is declared.
+func (d *Domain) validatePlacements() error {
+	ids := make(map[string]bool)
+	for i := range d.Placements {
+		p := &d.Placements[i]
+		err := p.validate(d.Host)
+		if err != nil {
+			return err
+		}
+		if ids[p.ID] {
+			return fmt.Errorf(
+				"Placement '%s' in '%s' is declared more than once",
+				p.ID,
+				d.Host)
+		}
+		ids[p.ID] = true
+	}
+	if d.templates == nil {
+		return nil
+	}
+	for _, t := range d.templates.Templates() {
+		for _, r := range templatePlacements(t) {
+			if ids[r] == false {
+				return fmt.Errorf(
+					"Template '%s' in '%s' references placement '%s' "+
+						"which is not in config.json",
+					t.Name(),
+					d.Host,
+					r)
+			}
+		}
+	}
+	return nil
+}
+
+// templatePlacements returns the placement IDs passed to NewAdvertHTML or
+// AdvertTag in the template.
+func templatePlacements(t *template.Template) []string {
+	var r []string
+	if t.Tree == nil {
+		return r
+	}
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n != nil {
+				for _, i := range n.Nodes {
+					walk(i)
+				}
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.PipeNode:
+			if n != nil {
+				for _, c := range n.Cmds {
+					walk(c)
+				}
+			}
+		case *parse.CommandNode:
+			if len(n.Args) > 1 {
+				f, ok := n.Args[0].(*parse.FieldNode)
+				s, sok := n.Args[1].(*parse.StringNode)
+				if ok && sok && placementMethods[f.Ident[len(f.Ident)-1]] {
+					r = append(r, s.Text)
+				}
+			}
+			for _, a := range n.Args {
+				walk(a)
+			}
+		}
+	}
+	walk(t.Tree.Root)
+	return r
+}
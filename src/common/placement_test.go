@@ -0,0 +1,110 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestPlacementAccepts(t *testing.T) {
+	p := Placement{
+		ID:                "top",
+		Sizes:             []Size{{728, 90}, {320, 50}},
+		Formats:           []string{FormatBanner, FormatVideo},
+		Floor:             1.5,
+		BlockedCategories: []string{"gambling"}}
+	for _, v := range []struct {
+		name   string
+		advert Advert
+		cpm    float64
+		accept bool
+	}{
+		{"scalable banner", Advert{}, 2, true},
+		{"second size", Advert{Width: 320, Height: 50}, 2, true},
+		{"wrong size", Advert{Width: 300, Height: 250}, 2, false},
+		{"video", Advert{Format: FormatVideo}, 2, true},
+		{"native not allowed", Advert{Format: FormatNative}, 2, false},
+		{"at floor", Advert{}, 1.5, true},
+		{"below floor", Advert{}, 1.4, false},
+		{"blocked category", Advert{Category: "Gambling"}, 2, false},
+		{"other category", Advert{Category: "cars"}, 2, true},
+	} {
+		if p.Accepts(&v.advert, v.cpm) != v.accept {
+			t.Errorf("%s: expected accept %v", v.name, v.accept)
+		}
+	}
+}
+
+func TestPlacementDefaults(t *testing.T) {
+	var p Placement
+	if p.AllowsFormat(FormatBanner) == false ||
+		p.AllowsFormat(FormatVideo) {
+		t.Fatal("placements without formats should only allow banners")
+	}
+	if p.Size() != (Size{}) {
+		t.Fatal("placements without sizes should have an empty size")
+	}
+}
+
+func TestPlacementValidate(t *testing.T) {
+	for _, v := range []struct {
+		placement Placement
+		err       string
+	}{
+		{Placement{Sizes: []Size{{1, 1}}}, "no ID"},
+		{Placement{ID: "a"}, "no sizes"},
+		{Placement{ID: "a", Sizes: []Size{{0, 90}}}, "invalid size"},
+		{Placement{
+			ID:      "a",
+			Sizes:   []Size{{1, 1}},
+			Formats: []string{"audio"}}, "unknown format"},
+		{Placement{ID: "a", Sizes: []Size{{1, 1}}, Floor: -1}, "negative floor"},
+		{Placement{ID: "a", Sizes: []Size{{1, 1}}}, ""},
+	} {
+		err := v.placement.validate("pub.com")
+		if v.err == "" && err != nil {
+			t.Errorf("unexpected error %s", err)
+		}
+		if v.err != "" && (err == nil || strings.Contains(err.Error(), v.err) == false) {
+			t.Errorf("expected error '%s', got %v", v.err, err)
+		}
+	}
+}
+
+func TestValidatePlacements(t *testing.T) {
+	p := Placement{ID: "top", Sizes: []Size{{728, 90}}}
+	d := Domain{Host: "pub.com", Placements: []Placement{p, p}}
+	if err := d.validatePlacements(); err == nil {
+		t.Fatal("duplicate placement should be an error")
+	}
+	d.Placements = []Placement{p}
+	d.templates = template.Must(template.New("page.html").Parse(
+		`{{ if .Valid }}{{ .NewAdvertHTML "top" }}{{ end }}` +
+			`{{ with .Other }}{{ .NewAdvertHTML "side" }}{{ end }}`))
+	err := d.validatePlacements()
+	if err == nil || strings.Contains(err.Error(), "'side'") == false {
+		t.Fatalf("undeclared placement should be an error, got %v", err)
+	}
+	d.Placements = append(d.Placements, Placement{
+		ID:    "side",
+		Sizes: []Size{{300, 250}}})
+	if err = d.validatePlacements(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"sort"
+	"strings"
+)
+
+// Identifiers for the consent purposes the demo understands.
+const (
+	PurposePersonalizedAds     = "personalized-ads"
+	PurposeMeasurement         = "measurement"
+	PurposeContentPersonalized = "content-personalization"
+)
+
+// Preference values used when consent is all or nothing.
+const (
+	consentAll  = "on"  // Legacy value meaning every purpose is allowed
+	consentNone = "off" // No purposes are allowed
+)
+
+// Purpose is a reason for using personal data that the user can allow or deny
+// individually in the CMP dialog.
+type Purpose struct {
+	ID          string // Identifier carried in the SWAN preferences value
+	Name        string // Name displayed in the CMP dialog
+	Description string // Help text displayed in the CMP dialog
+}
+
+// DefaultPurposes are used by CMPs that do not configure their own purposes.
+var DefaultPurposes = []Purpose{
+	{
+		ID:          PurposePersonalizedAds,
+		Name:        "Personalize Marketing",
+		Description: "Tick to receive adverts chosen using your preferences.",
+	},
+	{
+		ID:          PurposeMeasurement,
+		Name:        "Measure Advertising",
+		Description: "Tick to let advertisers measure how their adverts perform.",
+	},
+	{
+		ID:          PurposeContentPersonalized,
+		Name:        "Personalize Content",
+		Description: "Tick to receive more personalized content.",
+	},
+}
+
+// Consent is the set of purpose identifiers the user has allowed.
+type Consent []string
+
+// ParseConsent turns the preferences value stored in SWAN into the purposes
+// allowed. The legacy value "on" allows all the default purposes, and "off" or
+// an empty string allows none. Any other value is a comma separated list of
+// purpose identifiers.
+func ParseConsent(s string) Consent {
+	var c Consent
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", consentNone:
+		return c
+	case consentAll:
+		for _, p := range DefaultPurposes {
+			c = append(c, p.ID)
+		}
+		return c
+	}
+	for _, i := range strings.Split(s, ",") {
+		i = strings.TrimSpace(i)
+		if i != "" && c.Has(i) == false {
+			c = append(c, i)
+		}
+	}
+	sort.Strings(c)
+	return c
+}
+
+// Has returns true if the purpose has been allowed, otherwise false.
+func (c Consent) Has(purpose string) bool {
+	for _, i := range c {
+		if i == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAll returns true if every one of the purposes has been allowed.
+func (c Consent) HasAll(purposes []string) bool {
+	for _, p := range purposes {
+		if c.Has(p) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the value to store in SWAN for the consent.
+func (c Consent) String() string {
+	if len(c) == 0 {
+		return consentNone
+	}
+	return strings.Join(c, ",")
+}
@@ -0,0 +1,63 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConsent(t *testing.T) {
+	for _, v := range []struct {
+		value    string
+		expected Consent
+	}{
+		{"", nil},
+		{"off", nil},
+		{"on", Consent{
+			PurposePersonalizedAds,
+			PurposeMeasurement,
+			PurposeContentPersonalized}},
+		{"measurement, personalized-ads,measurement", Consent{
+			PurposeMeasurement,
+			PurposePersonalizedAds}},
+	} {
+		c := ParseConsent(v.value)
+		if reflect.DeepEqual(c, v.expected) == false {
+			t.Errorf("'%s' parsed as %v, expected %v", v.value, c, v.expected)
+		}
+	}
+}
+
+func TestConsentHasAll(t *testing.T) {
+	c := ParseConsent(PurposeMeasurement)
+	if c.HasAll(nil) == false {
+		t.Error("no purposes needed should be allowed")
+	}
+	if c.HasAll([]string{PurposeMeasurement}) == false {
+		t.Error("measurement should be allowed")
+	}
+	if c.HasAll([]string{PurposeMeasurement, PurposePersonalizedAds}) {
+		t.Error("personalized ads should not be allowed")
+	}
+	if ParseConsent(c.String()).String() != c.String() {
+		t.Error("consent should survive a round trip")
+	}
+	if Consent(nil).String() != consentNone {
+		t.Error("no consent should be stored as off")
+	}
+}
@@ -0,0 +1,153 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"owid"
+	"strings"
+	"swan"
+)
+
+// referenceHashSize is the number of bytes of the hash of each OWID on the
+// winning path held in a transaction reference.
+const referenceHashSize = 16
+
+// referenceSeparator separates the OWIDs in a transaction reference. It is
+// not a base 64 character.
+const referenceSeparator = "."
+
+// TransactionRef is a compact reference to a transaction used in advert
+// markup instead of the OWID tree. The publisher creates an OWID with the
+// hashes of the OWIDs on the winning path as the payload and signs it over the
+// Offer OWID. The reference can be verified without the tree, and the tree
+// fetched from the publisher can be checked against it.
+type TransactionRef struct {
+	Offer *owid.OWID // The Offer OWID at the root of the tree
+	OWID  *owid.OWID // The publisher's OWID with the winning path hashes
+}
+
+// NewTransactionRef returns a reference for the transaction with the winning
+// node signed by the publisher domain.
+func NewTransactionRef(d *Domain, w *owid.Node) (*TransactionRef, error) {
+	_, err := d.GetOWIDCreator()
+	if err != nil {
+		return nil, err
+	}
+	o, err := w.GetRoot().GetOWID()
+	if err != nil {
+		return nil, err
+	}
+	t := d.OWID.CreateOWID(pathHashes(w))
+	if t == nil {
+		return nil, fmt.Errorf("Could not create new OWID")
+	}
+	err = d.OWID.Sign(t, o)
+	if err != nil {
+		return nil, err
+	}
+	return &TransactionRef{o, t}, nil
+}
+
+// ParseTransactionRef returns the reference from the string form after
+// checking it was signed by the publisher of the offer.
+func ParseTransactionRef(
+	c *Configuration,
+	s string) (*TransactionRef, error) {
+	p := strings.Split(s, referenceSeparator)
+	if len(p) != 2 {
+		return nil, fmt.Errorf("Transaction reference invalid")
+	}
+	var r TransactionRef
+	var err error
+	r.Offer, err = owid.FromBase64(p[0])
+	if err != nil {
+		return nil, err
+	}
+	r.OWID, err = owid.FromBase64(p[1])
+	if err != nil {
+		return nil, err
+	}
+	f, err := swan.OfferFromOWID(r.Offer)
+	if err != nil {
+		return nil, err
+	}
+	if r.OWID.Domain != f.PubDomain {
+		return nil, fmt.Errorf(
+			"Transaction reference from '%s' not publisher '%s'",
+			r.OWID.Domain,
+			f.PubDomain)
+	}
+	ok, err := c.VerifyOWID(r.OWID, r.Offer)
+	if err != nil {
+		return nil, err
+	}
+	if ok == false {
+		return nil, fmt.Errorf("Transaction reference signature invalid")
+	}
+	return &r, nil
+}
+
+// String returns the reference for use in HTML forms and URLs.
+func (r *TransactionRef) String() string {
+	return r.Offer.AsString() + referenceSeparator + r.OWID.AsString()
+}
+
+// OfferID returns the base 64 Offer OWID.
+func (r *TransactionRef) OfferID() string { return r.Offer.AsString() }
+
+// Resolve fetches the winning path of the OWID tree from the publisher and
+// checks it is for the offer and matches the reference.
+func (r *TransactionRef) Resolve(c *Configuration) (*owid.Node, error) {
+	t, err := FetchTransaction(c, r.OfferID())
+	if err != nil {
+		return nil, err
+	}
+	o, err := r.Offer.AsByteArray()
+	if err != nil {
+		return nil, err
+	}
+	w, err := swan.WinningNode(t)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil ||
+		bytes.Equal(t.GetRoot().OWID, o) == false ||
+		bytes.Equal(pathHashes(w), r.OWID.Payload) == false {
+		return nil, fmt.Errorf(
+			"Transaction '%s' does not match reference",
+			r.OfferID())
+	}
+	return t, nil
+}
+
+// pathHashes returns the truncated hashes of the OWIDs from the child of the
+// root to the node.
+func pathHashes(w *owid.Node) []byte {
+	var h [][]byte
+	for n := w; n != nil && n.GetParent() != nil; n = n.GetParent() {
+		s := sha256.Sum256(n.OWID)
+		h = append(h, s[:referenceHashSize])
+	}
+	var b []byte
+	for i := len(h) - 1; i >= 0; i-- {
+		b = append(b, h[i]...)
+	}
+	return b
+}
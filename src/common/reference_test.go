@@ -0,0 +1,148 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common_test
+
+import (
+	"common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"owid"
+	"strings"
+	"swan"
+	"testing"
+)
+
+// TestTransactionRefRoundTrip checks that the string form of a reference can
+// be parsed and holds the Offer ID and a hash for each OWID on the winning
+// path after the root.
+func TestTransactionRefRoundTrip(t *testing.T) {
+	c := newTwoBidderNetwork(t)
+	n, f := newStoredTransaction(t, c)
+	g, err := common.ParseTransactionRef(c, f.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := n.GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.OfferID() != o.AsString() || g.OWID.Domain != "pub.com" {
+		t.Fatalf("expected the Offer ID signed by pub.com, got %s", g.String())
+	}
+	w, err := swan.WinningNode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := 0
+	for p := w; p.GetParent() != nil; p = p.GetParent() {
+		d++
+	}
+	if len(g.OWID.Payload) != d*16 {
+		t.Fatalf("expected %d path hashes, got %d bytes",
+			d,
+			len(g.OWID.Payload))
+	}
+	if len(f.String()) >= len(mustJSON(t, n)) {
+		t.Fatal("reference is not smaller than the tree")
+	}
+}
+
+// TestTransactionRefTampered checks that references that have been changed,
+// are for another offer, or were not signed by the publisher are refused
+// without fetching the tree.
+func TestTransactionRefTampered(t *testing.T) {
+	c := newTwoBidderNetwork(t)
+	n, f := newStoredTransaction(t, c)
+
+	// Path hashes changed.
+	x := *f.OWID
+	x.Payload = append([]byte{}, x.Payload...)
+	x.Payload[len(x.Payload)-1]++
+	s := (&common.TransactionRef{Offer: f.Offer, OWID: &x}).String()
+	if _, err := common.ParseTransactionRef(c, s); err == nil {
+		t.Fatal("reference with changed path accepted")
+	}
+
+	// Reference moved to another offer.
+	_, g := newStoredTransaction(t, c)
+	s = (&common.TransactionRef{Offer: g.Offer, OWID: f.OWID}).String()
+	if _, err := common.ParseTransactionRef(c, s); err == nil {
+		t.Fatal("reference for another offer accepted")
+	}
+
+	// Reference created by a DSP rather than the publisher.
+	w, err := swan.WinningNode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := common.NewTransactionRef(c.FindDomain("dsp-a.com"), w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := common.ParseTransactionRef(c, h.String()); err == nil {
+		t.Fatal("reference from a DSP accepted")
+	}
+
+	// Malformed references.
+	for _, s := range []string{
+		"",
+		f.OfferID(),
+		f.String() + ".",
+		"invalid.invalid"} {
+		if _, err := common.ParseTransactionRef(c, s); err == nil {
+			t.Fatalf("reference '%s' accepted", s)
+		}
+	}
+}
+
+// TestEventClickReference checks that a click with a transaction reference
+// fetches the transaction from the publisher to find the winning DSP and
+// advertiser.
+func TestEventClickReference(t *testing.T) {
+	c := newTwoBidderNetwork(t)
+	_, f := newStoredTransaction(t, c)
+	v := url.Values{"reference": {f.String()}}
+	u := "http://pub.com/event/click"
+	for _, next := range []string{
+		"http://dsp-a.com/event/click",
+		"http://a.com"} {
+		r := httptest.NewRequest("POST", u, strings.NewReader(v.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := serveEvent(c, r)
+		if w.Code != http.StatusTemporaryRedirect ||
+			w.Header().Get("Location") != next {
+			t.Fatalf("expected redirect to '%s', got %d '%s'",
+				next,
+				w.Code,
+				w.Header().Get("Location"))
+		}
+		u = next
+	}
+	checkEvent(t, c, "pub.com", common.EventClick, f.Offer)
+	checkEvent(t, c, "dsp-a.com", common.EventClick, f.Offer)
+}
+
+// mustJSON returns the tree as JSON.
+func mustJSON(t *testing.T, n *owid.Node) []byte {
+	t.Helper()
+	b, err := n.AsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,114 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultOfferWindow is how long after creation an offer is accepted if the
+// configuration does not provide a period.
+const defaultOfferWindow = 10 * time.Minute
+
+// seenOffersLimit is the most offers each domain remembers.
+const seenOffersLimit = 100000
+
+// SeenOffers remembers the offers a processor has recently been asked to take
+// part in so duplicate requests through other supply paths, and replays of
+// captured offers, can be refused. Offers are remembered for the TTL which
+// should be at least as long as offers are accepted for. If more offers than
+// the limit are seen within the TTL the offers seen longest ago are forgotten.
+// Offers created before the last forgotten offer was seen are then refused as
+// they might have been forgotten, so sending many other offers can't be used
+// to replay a captured one.
+type SeenOffers struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	limit   int                  // Most offers remembered
+	offers  map[string]time.Time // Offer UUID to when first seen
+	order   []string             // Offer UUIDs in the order first seen
+	evicted time.Time            // When the last offer forgotten early was seen
+}
+
+// NewSeenOffers creates a new empty set of offers remembered for the TTL.
+func NewSeenOffers(ttl time.Duration) *SeenOffers {
+	return &SeenOffers{
+		ttl:    ttl,
+		limit:  seenOffersLimit,
+		offers: make(map[string]time.Time)}
+}
+
+// Add returns true if the offer created at the time provided has not been
+// seen within the TTL and remembers it. Otherwise returns false and when the
+// offer was first seen, or when the last offer forgotten early was seen if
+// the offer was created before then.
+func (s *SeenOffers) Add(id string, created time.Time) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := time.Now()
+	s.expire(n)
+	if t, ok := s.offers[id]; ok {
+		return t, false
+	}
+	if created.After(s.evicted) == false {
+		return s.evicted, false
+	}
+	if len(s.order) >= s.limit {
+		s.evict(len(s.order) - s.limit + 1)
+	}
+	s.offers[id] = n
+	s.order = append(s.order, id)
+	return n, true
+}
+
+// Len returns the number of offers remembered.
+func (s *SeenOffers) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.offers)
+}
+
+// expire forgets the offers first seen longer ago than the TTL. The order is
+// the order first seen so only the start of the order needs to be checked.
+func (s *SeenOffers) expire(n time.Time) {
+	i := 0
+	for i < len(s.order) && n.Sub(s.offers[s.order[i]]) >= s.ttl {
+		delete(s.offers, s.order[i])
+		i++
+	}
+	s.order = s.order[i:]
+}
+
+// evict forgets the number of offers seen longest ago before their TTL and
+// records when the last of them was seen.
+func (s *SeenOffers) evict(count int) {
+	for _, id := range s.order[:count] {
+		s.evicted = s.offers[id]
+		delete(s.offers, id)
+	}
+	s.order = s.order[count:]
+}
+
+// OfferWindow returns how long after creation processors accept an offer.
+func (c *Configuration) OfferWindow() time.Duration {
+	d, err := time.ParseDuration(c.OfferExpiry)
+	if err != nil || d <= 0 {
+		return defaultOfferWindow
+	}
+	return d
+}
@@ -0,0 +1,78 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestSeenOffers checks that an offer is only added once within the TTL.
+func TestSeenOffers(t *testing.T) {
+	s := NewSeenOffers(time.Hour)
+	c := time.Now()
+	f, ok := s.Add("a", c)
+	if ok == false {
+		t.Fatal("new offer refused")
+	}
+	g, ok := s.Add("a", c)
+	if ok || g != f {
+		t.Fatal("expected the offer to be seen with the time first added")
+	}
+	if _, ok := s.Add("b", c); ok == false {
+		t.Fatal("other offer refused")
+	}
+}
+
+// TestSeenOffersLimit checks that the offers seen longest ago are forgotten
+// when the limit is reached, and that offers created before they were seen
+// are still refused so they can't be replayed by sending other offers first.
+func TestSeenOffersLimit(t *testing.T) {
+	s := NewSeenOffers(time.Hour)
+	s.limit = 100
+	c := time.Now()
+	s.Add("replayed", c)
+	for i := 0; i < 2*s.limit; i++ {
+		s.Add(fmt.Sprintf("offer-%d", i), time.Now())
+	}
+	if s.Len() != s.limit {
+		t.Fatalf("expected %d offers, got %d", s.limit, s.Len())
+	}
+	if _, ok := s.Add("replayed", c); ok {
+		t.Fatal("forgotten offer accepted")
+	}
+	if _, ok := s.Add("new", time.Now()); ok == false {
+		t.Fatal("offer created after the last forgotten offer refused")
+	}
+}
+
+// TestSeenOffersExpire checks that offers first seen longer ago than the TTL
+// are forgotten.
+func TestSeenOffersExpire(t *testing.T) {
+	s := NewSeenOffers(50 * time.Millisecond)
+	c := time.Now()
+	s.Add("a", c)
+	s.Add("b", c)
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := s.Add("a", c); ok == false {
+		t.Fatal("expired offer still seen")
+	}
+	if s.Len() != 1 {
+		t.Fatalf("expected expired offers to be removed, got %d", s.Len())
+	}
+}
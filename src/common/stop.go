@@ -0,0 +1,204 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Scopes for entries in the stop list.
+const (
+	StopScopeAdvertiser = "advertiser" // Adverts for the host are stopped
+	StopScopeSupplier   = "supplier"   // The processor host is not used
+	StopScopeCategory   = "category"   // Adverts in the category are stopped
+	// The host is a wildcard pattern matched against advertisers and suppliers
+	StopScopePattern = "pattern"
+)
+
+// StopScopes are all the valid scopes for entries in the stop list.
+var StopScopes = []string{
+	StopScopeAdvertiser,
+	StopScopeSupplier,
+	StopScopeCategory,
+	StopScopePattern}
+
+// IsStopScope returns true if the scope is valid.
+func IsStopScope(s string) bool {
+	for _, i := range StopScopes {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
+// stopSeparator separates the fields of a stop entry when stored in SWAN.
+const stopSeparator = "|"
+
+// StopEntry is a host the user has stopped along with when and how.
+type StopEntry struct {
+	Host  string    // The host that is stopped
+	Scope string    // What the stop applies to
+	Date  time.Time // When the stop was added, zero if not known
+}
+
+// StopList is the list of stopped hosts held in the SWAN stop value.
+type StopList []*StopEntry
+
+// NewStopEntry returns an entry for the host and scope dated now.
+func NewStopEntry(host string, scope string) *StopEntry {
+	return &StopEntry{host, scope, time.Now().UTC()}
+}
+
+// ParseStopEntry returns the entry from the value stored in SWAN. Values that
+// contain only a host are advertiser stops with an unknown date.
+func ParseStopEntry(s string) *StopEntry {
+	f := strings.Split(strings.TrimSpace(s), stopSeparator)
+	e := StopEntry{Host: f[0], Scope: StopScopeAdvertiser}
+	if len(f) > 1 && f[1] != "" {
+		e.Scope = f[1]
+	}
+	if len(f) > 2 {
+		e.Date, _ = time.Parse(time.RFC3339, f[2])
+	}
+	return &e
+}
+
+// String returns the entry in the form stored in SWAN.
+func (e *StopEntry) String() string {
+	if e.Date.IsZero() {
+		return e.Host + stopSeparator + e.Scope
+	}
+	return e.Host + stopSeparator + e.Scope + stopSeparator +
+		e.Date.Format(time.RFC3339)
+}
+
+// Key identifies the entry within the list.
+func (e *StopEntry) Key() string { return e.Scope + stopSeparator + e.Host }
+
+// DateAsString returns the date the entry was added for display.
+func (e *StopEntry) DateAsString() string {
+	if e.Date.IsZero() {
+		return "Unknown"
+	}
+	return e.Date.Format("2006-01-02 15:04")
+}
+
+// NewStopList returns the list from the individual values provided by SWAN.
+func NewStopList(values []string) StopList {
+	var l StopList
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			l = l.Add(ParseStopEntry(v))
+		}
+	}
+	return l
+}
+
+// ParseStopList returns the list from the stop value stored in SWAN.
+func ParseStopList(s string) StopList {
+	return NewStopList(strings.Split(s, "\r\n"))
+}
+
+// String returns the list as the stop value stored in SWAN.
+func (l StopList) String() string {
+	var s []string
+	for _, e := range l {
+		s = append(s, e.String())
+	}
+	return strings.Join(s, "\r\n")
+}
+
+// Add returns the list with the entry added, replacing any existing entry for
+// the same host and scope.
+func (l StopList) Add(e *StopEntry) StopList {
+	l = l.Remove(e.Key())
+	l = append(l, e)
+	sort.SliceStable(l, func(i, j int) bool { return l[i].Host < l[j].Host })
+	return l
+}
+
+// Remove returns the list without the entries whose keys are provided.
+func (l StopList) Remove(keys ...string) StopList {
+	var r StopList
+	for _, e := range l {
+		f := false
+		for _, k := range keys {
+			if e.Key() == k {
+				f = true
+				break
+			}
+		}
+		if f == false {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// IsStopped returns true if adverts for the host have been stopped.
+func (l StopList) IsStopped(host string) bool {
+	return l.IsAdvertStopped(&Advert{AdvertiserURL: host})
+}
+
+// IsAdvertStopped returns true if the advert's advertiser or category have
+// been stopped.
+func (l StopList) IsAdvertStopped(a *Advert) bool {
+	for _, e := range l {
+		switch e.Scope {
+		case StopScopeAdvertiser:
+			if strings.EqualFold(e.Host, a.AdvertiserURL) {
+				return true
+			}
+		case StopScopeCategory:
+			if a.Category != "" && strings.EqualFold(e.Host, a.Category) {
+				return true
+			}
+		case StopScopePattern:
+			if e.matches(a.AdvertiserURL) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsSupplierStopped returns true if the processor host has been stopped.
+func (l StopList) IsSupplierStopped(host string) bool {
+	for _, e := range l {
+		switch e.Scope {
+		case StopScopeSupplier:
+			if strings.EqualFold(e.Host, host) {
+				return true
+			}
+		case StopScopePattern:
+			if e.matches(host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches returns true if the host matches the entry's wildcard pattern.
+func (e *StopEntry) matches(host string) bool {
+	m, err := path.Match(strings.ToLower(e.Host), strings.ToLower(host))
+	return err == nil && m
+}
@@ -0,0 +1,96 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStopEntryRoundTrip(t *testing.T) {
+	d := time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)
+	e := &StopEntry{"dsp.com", StopScopeSupplier, d}
+	p := ParseStopEntry(e.String())
+	if *p != *e {
+		t.Fatalf("parsed %+v, expected %+v", p, e)
+	}
+	p = ParseStopEntry(" advertiser.com ")
+	if p.Host != "advertiser.com" ||
+		p.Scope != StopScopeAdvertiser ||
+		p.Date.IsZero() == false ||
+		p.DateAsString() != "Unknown" {
+		t.Fatalf("host only entry parsed as %+v", p)
+	}
+}
+
+func TestStopList(t *testing.T) {
+	l := ParseStopList("b.com\r\na.com|supplier\r\n\r\nb.com|category")
+	if len(l) != 3 || l[0].Host != "a.com" {
+		t.Fatalf("parsed %v", l.String())
+	}
+	l = l.Add(NewStopEntry("b.com", StopScopeAdvertiser))
+	if len(l) != 3 {
+		t.Fatal("adding the same host and scope should replace the entry")
+	}
+	if ParseStopList(l.String()).String() != l.String() {
+		t.Fatal("list should survive a round trip")
+	}
+	l = l.Remove(
+		StopScopeAdvertiser+stopSeparator+"b.com",
+		StopScopeSupplier+stopSeparator+"a.com")
+	if len(l) != 1 || l[0].Scope != StopScopeCategory {
+		t.Fatalf("remaining %v", l.String())
+	}
+}
+
+func TestStopScopes(t *testing.T) {
+	l := NewStopList([]string{
+		"advertiser.com",
+		"Gambling|category",
+		"bad-dsp.com|supplier",
+		"*.tracker.com|pattern"})
+	for _, v := range []struct {
+		advert  Advert
+		stopped bool
+	}{
+		{Advert{AdvertiserURL: "ADVERTISER.com"}, true},
+		{Advert{AdvertiserURL: "other.com", Category: "gambling"}, true},
+		{Advert{AdvertiserURL: "ads.tracker.com"}, true},
+		{Advert{AdvertiserURL: "bad-dsp.com"}, false},
+		{Advert{AdvertiserURL: "other.com", Category: "cars"}, false},
+	} {
+		if l.IsAdvertStopped(&v.advert) != v.stopped {
+			t.Errorf("%+v stopped should be %v", v.advert, v.stopped)
+		}
+	}
+	for _, v := range []struct {
+		host    string
+		stopped bool
+	}{
+		{"bad-dsp.com", true},
+		{"x.tracker.com", true},
+		{"advertiser.com", false},
+		{"gambling", false},
+	} {
+		if l.IsSupplierStopped(v.host) != v.stopped {
+			t.Errorf("supplier %s stopped should be %v", v.host, v.stopped)
+		}
+	}
+	if IsStopScope("everything") || IsStopScope(StopScopePattern) == false {
+		t.Error("scope validation incorrect")
+	}
+}
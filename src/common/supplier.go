@@ -0,0 +1,64 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import "strings"
+
+// SupplierCycles returns the cycles in the graph formed by the Suppliers of
+// the domains. Each cycle starts and ends with the same host. Processors
+// refuse to take part twice in the same transaction so cycles don't cause
+// endless requests, but they are a configuration mistake.
+func (c *Configuration) SupplierCycles() [][]string {
+	var r [][]string
+	s := make(map[string]int) // 0 not visited, 1 on the path, 2 finished
+	var p []string
+	var visit func(h string)
+	visit = func(h string) {
+		s[h] = 1
+		p = append(p, h)
+		if d := c.FindDomain(h); d != nil {
+			for _, n := range d.Suppliers {
+				n = strings.ToLower(n)
+				switch s[n] {
+				case 0:
+					visit(n)
+				case 1:
+					r = append(r, append(cycleFrom(p, n), n))
+				}
+			}
+		}
+		p = p[:len(p)-1]
+		s[h] = 2
+	}
+	for _, d := range c.Domains {
+		h := strings.ToLower(d.Host)
+		if s[h] == 0 {
+			visit(h)
+		}
+	}
+	return r
+}
+
+// cycleFrom returns a copy of the path from the host to the end.
+func cycleFrom(p []string, h string) []string {
+	for i, e := range p {
+		if e == h {
+			return append([]string(nil), p[i:]...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newSupplierConfig returns a configuration with domains for the hosts and
+// their suppliers.
+func newSupplierConfig(t *testing.T, suppliers map[string]string) *Configuration {
+	t.Helper()
+	c := &Configuration{}
+	for h, s := range suppliers {
+		newTestDomain(t, c, h, `{"suppliers": [`+s+`]}`)
+	}
+	return c
+}
+
+// TestSupplierCyclesNone checks that suppliers shared by several domains are
+// not a cycle.
+func TestSupplierCyclesNone(t *testing.T) {
+	c := newSupplierConfig(t, map[string]string{
+		"pub.com": `"ssp.com", "dsp.com"`,
+		"ssp.com": `"dsp.com"`,
+		"dsp.com": ``})
+	if r := c.SupplierCycles(); len(r) != 0 {
+		t.Fatalf("expected no cycles, got %v", r)
+	}
+}
+
+// TestSupplierCycles checks that domains which supply each other, compared
+// without case, and a domain that supplies itself are cycles.
+func TestSupplierCycles(t *testing.T) {
+	c := newSupplierConfig(t, map[string]string{
+		"pub.com":  `"ex-a.com"`,
+		"ex-a.com": `"EX-B.com"`,
+		"ex-b.com": `"ex-a.com"`})
+	r := c.SupplierCycles()
+	if len(r) != 1 || len(r[0]) != 3 || r[0][0] != r[0][2] {
+		t.Fatalf("expected one cycle between the exchanges, got %v", r)
+	}
+	c = newSupplierConfig(t, map[string]string{"ex-a.com": `"ex-a.com"`})
+	r = c.SupplierCycles()
+	if reflect.DeepEqual(r, [][]string{{"ex-a.com", "ex-a.com"}}) == false {
+		t.Fatalf("expected ex-a.com to supply itself, got %v", r)
+	}
+}
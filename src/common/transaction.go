@@ -0,0 +1,222 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Types of transaction store set in the configuration.
+const (
+	TransactionStoreMemory = "memory" // Ring buffer held in memory
+	TransactionStoreFile   = "file"   // Files in the data folder
+)
+
+// transactionStoreSize is the number of transactions each domain keeps in
+// memory.
+const transactionStoreSize = 10000
+
+// transactionPruneInterval is the least time between the removal of the
+// transactions that have passed the retention period from a file store.
+const transactionPruneInterval = time.Hour
+
+// defaultTransactionRetention is how long transactions are kept if the
+// configuration does not provide a period.
+const defaultTransactionRetention = 24 * time.Hour
+
+// TransactionStore keeps the OWID trees of the transactions a domain took part
+// in so they can be audited after the page that contained them has gone.
+type TransactionStore interface {
+	// Add stores the OWID tree as JSON for the base 64 Offer OWID.
+	Add(offerID string, tree []byte) error
+	// Get returns the OWID tree as JSON for the base 64 Offer OWID, or nil if
+	// it is not stored or has passed the retention period.
+	Get(offerID string) ([]byte, error)
+}
+
+// transaction is an entry in the memory store.
+type transaction struct {
+	offerID string
+	tree    []byte
+	created time.Time
+}
+
+// memoryTransactions is a ring buffer of transactions. When full the oldest
+// transaction is replaced.
+type memoryTransactions struct {
+	mutex        sync.Mutex
+	retention    time.Duration
+	transactions []transaction
+	index        map[string]int // Offer ID to position in transactions
+	next         int            // Position of the next transaction to add
+}
+
+// NewMemoryTransactionStore creates a store that keeps the most recent
+// transactions in memory for the retention period.
+func NewMemoryTransactionStore(retention time.Duration) TransactionStore {
+	return &memoryTransactions{
+		retention:    retention,
+		transactions: make([]transaction, transactionStoreSize),
+		index:        make(map[string]int)}
+}
+
+func (s *memoryTransactions) Add(offerID string, tree []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if i, ok := s.index[offerID]; ok {
+		s.transactions[i].tree = tree
+		return nil
+	}
+	o := s.transactions[s.next]
+	if o.offerID != "" {
+		delete(s.index, o.offerID)
+	}
+	s.transactions[s.next] = transaction{offerID, tree, time.Now()}
+	s.index[offerID] = s.next
+	s.next = (s.next + 1) % len(s.transactions)
+	return nil
+}
+
+func (s *memoryTransactions) Get(offerID string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i, ok := s.index[offerID]
+	if ok == false || time.Since(s.transactions[i].created) > s.retention {
+		return nil, nil
+	}
+	return s.transactions[i].tree, nil
+}
+
+// fileTransactions keeps each transaction in a file in a folder. The
+// modification time of the file is used for the retention period.
+type fileTransactions struct {
+	folder    string
+	retention time.Duration
+	mutex     sync.Mutex
+	pruned    time.Time // When expired files were last removed
+}
+
+// NewFileTransactionStore creates a store that keeps transactions as files in
+// the folder for the retention period. Files that have passed the retention
+// period are removed when the store is created and then at most once every
+// transactionPruneInterval as transactions are added. The folder and files
+// can only be read by the owner as the trees contain the bids of all the
+// processors.
+func NewFileTransactionStore(
+	folder string,
+	retention time.Duration) (TransactionStore, error) {
+	err := os.MkdirAll(folder, 0700)
+	if err != nil {
+		return nil, err
+	}
+	s := fileTransactions{
+		folder:    folder,
+		retention: retention,
+		pruned:    time.Now()}
+	err = s.prune(s.pruned)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *fileTransactions) Add(offerID string, tree []byte) error {
+	n := time.Now()
+	s.mutex.Lock()
+	p := n.Sub(s.pruned) >= transactionPruneInterval
+	if p {
+		s.pruned = n
+	}
+	s.mutex.Unlock()
+	if p {
+		err := s.prune(n)
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(s.file(offerID), tree, 0600)
+}
+
+func (s *fileTransactions) Get(offerID string) ([]byte, error) {
+	f := s.file(offerID)
+	i, err := os.Stat(f)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(i.ModTime()) > s.retention {
+		return nil, os.Remove(f)
+	}
+	return ioutil.ReadFile(f)
+}
+
+// prune removes the files that have passed the retention period.
+func (s *fileTransactions) prune(n time.Time) error {
+	f, err := ioutil.ReadDir(s.folder)
+	if err != nil {
+		return err
+	}
+	for _, i := range f {
+		if n.Sub(i.ModTime()) > s.retention {
+			os.Remove(filepath.Join(s.folder, i.Name()))
+		}
+	}
+	return nil
+}
+
+// file returns the path of the file for the Offer ID. Offer IDs are hashed as
+// base 64 can contain characters that are not valid in file names.
+func (s *fileTransactions) file(offerID string) string {
+	return filepath.Join(
+		s.folder,
+		fmt.Sprintf("%x.json", sha256.Sum256([]byte(offerID))))
+}
+
+// newTransactionStore returns the transaction store for the domain from the
+// configuration.
+func (d *Domain) newTransactionStore() (TransactionStore, error) {
+	r := defaultTransactionRetention
+	if d.Config.TransactionRetention != "" {
+		var err error
+		r, err = time.ParseDuration(d.Config.TransactionRetention)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch d.Config.TransactionStore {
+	case "", TransactionStoreMemory:
+		return NewMemoryTransactionStore(r), nil
+	case TransactionStoreFile:
+		if d.Config.DataFolder == "" {
+			return nil, fmt.Errorf(
+				"Transaction store '%s' needs a data folder",
+				TransactionStoreFile)
+		}
+		return NewFileTransactionStore(d.dataFile("transactions"), r)
+	}
+	return nil, fmt.Errorf(
+		"Transaction store '%s' invalid",
+		d.Config.TransactionStore)
+}
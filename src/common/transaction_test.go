@@ -0,0 +1,147 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testOfferID is a base 64 Offer ID containing characters that are not valid
+// in file names or path segments.
+const testOfferID = "AQ/+abc/def=="
+
+// TestMemoryTransactions checks that transactions are returned until the
+// retention period has passed.
+func TestMemoryTransactions(t *testing.T) {
+	s := NewMemoryTransactionStore(time.Hour)
+	err := s.Add(testOfferID, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Get(testOfferID)
+	if err != nil || string(b) != "{}" {
+		t.Fatalf("expected the tree, got '%s' %v", b, err)
+	}
+	if b, _ := s.Get("other"); b != nil {
+		t.Fatal("unknown Offer ID returned a tree")
+	}
+	s = NewMemoryTransactionStore(0)
+	s.Add(testOfferID, []byte("{}"))
+	if b, _ := s.Get(testOfferID); b != nil {
+		t.Fatal("expired transaction returned")
+	}
+}
+
+// TestFileTransactions checks that transactions are stored in files that only
+// the owner can read and that Offer IDs are not used as file names.
+func TestFileTransactions(t *testing.T) {
+	p, err := ioutil.TempDir("", "common")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+	f := filepath.Join(p, "transactions")
+	s, err := NewFileTransactionStore(f, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Add(testOfferID, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Get(testOfferID)
+	if err != nil || string(b) != "{}" {
+		t.Fatalf("expected the tree, got '%s' %v", b, err)
+	}
+	i, err := os.Stat(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Mode().Perm() != 0700 {
+		t.Fatalf("expected folder mode 0700, got %o", i.Mode().Perm())
+	}
+	e, err := ioutil.ReadDir(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e) != 1 {
+		t.Fatalf("expected one file, got %d", len(e))
+	}
+	if e[0].Mode().Perm() != 0600 {
+		t.Fatalf("expected file mode 0600, got %o", e[0].Mode().Perm())
+	}
+	if filepath.Ext(e[0].Name()) != ".json" || len(e[0].Name()) != 69 {
+		t.Fatalf("expected a hashed file name, got '%s'", e[0].Name())
+	}
+}
+
+// TestFileTransactionsRetention checks that expired transactions are removed.
+func TestFileTransactionsRetention(t *testing.T) {
+	p, err := ioutil.TempDir("", "common")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+	s, err := NewFileTransactionStore(p, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add(testOfferID, []byte("{}"))
+	o := time.Now().Add(-2 * time.Hour)
+	e, _ := ioutil.ReadDir(p)
+	os.Chtimes(filepath.Join(p, e[0].Name()), o, o)
+	if b, _ := s.Get(testOfferID); b != nil {
+		t.Fatal("expired transaction returned")
+	}
+	if e, _ := ioutil.ReadDir(p); len(e) != 0 {
+		t.Fatal("expired transaction not removed")
+	}
+}
+
+// TestFileTransactionsPruned checks that expired transactions are removed as
+// new transactions are added even if they are never requested.
+func TestFileTransactionsPruned(t *testing.T) {
+	p, err := ioutil.TempDir("", "common")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(p)
+	s, err := NewFileTransactionStore(p, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add(testOfferID, []byte("{}"))
+	o := time.Now().Add(-2 * time.Hour)
+	e, _ := ioutil.ReadDir(p)
+	os.Chtimes(filepath.Join(p, e[0].Name()), o, o)
+	s.Add("other", []byte("{}"))
+	if e, _ := ioutil.ReadDir(p); len(e) != 2 {
+		t.Fatal("pruned before the prune interval")
+	}
+	s.(*fileTransactions).pruned = o
+	s.Add("another", []byte("{}"))
+	if e, _ := ioutil.ReadDir(p); len(e) != 2 {
+		t.Fatal("expired transaction not pruned")
+	}
+	if b, _ := s.Get(testOfferID); b != nil {
+		t.Fatal("expired transaction returned")
+	}
+}
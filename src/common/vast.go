@@ -0,0 +1,142 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package common
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// vastVersion is the version of the IAB VAST documents served.
+const vastVersion = "4.2"
+
+// VAST is an IAB VAST 4 document containing a single inline linear ad.
+type VAST struct {
+	XMLName xml.Name `xml:"VAST"`
+	Version string   `xml:"version,attr"`
+	Ad      vastAd   `xml:"Ad"`
+}
+
+type vastAd struct {
+	ID     string     `xml:"id,attr"`
+	InLine vastInLine `xml:"InLine"`
+}
+
+type vastInLine struct {
+	AdSystem   string         `xml:"AdSystem"`
+	AdTitle    string         `xml:"AdTitle"`
+	Impression []vastURL      `xml:"Impression"`
+	Creatives  []vastCreative `xml:"Creatives>Creative"`
+}
+
+type vastURL struct {
+	ID  string `xml:"id,attr,omitempty"`
+	URL string `xml:",cdata"`
+}
+
+type vastCreative struct {
+	ID            string          `xml:"id,attr"`
+	AdID          string          `xml:"adId,attr"`
+	UniversalAdID vastUniversalID `xml:"UniversalAdId"`
+	Linear        vastLinear      `xml:"Linear"`
+}
+
+type vastUniversalID struct {
+	Registry string `xml:"idRegistry,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type vastLinear struct {
+	Duration     string          `xml:"Duration"`
+	MediaFiles   []vastMediaFile `xml:"MediaFiles>MediaFile"`
+	ClickThrough vastURL         `xml:"VideoClicks>ClickThrough"`
+}
+
+type vastMediaFile struct {
+	Delivery string `xml:"delivery,attr"`
+	Type     string `xml:"type,attr"`
+	Width    int    `xml:"width,attr"`
+	Height   int    `xml:"height,attr"`
+	URL      string `xml:",cdata"`
+}
+
+// NewVAST returns a VAST document for the video advert. The click through
+// goes to the advertiser.
+func NewVAST(a *Advert, scheme string) *VAST {
+	var v VAST
+	v.Version = vastVersion
+	v.Ad.ID = a.ID
+	v.Ad.InLine.AdSystem = "SWAN Demo"
+	v.Ad.InLine.AdTitle = a.ID
+	var c vastCreative
+	c.ID = a.ID
+	c.AdID = a.ID
+	c.UniversalAdID.Registry = "unknown"
+	c.UniversalAdID.Value = a.ID
+	c.Linear.Duration = fmt.Sprintf(
+		"%02d:%02d:%02d",
+		a.Video.Duration/3600,
+		(a.Video.Duration/60)%60,
+		a.Video.Duration%60)
+	c.Linear.MediaFiles = []vastMediaFile{{
+		Delivery: "progressive",
+		Type:     a.Video.MIMETypeOrDefault(),
+		Width:    a.Width,
+		Height:   a.Height,
+		URL:      a.Video.MediaFile}}
+	c.Linear.ClickThrough.URL = scheme + "://" + a.AdvertiserURL
+	v.Ad.InLine.Creatives = []vastCreative{c}
+	return &v
+}
+
+// ParseVAST returns the VAST document from the XML.
+func ParseVAST(b []byte) (*VAST, error) {
+	var v VAST
+	err := xml.Unmarshal(b, &v)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// AsXML returns the VAST document as XML.
+func (v *VAST) AsXML() ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// MediaFile returns the URL and MIME type of the first media file, or empty
+// strings if there are none.
+func (v *VAST) MediaFile() (string, string) {
+	for _, c := range v.Ad.InLine.Creatives {
+		if len(c.Linear.MediaFiles) > 0 {
+			return c.Linear.MediaFiles[0].URL, c.Linear.MediaFiles[0].Type
+		}
+	}
+	return "", ""
+}
+
+// ClickThrough returns the URL to navigate to when the video is clicked.
+func (v *VAST) ClickThrough() string {
+	if len(v.Ad.InLine.Creatives) == 0 {
+		return ""
+	}
+	return v.Ad.InLine.Creatives[0].Linear.ClickThrough.URL
+}
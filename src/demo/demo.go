@@ -27,6 +27,7 @@ import (
 	"os"
 	"path/filepath"
 	"publisher"
+	"strings"
 	"swan"
 )
 
@@ -52,6 +53,13 @@ func AddHandlers(settingsFile string) {
 	}
 	dc.Domains = domains
 
+	// Warn about suppliers that lead back to the same domain. Processors
+	// refuse to take part twice in a transaction but the configuration should
+	// be fixed.
+	for _, c := range dc.SupplierCycles() {
+		log.Printf("Warning: supplier cycle %s", strings.Join(c, " -> "))
+	}
+
 	// Add the SWAN handlers, with the demo handler being used for any
 	// malformed storage requests.
 	err = swan.AddHandlers(
@@ -125,6 +133,9 @@ func addHandler(d *common.Domain) error {
 	case "Exchange":
 		d.SetHandler(openrtb.Handler)
 		break
+	case "Replay":
+		d.SetHandler(openrtb.Handler)
+		break
 	case "Demo":
 		d.SetHandler(common.HandlerHTML)
 		break
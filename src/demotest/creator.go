@@ -0,0 +1,82 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package demotest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"owid"
+	"time"
+)
+
+// Creator signs OWIDs with a key derived from the domain so that tests can
+// sign and verify OWIDs without the OWID store. A signature made by one
+// creator fails verification by any other.
+type Creator struct {
+	Domain string
+}
+
+// CreateOWID returns a new unsigned OWID for the domain with the payload.
+func (c *Creator) CreateOWID(payload []byte) *owid.OWID {
+	return &owid.OWID{
+		Version: 1,
+		Domain:  c.Domain,
+		Date:    time.Now().UTC(),
+		Payload: payload}
+}
+
+// Sign signs the OWID, including the other OWIDs in the signature.
+func (c *Creator) Sign(o *owid.OWID, others ...*owid.OWID) error {
+	s, err := c.signature(o, others)
+	if err != nil {
+		return err
+	}
+	o.Signature = s
+	return nil
+}
+
+// Verify returns true if the OWID was signed by this creator, including the
+// other OWIDs in the signature.
+func (c *Creator) Verify(o *owid.OWID, others ...*owid.OWID) (bool, error) {
+	s, err := c.signature(o, others)
+	if err != nil {
+		return false, err
+	}
+	return o.Domain == c.Domain && hmac.Equal(s, o.Signature), nil
+}
+
+// signature returns the signature for the OWID without its signature and the
+// other OWIDs. The byte form of the OWID is used so the signature is the same
+// after the OWID has been encoded and decoded.
+func (c *Creator) signature(o *owid.OWID, others []*owid.OWID) ([]byte, error) {
+	h := hmac.New(sha256.New, []byte(c.Domain))
+	u := *o
+	u.Signature = nil
+	b, err := u.AsByteArray()
+	if err != nil {
+		return nil, err
+	}
+	h.Write(b)
+	for _, i := range others {
+		b, err := i.AsByteArray()
+		if err != nil {
+			return nil, err
+		}
+		h.Write(b)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,86 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+// Package demotest provides domains and OWID creators for the tests of the
+// demo packages so that they don't need the OWID store or the network.
+package demotest
+
+import (
+	"common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// NewConfig returns a configuration for tests with no domains.
+func NewConfig() *common.Configuration {
+	return &common.Configuration{Scheme: "http"}
+}
+
+// NewDomain creates the domain for the host from the JSON configuration
+// provided and adds it to the configuration. The domain signs OWIDs with a
+// test Creator. The shared templates named are
+// copied from the www folder so the domain can use them. The folder for the
+// domain is removed when the test finishes.
+func NewDomain(
+	t testing.TB,
+	c *common.Configuration,
+	host string,
+	config string,
+	shared ...string) *common.Domain {
+	t.Helper()
+	p := tempDir(t)
+	for _, s := range shared {
+		b, err := ioutil.ReadFile(filepath.Join("..", "..", "www", s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(p, s), b, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := filepath.Join(p, host)
+	err := os.Mkdir(f, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == "" {
+		config = "{}"
+	}
+	err = ioutil.WriteFile(filepath.Join(f, "config.json"), []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := common.NewDomain(c, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.OWID = &Creator{host}
+	c.Domains = append(c.Domains, d)
+	return d
+}
+
+// tempDir returns a new folder that is removed when the test finishes.
+func tempDir(t testing.TB) string {
+	f, err := ioutil.TempDir("", "demotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(f) })
+	return f
+}
@@ -0,0 +1,61 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package demotest
+
+import (
+	"common"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// server is the address of the test server for the current test. All
+// requests made with the default transport are sent to it.
+var server atomic.Value
+
+// useServer changes the default transport once so that requests to any host
+// are sent to the current test server. The transport is not restored as
+// notices may still be being sent in the background when a test finishes.
+// Connections are not kept alive as the host names are the same for every
+// test server.
+var useServer sync.Once
+
+// Serve starts a test server for the domains of the configuration. Requests
+// to any host made with the default transport are sent to the server so that
+// domains call each other as they would in the demo. The server is closed
+// when the test finishes.
+func Serve(t testing.TB, c *common.Configuration) {
+	t.Helper()
+	s := httptest.NewServer(common.Handler(c.Domains))
+	t.Cleanup(s.Close)
+	server.Store(s.Listener.Addr().String())
+	useServer.Do(func() {
+		http.DefaultTransport = &http.Transport{
+			DisableKeepAlives: true,
+			DialContext: func(
+				x context.Context,
+				network string,
+				addr string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(x, network, server.Load().(string))
+			}}
+	})
+}
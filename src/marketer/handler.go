@@ -21,11 +21,22 @@ import (
 	"encoding/base64"
 	"net/http"
 	"owid"
+	"strings"
 )
 
 // Handler for the marketer features.
 func Handler(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 
+	// Serve the documents for video and native adverts.
+	if strings.HasPrefix(r.URL.Path, "/vast/") {
+		handlerVAST(d, w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/native/") {
+		handlerNative(d, w, r)
+		return
+	}
+
 	// Get the template for the URL path.
 	t := d.LookupHTML(r.URL.Path)
 	if t == nil {
@@ -34,7 +45,7 @@ func Handler(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the SWAN Offer that relates to the advert.
-	o, err := getOffer(r)
+	o, err := getOffer(d, r)
 	if err != nil {
 		common.ReturnServerError(d.Config, w, err)
 		return
@@ -51,7 +62,10 @@ func Handler(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getOffer(r *http.Request) (*owid.Node, error) {
+// getOffer returns the OWID tree from the transaction form data, or if only
+// a transaction reference or Offer ID is provided fetches the winning path
+// of the tree from the publisher.
+func getOffer(d *common.Domain, r *http.Request) (*owid.Node, error) {
 
 	// Parse the form data.
 	err := r.ParseForm()
@@ -59,17 +73,31 @@ func getOffer(r *http.Request) (*owid.Node, error) {
 		return nil, err
 	}
 
+	// If a reference is provided then check it and fetch the tree.
+	if r.Form.Get("reference") != "" {
+		f, err := common.ParseTransactionRef(d.Config, r.Form.Get("reference"))
+		if err != nil {
+			return nil, err
+		}
+		return f.Resolve(d.Config)
+	}
+
+	// If only the Offer ID is provided then fetch the tree.
+	if r.Form.Get("transaction") == "" && r.Form.Get("offerid") != "" {
+		return common.FetchTransaction(d.Config, r.Form.Get("offerid"))
+	}
+
 	// If the bid data does not exist return warning.
 	if r.Form.Get("transaction") == "" {
 		return nil, nil
 	}
 
 	// Get the transaction from the form data.
-	d, err := base64.StdEncoding.DecodeString(
+	b, err := base64.StdEncoding.DecodeString(
 		r.Form.Get("transaction"))
 	if err != nil {
 		return nil, err
 	}
 
-	return owid.NodeFromJSON(d)
+	return owid.NodeFromJSON(b)
 }
@@ -0,0 +1,77 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package marketer
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// handlerVAST returns the VAST document for the video advert in the path.
+func handlerVAST(d *common.Domain, w http.ResponseWriter, r *http.Request) {
+	a, err := getCreative(d, r, ".xml", common.FormatVideo)
+	if err != nil {
+		common.ReturnStatusCodeError(d.Config, w, err, http.StatusNotFound)
+		return
+	}
+	b, err := common.NewVAST(a, d.Config.Scheme).AsXML()
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(b)
+}
+
+// handlerNative returns the assets for the native advert in the path as JSON.
+func handlerNative(d *common.Domain, w http.ResponseWriter, r *http.Request) {
+	a, err := getCreative(d, r, ".json", common.FormatNative)
+	if err != nil {
+		common.ReturnStatusCodeError(d.Config, w, err, http.StatusNotFound)
+		return
+	}
+	b, err := json.Marshal(a.Native)
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(b)
+}
+
+// getCreative returns the advert of the format with the ID in the last
+// segment of the path.
+func getCreative(
+	d *common.Domain,
+	r *http.Request,
+	ext string,
+	format string) (*common.Advert, error) {
+	id := strings.TrimSuffix(path.Base(r.URL.Path), ext)
+	a := d.Creative(id)
+	if a == nil || a.FormatOrDefault() != format {
+		return nil, fmt.Errorf("No %s advert '%s'", format, id)
+	}
+	return a, nil
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"html/template"
 	"owid"
+	"sort"
 	"strings"
 	"swan"
 )
@@ -43,6 +44,47 @@ func (m *MarketerModel) Stop() bool {
 	return false
 }
 
+// eventModel is a delivery event and whether the OWID of the event was signed
+// by the recording domain over the offer.
+type eventModel struct {
+	*common.Event
+	Verified bool
+}
+
+// Events returns the delivery events recorded by the domains in the demo for
+// the offer, in the order they were recorded.
+func (m *MarketerModel) Events() ([]*eventModel, error) {
+	var r []*eventModel
+	if m.offer == nil {
+		return r, nil
+	}
+	o, err := m.offer.GetRoot().GetOWID()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range m.Domain.Config.Domains {
+		for _, e := range d.Events().ForOffer(o.AsString()) {
+			v := false
+			f, err := owid.FromBase64(e.OWID)
+			if err == nil {
+				v, _ = m.Domain.Config.VerifyOWID(f, o)
+			}
+			r = append(r, &eventModel{e, v})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Date.Before(r[j].Date) })
+	return r, nil
+}
+
+// Campaigns returns the campaigns of the advertiser with their live spend.
+func (m *MarketerModel) Campaigns() []*common.Campaign {
+	var c []*common.Campaign
+	for i := range m.Domain.Campaigns {
+		c = append(c, &m.Domain.Campaigns[i])
+	}
+	return c
+}
+
 // TreeAsJSON return the transaction as JSON.
 func (m *MarketerModel) TreeAsJSON() (template.HTML, error) {
 	if m.offer == nil {
@@ -144,11 +186,15 @@ func (m *MarketerModel) AuditWinnerHTML() (template.HTML, error) {
 		return "", nil
 	}
 	htmlAddHeader(&html)
-	err = appendParents(&html, w)
+	err = appendParents(&html, w, m.cmp())
 	if err != nil {
 		return "", err
 	}
 	htmlAddFooter(&html)
+	err = appendSupplyChain(&html, w)
+	if err != nil {
+		return "", err
+	}
 	return template.HTML(html.String()), nil
 }
 
@@ -166,7 +212,7 @@ func (m *MarketerModel) AuditFullHTML() (template.HTML, error) {
 
 	var html bytes.Buffer
 	htmlAddHeader(&html)
-	err = appendOWIDAndChildren(&html, m.offer, w, 0)
+	err = appendOWIDAndChildren(&html, m.offer, w, 0, m.cmp())
 	if err != nil {
 		return template.HTML("<p>" + err.Error() + "</p>"), nil
 	}
@@ -174,6 +220,20 @@ func (m *MarketerModel) AuditFullHTML() (template.HTML, error) {
 	return template.HTML(html.String()), nil
 }
 
+// cmp returns the host of the CMP used by the publisher of the offer. This is
+// where complaints about the organizations in the transaction are made.
+func (m *MarketerModel) cmp() string {
+	o, err := swan.OfferFromNode(m.offer)
+	if err != nil {
+		return ""
+	}
+	p := m.Domain.Config.FindDomain(o.PubDomain)
+	if p == nil {
+		return ""
+	}
+	return p.CMP
+}
+
 func convertToString(b []byte) string {
 	return fmt.Sprintf("%x", b)
 }
@@ -192,7 +252,7 @@ func htmlAddFooter(html *bytes.Buffer) {
 	html.WriteString("</tbody>\r\n</table>\r\n")
 }
 
-func appendParents(html *bytes.Buffer, w *owid.Node) error {
+func appendParents(html *bytes.Buffer, w *owid.Node, cmp string) error {
 	var n []*owid.Node
 	p := w
 	for p != nil {
@@ -201,7 +261,7 @@ func appendParents(html *bytes.Buffer, w *owid.Node) error {
 	}
 	i := len(n) - 1
 	for i >= 0 {
-		err := appendHTML(html, w, n[i], 0)
+		err := appendHTML(html, w, n[i], 0, cmp)
 		if err != nil {
 			return err
 		}
@@ -210,15 +270,71 @@ func appendParents(html *bytes.Buffer, w *owid.Node) error {
 	return nil
 }
 
+// appendSupplyChain adds the prices received and passed on by each processor
+// between the winning bid and the publisher, and the percentage of the
+// advertiser's spend that reached the publisher.
+func appendSupplyChain(html *bytes.Buffer, w *owid.Node) error {
+	var n []*owid.Node
+	var h []*common.Hop
+	for p := w.GetParent(); p != nil; p = p.GetParent() {
+		s, err := common.PayloadFromNode(p)
+		if err != nil {
+			return err
+		}
+		if b, ok := s.(*common.Bid); ok && b.Hop != nil {
+			n = append(n, p)
+			h = append(h, b.Hop)
+		}
+	}
+	if len(h) == 0 {
+		return nil
+	}
+	html.WriteString("<table class=\"table\">\r\n")
+	html.WriteString("<thead>\r\n<tr>\r\n")
+	html.WriteString("<th>Organization</th>\r\n")
+	html.WriteString("<th>Received</th>\r\n")
+	html.WriteString("<th>Passed on</th>\r\n")
+	html.WriteString("<th>Fee</th>\r\n")
+	html.WriteString("</tr>\r\n</thead>\r\n<tbody>\r\n")
+	for i := len(h) - 1; i >= 0; i-- {
+		html.WriteString(fmt.Sprintf(
+			"<tr>\r\n<td>\r\n<script>new owid().appendName("+
+				"document.currentScript.parentNode,\"%s\")</script></td>\r\n"+
+				"<td>%.2f</td>\r\n<td>%.2f</td>\r\n<td>%.1f%%</td>\r\n</tr>\r\n",
+			n[i].GetOWIDAsString(),
+			h[i].Received,
+			h[i].Passed,
+			h[i].Fee()))
+	}
+	htmlAddFooter(html)
+
+	html.WriteString(fmt.Sprintf(
+		"<p>%.1f%% of the advertiser's spend reached the publisher.</p>\r\n",
+		publisherShare(h)))
+	return nil
+}
+
+// publisherShare returns the percentage of the advertiser's spend that reached
+// the publisher. The first hop is the processor the winning bid was sent to so
+// received the advertiser's spend. The last hop is the processor the publisher
+// called so passed on the price the publisher received.
+func publisherShare(h []*common.Hop) float64 {
+	if len(h) == 0 || h[0].Received == 0 {
+		return 0
+	}
+	return h[len(h)-1].Passed * 100 / h[0].Received
+}
+
 func appendOWIDAndChildren(
 	html *bytes.Buffer,
 	o *owid.Node,
 	w *owid.Node,
-	level int) error {
-	appendHTML(html, w, o, level)
+	level int,
+	cmp string) error {
+	appendHTML(html, w, o, level, cmp)
 	if len(o.Children) > 0 {
 		for _, c := range o.Children {
-			err := appendOWIDAndChildren(html, c, w, level+1)
+			err := appendOWIDAndChildren(html, c, w, level+1, cmp)
 			if err != nil {
 				return err
 			}
@@ -231,9 +347,10 @@ func appendHTML(
 	html *bytes.Buffer,
 	w *owid.Node,
 	o *owid.Node,
-	level int) error {
+	level int,
+	cmp string) error {
 
-	s, err := swan.FromNode(o)
+	s, err := common.PayloadFromNode(o)
 	if err != nil {
 		return err
 	}
@@ -260,7 +377,7 @@ func appendHTML(
 		html.WriteString("<td>\r\n<img style=\"width:32px\" src=\"noun_rosette_470370.svg\">\r\n</td>\r\n")
 	} else {
 		f, fok := s.(*swan.Failed)
-		_, bok := s.(*swan.Bid)
+		_, bok := s.(*common.Bid)
 		if fok {
 			html.WriteString(fmt.Sprintf("<td style=\"color:lightpink\">\r\n%s&nbsp;%s</td>\r\n",
 				f.Host,
@@ -275,8 +392,9 @@ func appendHTML(
 	if o != nil && r != "" {
 		html.WriteString(fmt.Sprintf(
 			"<td style=\"text-align:center;\">\r\n"+
-				"<script>new owid().appendComplaintEmail(document.currentScript.parentNode,\"%s\",\"%s\", \"noun_complaint_376466.svg\");</script>\r\n"+
+				"<script>new owid().appendComplaint(document.currentScript.parentNode,\"%s\",\"%s\",\"%s\", \"noun_complaint_376466.svg\");</script>\r\n"+
 				"<noscript>JavaScript needed to audit</noscript></td>\r\n",
+			cmp,
 			r,
 			o.GetOWIDAsString()))
 	} else {
@@ -0,0 +1,96 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package marketer
+
+import (
+	"bytes"
+	"common"
+	"demotest"
+	"openrtb"
+	"owid"
+	"strings"
+	"swan"
+	"testing"
+)
+
+// TestPublisherShare checks the percentage of the advertiser's spend that
+// reached the publisher after the fee of each processor.
+func TestPublisherShare(t *testing.T) {
+	h := []*common.Hop{common.NewHop(10, 0.5), common.NewHop(5, 0.2)}
+	if s := publisherShare(h); s != 40 {
+		t.Fatalf("expected 40%%, got %v", s)
+	}
+	if s := publisherShare([]*common.Hop{common.NewHop(2, 0)}); s != 100 {
+		t.Fatalf("expected 100%%, got %v", s)
+	}
+	if s := publisherShare(nil); s != 0 {
+		t.Fatalf("expected 0%%, got %v", s)
+	}
+}
+
+// TestSupplyChainAudit checks that the audit of a transaction shows the fee
+// of each processor between the winning bid and the publisher, and the share
+// of the spend that reached the publisher.
+func TestSupplyChainAudit(t *testing.T) {
+	c := demotest.NewConfig()
+	for h, j := range map[string]string{
+		"pub.com":      `{"suppliers": ["ssp.com"]}`,
+		"ssp.com":      `{"suppliers": ["exchange.com"], "takeRate": 0.2}`,
+		"exchange.com": `{"suppliers": ["dsp.com"], "takeRate": 0.5}`,
+		"dsp.com":      `{"adverts": [{"advertiserURL": "adv.com", "cpm": 10}]}`} {
+		demotest.NewDomain(t, c, h, j).SetHandler(openrtb.Handler)
+	}
+	demotest.Serve(t, c)
+	p := c.FindDomain("pub.com")
+	o := swan.Offer{PubDomain: p.Host, UUID: make([]byte, 16)}
+	b, err := o.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := p.OWID.CreateOWID(b)
+	err = p.OWID.Sign(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root owid.Node
+	root.OWID, err = r.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := openrtb.HandleTransaction(p, &root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := swan.WinningNode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var html bytes.Buffer
+	err = appendSupplyChain(&html, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := html.String()
+	for _, e := range []string{
+		"<td>10.00</td>\r\n<td>5.00</td>\r\n<td>50.0%</td>",
+		"<td>5.00</td>\r\n<td>4.00</td>\r\n<td>20.0%</td>",
+		"40.0% of the advertiser's spend reached the publisher."} {
+		if strings.Contains(s, e) == false {
+			t.Fatalf("expected '%s' in\n%s", e, s)
+		}
+	}
+}
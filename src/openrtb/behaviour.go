@@ -0,0 +1,300 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"fmt"
+	"owid"
+	"swan"
+	"time"
+)
+
+// Settings used by the bad actor behaviours.
+const (
+	spoofedPubDomain = "high-value-pub.com" // Publisher domain when spoofed
+	fakeHopDomain    = "ghost-exchange.com" // Domain of the fake intermediary
+	inflateFactor    = 1.5                  // Multiplier for inflated prices
+)
+
+// slowResponseDelay is the delay for slow responses. It is longer than the
+// time any caller waits for its suppliers.
+var slowResponseDelay = 2 * bidTimeout
+
+// behaviour is a set of hooks called while a processor handles a transaction
+// to implement one of the bad actor behaviours. Hooks that are nil are not
+// called.
+type behaviour struct {
+
+	// received is called with the tree from the caller before it is used.
+	received func(d *common.Domain, n *owid.Node) error
+
+	// merged is called with this processor's node and the bid fields of the
+	// children after the suppliers have responded and before the auction.
+	merged func(
+		d *common.Domain,
+		n *owid.Node,
+		b []*notice) ([]*notice, error)
+
+	// decided is called with this processor's node and OWID, the index of
+	// the winning child, the root OWID, and the prices of the hop after a
+	// supplier has won the auction.
+	decided func(
+		d *common.Domain,
+		n *owid.Node,
+		w int,
+		t *owid.OWID,
+		r *owid.OWID,
+		h *common.Hop) error
+
+	// ignoreStops is true if the stop list in the offer is not used.
+	ignoreStops bool
+}
+
+// behaviours are the hooks for each of the behaviour names.
+var behaviours = map[string]*behaviour{
+	common.BehaviourSpoofPubDomain: {received: spoofPubDomain},
+	common.BehaviourStripCBID:      {received: stripCBID},
+	common.BehaviourSlowResponse:   {received: slowResponse},
+	common.BehaviourDropBid:        {merged: dropBid},
+	common.BehaviourForgeSignature: {merged: forgeSignature},
+	common.BehaviourInflatePrice:   {decided: inflatePrice},
+	common.BehaviourFakeHop:        {decided: fakeHop},
+	common.BehaviourIgnoreStops:    {ignoreStops: true}}
+
+// behavioursOf returns the hooks for the behaviours of the domain.
+func behavioursOf(d *common.Domain) []*behaviour {
+	var b []*behaviour
+	for _, e := range d.Behaviours {
+		if h, ok := behaviours[e]; ok {
+			b = append(b, h)
+		}
+	}
+	return b
+}
+
+// onReceived calls the received hooks of the domain's behaviours.
+func onReceived(d *common.Domain, n *owid.Node) error {
+	for _, h := range behavioursOf(d) {
+		if h.received != nil {
+			err := h.received(d, n)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// onMerged calls the merged hooks of the domain's behaviours.
+func onMerged(
+	d *common.Domain,
+	n *owid.Node,
+	b []*notice) ([]*notice, error) {
+	var err error
+	for _, h := range behavioursOf(d) {
+		if h.merged != nil {
+			b, err = h.merged(d, n, b)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// onDecided calls the decided hooks of the domain's behaviours.
+func onDecided(
+	d *common.Domain,
+	n *owid.Node,
+	w int,
+	t *owid.OWID,
+	r *owid.OWID,
+	p *common.Hop) error {
+	for _, h := range behavioursOf(d) {
+		if h.decided != nil {
+			err := h.decided(d, n, w, t, r, p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stopList returns the stop list from the offer, or an empty list if the
+// domain ignores stops.
+func stopList(d *common.Domain, o *swan.Offer) common.StopList {
+	for _, h := range behavioursOf(d) {
+		if h.ignoreStops {
+			return nil
+		}
+	}
+	return common.NewStopList(o.StoppedAsArray())
+}
+
+// spoofPubDomain changes the publisher's domain to one that would generate
+// more money from advertising. Suppliers sign over the changed Offer so their
+// OWIDs fail verification against the publisher's Offer.
+func spoofPubDomain(d *common.Domain, n *owid.Node) error {
+	return changePubDomain(n, spoofedPubDomain)
+}
+
+// stripCBID removes the CBID from the Offer so suppliers can't honour the
+// user's frequency caps or preferences linked to it.
+func stripCBID(d *common.Domain, n *owid.Node) error {
+	return updateOffer(n, func(o *swan.Offer) { o.CBID = nil })
+}
+
+// slowResponse delays the handling of the transaction.
+func slowResponse(d *common.Domain, n *owid.Node) error {
+	time.Sleep(slowResponseDelay)
+	return nil
+}
+
+// dropBid removes the first child with a bid so the supplier can't win. The
+// supplier's stored transaction shows it bid.
+func dropBid(
+	d *common.Domain,
+	n *owid.Node,
+	b []*notice) ([]*notice, error) {
+	for i, c := range n.Children {
+		ok, err := isBid(c)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			n.Children = append(n.Children[:i], n.Children[i+1:]...)
+			return append(b[:i], b[i+1:]...), nil
+		}
+	}
+	return b, nil
+}
+
+// forgeSignature signs the first child's OWID with this domain's key while
+// leaving the child's domain unchanged. The signature fails verification with
+// the child's public key.
+func forgeSignature(
+	d *common.Domain,
+	n *owid.Node,
+	b []*notice) ([]*notice, error) {
+	if len(n.Children) == 0 {
+		return b, nil
+	}
+	c := n.Children[0]
+	o, err := c.GetOWID()
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.GetRoot().GetOWID()
+	if err != nil {
+		return nil, err
+	}
+	e := o.Domain
+	err = d.OWID.Sign(o, r)
+	if err != nil {
+		return nil, err
+	}
+	o.Domain = e
+	c.OWID, err = o.AsByteArray()
+	return b, err
+}
+
+// inflatePrice offers the parent a higher price than the price passed on in
+// the signed hop. The bid's price doesn't match its own hop and the parent's
+// hop shows it received more than this processor passed on.
+func inflatePrice(
+	d *common.Domain,
+	n *owid.Node,
+	w int,
+	t *owid.OWID,
+	r *owid.OWID,
+	h *common.Hop) error {
+	p, err := common.PayloadFromOWID(t)
+	if err != nil {
+		return err
+	}
+	b, ok := p.(*common.Bid)
+	if ok == false {
+		return nil
+	}
+	b.Price *= inflateFactor
+	t.Payload, err = b.AsByteArray()
+	if err != nil {
+		return err
+	}
+	err = d.OWID.Sign(t, r)
+	if err != nil {
+		return err
+	}
+	n.OWID, err = t.AsByteArray()
+	return err
+}
+
+// fakeHop inserts a node for an intermediary that was not involved between
+// this processor and the winning child. The node is signed by this domain but
+// claims to be from the fake domain so it fails verification.
+func fakeHop(
+	d *common.Domain,
+	n *owid.Node,
+	w int,
+	t *owid.OWID,
+	r *owid.OWID,
+	h *common.Hop) error {
+	p, err := empty.AsByteArray()
+	if err != nil {
+		return err
+	}
+	f := d.OWID.CreateOWID(p)
+	if f == nil {
+		return fmt.Errorf("Could not create new OWID")
+	}
+	err = d.OWID.Sign(f, r)
+	if err != nil {
+		return err
+	}
+	f.Domain = fakeHopDomain
+	var g owid.Node
+	g.OWID, err = f.AsByteArray()
+	if err != nil {
+		return err
+	}
+	_, err = g.AddChild(n.Children[w])
+	if err != nil {
+		return err
+	}
+	g.Value = 0
+	return replaceChild(n, w, &g)
+}
+
+// replaceChild makes the node the child of n at index i in place of the
+// existing child. The children are added to n again in the same order so each
+// one's parent is n.
+func replaceChild(n *owid.Node, i int, c *owid.Node) error {
+	e := n.Children
+	n.Children = nil
+	for j, h := range e {
+		if j == i {
+			h = c
+		}
+		_, err := n.AddChild(h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
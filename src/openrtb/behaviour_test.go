@@ -0,0 +1,320 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"owid"
+	"reflect"
+	"swan"
+	"testing"
+	"time"
+)
+
+// unverified returns the domains of the nodes below the root of the tree that
+// were not signed by their domain over the root, in the order an audit by the
+// publisher would find them.
+func unverified(t *testing.T, c *common.Configuration, n *owid.Node) []string {
+	t.Helper()
+	r, err := n.GetRoot().GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d []string
+	var walk func(n *owid.Node)
+	walk = func(n *owid.Node) {
+		for _, i := range n.Children {
+			o, err := i.GetOWID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := c.VerifyOWID(o, r)
+			if err != nil || v == false {
+				d = append(d, o.Domain)
+			}
+			walk(i)
+		}
+	}
+	walk(n.GetRoot())
+	return d
+}
+
+// hopOf returns the signed bid of the node, which includes the hop if the
+// node is a processor whose supplier won.
+func hopOf(t *testing.T, n *owid.Node) *common.Bid {
+	t.Helper()
+	p, err := common.PayloadFromNode(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, ok := p.(*common.Bid)
+	if ok == false || b.Hop == nil {
+		t.Fatal("node has no hop")
+	}
+	return b
+}
+
+// TestSpoofPubDomain checks that the publisher finds the nodes from the
+// exchange that changed the publisher domain, and its suppliers, were not
+// signed over its offer.
+func TestSpoofPubDomain(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["exchange.com", "dsp-a.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp-b.com"],
+			"behaviours": ["spoof-pub-domain"]}`,
+		"dsp-a.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`,
+		"dsp-b.com": `{"adverts": [{"advertiserURL": "b.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{})
+	d := unverified(t, c, n)
+	if reflect.DeepEqual(d, []string{"exchange.com", "dsp-b.com"}) == false {
+		t.Fatalf("expected the spoofing exchange to be found, got %v", d)
+	}
+}
+
+// TestStripCBID checks that the publisher finds the nodes from the exchange
+// that removed the CBID, and its suppliers, were not signed over its offer.
+func TestStripCBID(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["exchange.com", "dsp-a.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp-b.com"],
+			"behaviours": ["strip-cbid"]}`,
+		"dsp-a.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`,
+		"dsp-b.com": `{"adverts": [{"advertiserURL": "b.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{CBID: []byte("cbid")})
+	d := unverified(t, c, n)
+	if reflect.DeepEqual(d, []string{"exchange.com", "dsp-b.com"}) == false {
+		t.Fatalf("expected the exchange stripping the CBID, got %v", d)
+	}
+}
+
+// TestSlowResponse checks that the publisher stops waiting for a slow
+// supplier and adds a failed node for it so the audit shows why it was left
+// out.
+func TestSlowResponse(t *testing.T) {
+	b, s := bidTimeout, slowResponseDelay
+	t.Cleanup(func() { bidTimeout, slowResponseDelay = b, s })
+	bidTimeout = 200 * time.Millisecond
+	slowResponseDelay = 2 * bidTimeout
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["slow.com", "dsp.com"]}`,
+		"slow.com": `{"adverts": [{"advertiserURL": "s.com", "cpm": 5}],
+			"behaviours": ["slow-response"]}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{})
+	f := failedFrom(t, c, n, "pub.com", "slow.com")
+	if f == nil || f.Error != timedOut {
+		t.Fatal("slow supplier should have a failed node")
+	}
+	a, err := bidOf(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == nil || a.AdvertiserURL != "a.com" {
+		t.Fatalf("expected the bid from dsp.com to win, got %+v", a)
+	}
+}
+
+// TestSupplierTimeout checks that processors further down the chain give
+// their suppliers less time than their caller gives them.
+func TestSupplierTimeout(t *testing.T) {
+	r, p := newOfferTree(t)
+	e := newNode(t, "exchange.com", nil)
+	p.AddChild(e)
+	a, err := supplierTimeout(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := supplierTimeout(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != bidTimeout || b >= a {
+		t.Fatalf("expected %v then less, got %v and %v", bidTimeout, a, b)
+	}
+	if _, err = supplierTimeout(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDropBid checks that the supplier whose bid was dropped by the exchange
+// keeps a signed record of its bid that is missing from the publisher's tree.
+func TestDropBid(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["exchange.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp.com"],
+			"behaviours": ["drop-bid"]}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{})
+	if e := childFrom(t, n, "exchange.com"); e == nil ||
+		childFrom(t, e, "dsp.com") != nil {
+		t.Fatal("exchange should have dropped the bid")
+	}
+	r, err := n.GetRoot().GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.FindDomain("dsp.com").Transactions().Get(r.AsString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatal("supplier should have stored the transaction")
+	}
+	s, err := owid.NodeFromJSON(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := childFrom(t, childFrom(t, s, "pub.com"), "exchange.com")
+	if e == nil {
+		t.Fatal("stored transaction should include the exchange")
+	}
+	d := childFrom(t, e, "dsp.com")
+	if d == nil {
+		t.Fatal("stored transaction should include the supplier")
+	}
+	if a, err := bidOf(d); err != nil || a == nil {
+		t.Fatalf("stored transaction should include the bid, got %v", err)
+	}
+	if v := unverified(t, c, s); len(v) > 0 {
+		t.Fatalf("stored transaction should verify, got %v", v)
+	}
+}
+
+// TestForgeSignature checks that the publisher finds the node the exchange
+// signed in place of its supplier.
+func TestForgeSignature(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["exchange.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp.com"],
+			"behaviours": ["forge-signature"]}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{})
+	d := unverified(t, c, n)
+	if reflect.DeepEqual(d, []string{"dsp.com"}) == false {
+		t.Fatalf("expected the forged node to be found, got %v", d)
+	}
+}
+
+// TestInflatePrice checks that the publisher finds it received a higher price
+// from the exchange than the exchange's signed hop says it passed on.
+func TestInflatePrice(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["exchange.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp.com"], "takeRate": 0.2,
+			"behaviours": ["inflate-price"]}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{})
+	if d := unverified(t, c, n); len(d) > 0 {
+		t.Fatalf("inflated price should be signed, got %v", d)
+	}
+	p := hopOf(t, n)
+	e := hopOf(t, childFrom(t, n, "exchange.com"))
+	if e.Price == e.Hop.Passed {
+		t.Fatal("exchange should offer more than it passed on")
+	}
+	if p.Hop.Received <= e.Hop.Passed {
+		t.Fatalf(
+			"publisher received %f, exchange passed on %f",
+			p.Hop.Received,
+			e.Hop.Passed)
+	}
+}
+
+// TestFakeHop checks that the publisher finds the node of the intermediary
+// that was not involved, and that the supplier's node is still verified.
+func TestFakeHop(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["exchange.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp.com"],
+			"behaviours": ["fake-hop"]}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{})
+	d := unverified(t, c, n)
+	if reflect.DeepEqual(d, []string{fakeHopDomain}) == false {
+		t.Fatalf("expected the fake hop to be found, got %v", d)
+	}
+	g := childFrom(t, childFrom(t, n, "exchange.com"), fakeHopDomain)
+	if g == nil || childFrom(t, g, "dsp.com") == nil {
+		t.Fatal("fake hop should be between the exchange and the supplier")
+	}
+}
+
+// TestFakeHopParents checks that the fake hop is the parent of the winning
+// child, the processor is the parent of the fake hop, and the other children
+// keep their place and parent.
+func TestFakeHopParents(t *testing.T) {
+	c := newNetwork(t, map[string]string{"exchange.com": `{}`})
+	r, n := newOfferTree(t)
+	o, err := r.GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newNode(t, "dsp-a.com", nil)
+	b := newNode(t, "dsp-b.com", nil)
+	n.AddChild(a)
+	n.AddChild(b)
+	err = fakeHop(c.FindDomain("exchange.com"), n, 0, nil, o, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Children) != 2 || n.Children[1] != b || b.GetParent() != n {
+		t.Fatal("other child should keep its place and parent")
+	}
+	g := n.Children[0]
+	if g.GetParent() != n {
+		t.Fatal("processor should be the parent of the fake hop")
+	}
+	if len(g.Children) != 1 || g.Children[0] != a || a.GetParent() != g {
+		t.Fatal("fake hop should be the parent of the winning child")
+	}
+	d, err := pathDomains(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := []string{"pub.com", fakeHopDomain, "dsp-a.com"}
+	if reflect.DeepEqual(d, e) == false {
+		t.Fatalf("expected path %v, got %v", e, d)
+	}
+}
+
+// TestIgnoreStops checks that the publisher finds the node of the stopped
+// supplier the exchange called and doesn't let the exchange's bid for it win.
+func TestIgnoreStops(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["exchange.com", "dsp-a.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp-b.com"],
+			"behaviours": ["ignore-stops"]}`,
+		"dsp-a.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`,
+		"dsp-b.com": `{"adverts": [{"advertiserURL": "b.com", "cpm": 2}]}`})
+	o := &swan.Offer{Stopped: []string{"dsp-b.com|supplier"}}
+	n := transact(t, c, o)
+	e := childFrom(t, n, "exchange.com")
+	if e == nil {
+		t.Fatal("exchange missing")
+	}
+	if failedFrom(t, c, e, "exchange.com", "dsp-b.com") != nil ||
+		childFrom(t, e, "dsp-b.com") == nil {
+		t.Fatal("exchange should have called the stopped supplier")
+	}
+	b, err := bidOf(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil || b.AdvertiserURL != "a.com" {
+		t.Fatalf("expected the bid from dsp-a.com to win, got %+v", b)
+	}
+}
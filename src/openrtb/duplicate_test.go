@@ -0,0 +1,76 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"swan"
+	"testing"
+)
+
+// TestDuplicateOffer checks that a DSP reached through two supply paths only
+// bids once and returns a failed node it signed for the second request, so
+// it can't win against itself.
+func TestDuplicateOffer(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":   `{"suppliers": ["ssp-a.com", "ssp-b.com"]}`,
+		"ssp-a.com": `{"suppliers": ["dsp.com"]}`,
+		"ssp-b.com": `{"suppliers": ["dsp.com"]}`,
+		"dsp.com":   `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{})
+	b := 0
+	f := 0
+	for _, s := range []string{"ssp-a.com", "ssp-b.com"} {
+		p := childFrom(t, n, s)
+		if p == nil {
+			t.Fatalf("%s missing", s)
+		}
+		if x := failedFrom(t, c, p, "dsp.com", "dsp.com"); x != nil {
+			if x.Error != duplicateOffer {
+				t.Fatalf("expected a duplicate failure, got '%s'", x.Error)
+			}
+			f++
+		} else if childFrom(t, p, "dsp.com") != nil {
+			b++
+		}
+	}
+	if b != 1 || f != 1 {
+		t.Fatalf("expected one bid and one duplicate, got %d and %d", b, f)
+	}
+	if a := c.FindDomain("dsp.com").Auctions(); a.Bids() != 1 {
+		t.Fatalf("expected dsp.com to bid once, got %d", a.Bids())
+	}
+}
+
+// TestSupplyPathReport checks that a DSP's wins are reported against the
+// path of processors that delivered the bid request.
+func TestSupplyPathReport(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["ssp.com"]}`,
+		"ssp.com": `{"suppliers": ["dsp.com"]}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 2}]}`})
+	for i := 0; i < 2; i++ {
+		transact(t, c, &swan.Offer{})
+	}
+	notices.Wait()
+	p := c.FindDomain("dsp.com").Auctions().SupplyPaths()
+	if len(p) != 1 ||
+		p[0].Path != "pub.com > ssp.com" ||
+		p[0].Wins != 2 ||
+		p[0].CPM() != 2 {
+		t.Fatalf("expected 2 wins at 2 through ssp.com, got %+v", p)
+	}
+}
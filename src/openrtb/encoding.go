@@ -0,0 +1,299 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"owid"
+	"strings"
+)
+
+// Media types used for OWID trees exchanged between processors.
+const (
+	mediaTypeJSON = "application/json"
+	mediaTypeCBOR = "application/cbor"
+)
+
+// CBOR major types used to encode OWID trees.
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborArray    = 4
+	cborNull     = 0xf6
+)
+
+// cborNodeFields is the number of items in the CBOR array for a node.
+const cborNodeFields = 3
+
+// encodeNode returns the tree in the media type. CBOR encodes each node as an
+// array of the OWID as a byte string, the value as an integer or null, and an
+// array of the children. Unlike JSON the OWIDs are not base 64 encoded.
+func encodeNode(n *owid.Node, mediaType string) ([]byte, error) {
+	if mediaType != mediaTypeCBOR {
+		return n.AsJSON()
+	}
+	var b bytes.Buffer
+	err := writeCBORNode(&b, n)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decodeNode returns the tree from the data in the media type.
+func decodeNode(b []byte, mediaType string) (*owid.Node, error) {
+	if mediaType != mediaTypeCBOR {
+		return owid.NodeFromJSON(b)
+	}
+	r := cborReader{data: b}
+	n, err := r.node(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.data) {
+		return nil, fmt.Errorf("CBOR tree has %d extra bytes", len(b)-r.pos)
+	}
+	return n, nil
+}
+
+// contentType returns the media type of the body of the request or response
+// headers, JSON if not set.
+func contentType(h http.Header) string {
+	m, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || m == "" {
+		return mediaTypeJSON
+	}
+	return m
+}
+
+// acceptedType returns CBOR if the caller accepts it, otherwise JSON.
+func acceptedType(r *http.Request) string {
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		m, _, err := mime.ParseMediaType(strings.TrimSpace(a))
+		if err == nil && m == mediaTypeCBOR {
+			return mediaTypeCBOR
+		}
+	}
+	return mediaTypeJSON
+}
+
+// readBody returns the body of the request decompressing it if needed. Bodies
+// larger than maxBodySize after decompression are rejected.
+func readBody(r *http.Request) ([]byte, error) {
+	var b io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		g, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer g.Close()
+		b = g
+	}
+	d, err := ioutil.ReadAll(io.LimitReader(b, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(d) > maxBodySize {
+		return nil, fmt.Errorf("Body larger than %d bytes", maxBodySize)
+	}
+	return d, nil
+}
+
+// compress returns the data compressed with gzip.
+func compress(b []byte) ([]byte, error) {
+	var c bytes.Buffer
+	g := gzip.NewWriter(&c)
+	_, err := g.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	err = g.Close()
+	if err != nil {
+		return nil, err
+	}
+	return c.Bytes(), nil
+}
+
+func writeCBORNode(b *bytes.Buffer, n *owid.Node) error {
+	writeCBORHead(b, cborArray, cborNodeFields)
+	writeCBORHead(b, cborBytes, uint64(len(n.OWID)))
+	b.Write(n.OWID)
+	switch v := n.Value.(type) {
+	case nil:
+		b.WriteByte(cborNull)
+	case int:
+		writeCBORInt(b, int64(v))
+	case float64:
+		if v != math.Trunc(v) {
+			return fmt.Errorf("Node value '%f' not an integer", v)
+		}
+		writeCBORInt(b, int64(v))
+	default:
+		return fmt.Errorf("Node value type '%T' not supported", v)
+	}
+	writeCBORHead(b, cborArray, uint64(len(n.Children)))
+	for _, c := range n.Children {
+		err := writeCBORNode(b, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCBORInt(b *bytes.Buffer, v int64) {
+	if v < 0 {
+		writeCBORHead(b, cborNegative, uint64(-1-v))
+	} else {
+		writeCBORHead(b, cborUnsigned, uint64(v))
+	}
+}
+
+// writeCBORHead writes the major type and argument using the shortest form.
+func writeCBORHead(b *bytes.Buffer, major byte, v uint64) {
+	m := major << 5
+	switch {
+	case v < 24:
+		b.WriteByte(m | byte(v))
+	case v <= math.MaxUint8:
+		b.WriteByte(m | 24)
+		b.WriteByte(byte(v))
+	case v <= math.MaxUint16:
+		b.WriteByte(m | 25)
+		binary.Write(b, binary.BigEndian, uint16(v))
+	case v <= math.MaxUint32:
+		b.WriteByte(m | 26)
+		binary.Write(b, binary.BigEndian, uint32(v))
+	default:
+		b.WriteByte(m | 27)
+		binary.Write(b, binary.BigEndian, v)
+	}
+}
+
+// cborReader decodes the subset of CBOR written by writeCBORNode.
+type cborReader struct {
+	data []byte
+	pos  int
+}
+
+// node reads a node and its children. Values are returned as float64 to
+// match trees decoded from JSON.
+func (r *cborReader) node(depth int) (*owid.Node, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("Tree deeper than %d", maxDepth)
+	}
+	err := r.expect(cborArray, cborNodeFields)
+	if err != nil {
+		return nil, err
+	}
+	var n owid.Node
+	m, l, err := r.head()
+	if err != nil {
+		return nil, err
+	}
+	if m != cborBytes || l > uint64(len(r.data)-r.pos) {
+		return nil, fmt.Errorf("CBOR node OWID invalid")
+	}
+	n.OWID = r.data[r.pos : r.pos+int(l)]
+	r.pos += int(l)
+	if r.pos < len(r.data) && r.data[r.pos] == cborNull {
+		r.pos++
+	} else {
+		m, v, err := r.head()
+		if err != nil {
+			return nil, err
+		}
+		switch m {
+		case cborUnsigned:
+			n.Value = float64(v)
+		case cborNegative:
+			n.Value = -1 - float64(v)
+		default:
+			return nil, fmt.Errorf("CBOR node value invalid")
+		}
+	}
+	m, c, err := r.head()
+	if err != nil {
+		return nil, err
+	}
+	if m != cborArray || c > maxChildren {
+		return nil, fmt.Errorf("CBOR node children invalid")
+	}
+	for i := uint64(0); i < c; i++ {
+		h, err := r.node(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		n.AddChild(h)
+	}
+	return &n, nil
+}
+
+func (r *cborReader) expect(major byte, v uint64) error {
+	m, a, err := r.head()
+	if err != nil {
+		return err
+	}
+	if m != major || a != v {
+		return fmt.Errorf("CBOR item %d:%d not %d:%d", m, a, major, v)
+	}
+	return nil
+}
+
+// head reads the major type and argument of the next item.
+func (r *cborReader) head() (byte, uint64, error) {
+	if r.pos >= len(r.data) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	h := r.data[r.pos]
+	r.pos++
+	m := h >> 5
+	a := uint64(h & 0x1f)
+	var s int
+	switch {
+	case a < 24:
+		return m, a, nil
+	case a == 24:
+		s = 1
+	case a == 25:
+		s = 2
+	case a == 26:
+		s = 4
+	case a == 27:
+		s = 8
+	default:
+		return 0, 0, fmt.Errorf("CBOR argument %d not supported", a)
+	}
+	if r.pos+s > len(r.data) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	var v uint64
+	for _, c := range r.data[r.pos : r.pos+s] {
+		v = v<<8 | uint64(c)
+	}
+	r.pos += s
+	return m, v, nil
+}
@@ -0,0 +1,369 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"bytes"
+	"common"
+	"demotest"
+	"io/ioutil"
+	"net/http/httptest"
+	"owid"
+	"path/filepath"
+	"swan"
+	"testing"
+)
+
+// newSignedNode returns a node for the OWID from the domain with the payload
+// signed over the root if there is one.
+func newSignedNode(
+	tb testing.TB,
+	domain string,
+	payload []byte,
+	root *owid.OWID) *owid.Node {
+	tb.Helper()
+	c := demotest.Creator{Domain: domain}
+	o := c.CreateOWID(payload)
+	var err error
+	if root == nil {
+		err = c.Sign(o)
+	} else {
+		err = c.Sign(o, root)
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	b, err := o.AsByteArray()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &owid.Node{OWID: b}
+}
+
+// demoPublisher is the publisher in the www folder whose suppliers form the
+// largest tree.
+const demoPublisher = "current-bun.uk"
+
+// newDemoConfig returns a configuration with the domains from the www folder.
+func newDemoConfig(tb testing.TB) *common.Configuration {
+	tb.Helper()
+	c := demotest.NewConfig()
+	w := filepath.Join("..", "..", "www")
+	files, err := ioutil.ReadDir(w)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Join(w, f.Name(), "config.json"))
+		if err == nil {
+			demotest.NewDomain(tb, c, f.Name(), string(b))
+		}
+	}
+	return c
+}
+
+// newDemoTree returns a tree like the one formed when the publisher offers
+// an impression in the demo. Each processor calls the suppliers from its
+// configuration in the www folder, bids with its most valuable eligible
+// advert, and passes on the best bid recording its hop. Suppliers that are
+// not part of the demo are recorded as failed.
+func newDemoTree(tb testing.TB, publisher string) *owid.Node {
+	tb.Helper()
+	c := newDemoConfig(tb)
+	f := swan.Offer{
+		PubDomain:   publisher,
+		UUID:        make([]byte, 16),
+		CBID:        make([]byte, 16),
+		SID:         make([]byte, 32),
+		Preferences: []byte(common.PurposePersonalizedAds)}
+	p, err := f.AsByteArray()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	r := newSignedNode(tb, publisher, p, nil)
+	n, _ := newDemoNode(tb, c, r, &f, publisher)
+	r.AddChild(n)
+	return r
+}
+
+// newDemoNode returns the node for the processor with the host and the
+// notice of the bid it passed on, or nil if it did not bid.
+func newDemoNode(
+	tb testing.TB,
+	c *common.Configuration,
+	r *owid.Node,
+	f *swan.Offer,
+	host string) (*owid.Node, *notice) {
+	tb.Helper()
+	d := c.FindDomain(host)
+	o, err := r.GetOWID()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	// Get the children from the suppliers and the best bid of them.
+	var children []*owid.Node
+	var best *notice
+	w := 0
+	for _, s := range d.Suppliers {
+		var x *owid.Node
+		var b *notice
+		if c.FindDomain(s) == nil {
+			x, err = createFailed(d, r, s, "404")
+			if err != nil {
+				tb.Fatal(err)
+			}
+		} else {
+			x, b = newDemoNode(tb, c, r, f, s)
+		}
+		if b != nil && b.price() >= best.price() {
+			best = b
+			w = len(children)
+		}
+		children = append(children, x)
+	}
+
+	// Bid with the most valuable eligible advert if it beats the suppliers.
+	var a common.Bid
+	for _, e := range eligibleAdverts(d, f, nil) {
+		if e.price() >= best.price() {
+			best = newNotice(d, e.price())
+			a = common.Bid{
+				MediaURL:      e.advert.MediaURL,
+				AdvertiserURL: e.advert.AdvertiserURL}
+			w = -1
+		}
+	}
+
+	// Pass on the winning bid of the suppliers recording the hop.
+	p, err := empty.AsByteArray()
+	if best != nil {
+		if w >= 0 {
+			b, err := common.PayloadFromNode(children[w])
+			if err != nil {
+				tb.Fatal(err)
+			}
+			a = *b.(*common.Bid)
+			a.Hop = common.NewHop(best.price(), d.TakeRate)
+			best = newNotice(d, a.Hop.Passed)
+		}
+		best.setBid(&a)
+		p, err = a.AsByteArray()
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	n := newSignedNode(tb, host, p, o)
+	for _, x := range children {
+		n.AddChild(x)
+	}
+	if best != nil {
+		n.Value = w
+	}
+	return n, best
+}
+
+// TestEncodeRoundTrip checks that trees are the same after being encoded and
+// decoded with each media type.
+func TestEncodeRoundTrip(t *testing.T) {
+	r := newDemoTree(t, demoPublisher)
+	r.Children[0].Children[1].Value = -1
+	j, err := r.AsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{mediaTypeJSON, mediaTypeCBOR} {
+		b, err := encodeNode(r, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := decodeNode(b, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := n.AsJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(j, k) == false {
+			t.Fatalf("%s tree changed\n%s\n%s", m, j, k)
+		}
+		if c := n.Children[0].Children[1]; c.GetParent() != n.Children[0] {
+			t.Fatalf("%s parent not set", m)
+		}
+	}
+}
+
+// TestCBORSmallerThanJSON checks that CBOR is smaller than JSON as the OWIDs
+// are not base 64 encoded.
+func TestCBORSmallerThanJSON(t *testing.T) {
+	r := newDemoTree(t, demoPublisher)
+	j, err := encodeNode(r, mediaTypeJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := encodeNode(r, mediaTypeCBOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) >= len(j) {
+		t.Fatalf("CBOR %d bytes not smaller than JSON %d bytes", len(c), len(j))
+	}
+}
+
+// TestDecodeCBORInvalid checks that data that is not a complete tree is
+// refused.
+func TestDecodeCBORInvalid(t *testing.T) {
+	b, err := encodeNode(newDemoTree(t, demoPublisher), mediaTypeCBOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, d := range map[string][]byte{
+		"empty":     {},
+		"truncated": b[:len(b)-1],
+		"extra":     append(append([]byte{}, b...), 0),
+		"not array": {0x41, 0x00},
+		"long OWID": {0x83, 0x5a, 0xff, 0xff, 0xff, 0xff},
+		"bad value": {0x83, 0x40, 0x40, 0x80},
+		"children":  {0x83, 0x40, 0xf6, 0x98, maxChildren + 1}} {
+		if _, err := decodeNode(d, mediaTypeCBOR); err == nil {
+			t.Fatalf("%s CBOR accepted", n)
+		}
+	}
+	var d bytes.Buffer
+	for i := 0; i <= maxDepth+1; i++ {
+		d.Write([]byte{0x83, 0x40, 0xf6, 0x81})
+	}
+	d.Write([]byte{0x83, 0x40, 0xf6, 0x80})
+	if _, err := decodeNode(d.Bytes(), mediaTypeCBOR); err == nil {
+		t.Fatal("deep CBOR accepted")
+	}
+}
+
+// TestEncodeValue checks that only integer values can be encoded as CBOR.
+func TestEncodeValue(t *testing.T) {
+	n := newNode(t, "pub.com", nil)
+	for _, v := range []interface{}{nil, 0, 3, -1, float64(2)} {
+		n.Value = v
+		if _, err := encodeNode(n, mediaTypeCBOR); err != nil {
+			t.Fatalf("value %v refused: %s", v, err)
+		}
+	}
+	for _, v := range []interface{}{1.5, "1"} {
+		n.Value = v
+		if _, err := encodeNode(n, mediaTypeCBOR); err == nil {
+			t.Fatalf("value %v accepted", v)
+		}
+	}
+}
+
+// TestMediaTypeNegotiation checks that CBOR is only used if the caller
+// accepts it and that JSON is assumed if no type is given.
+func TestMediaTypeNegotiation(t *testing.T) {
+	for a, m := range map[string]string{
+		"":                                   mediaTypeJSON,
+		"application/json":                   mediaTypeJSON,
+		"application/cbor, application/json": mediaTypeCBOR,
+		"application/json, application/cbor": mediaTypeCBOR,
+		"text/html;q=0.9, application/cbor":  mediaTypeCBOR} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Accept", a)
+		if acceptedType(r) != m {
+			t.Fatalf("Accept '%s' not %s", a, m)
+		}
+	}
+	r := httptest.NewRequest("POST", "/", nil)
+	if contentType(r.Header) != mediaTypeJSON {
+		t.Fatal("missing content type not JSON")
+	}
+	r.Header.Set("Content-Type", mediaTypeCBOR+"; charset=binary")
+	if contentType(r.Header) != mediaTypeCBOR {
+		t.Fatal("content type not CBOR")
+	}
+}
+
+// TestReadBody checks that gzip bodies are decompressed and that bodies
+// larger than the limit after decompression are refused.
+func TestReadBody(t *testing.T) {
+	b, err := compress([]byte("tree"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+	r.Header.Set("Content-Encoding", "gzip")
+	d, err := readBody(r)
+	if err != nil || string(d) != "tree" {
+		t.Fatalf("expected 'tree', got '%s' %v", d, err)
+	}
+	b, err = compress(make([]byte, maxBodySize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("POST", "/", bytes.NewReader(b))
+	r.Header.Set("Content-Encoding", "gzip")
+	if _, err := readBody(r); err == nil {
+		t.Fatal("large body accepted")
+	}
+}
+
+// benchmarkEncode encodes the demo tree reporting the size of the
+// data before and after compression.
+func benchmarkEncode(b *testing.B, mediaType string) {
+	r := newDemoTree(b, demoPublisher)
+	d, err := encodeNode(r, mediaType)
+	if err != nil {
+		b.Fatal(err)
+	}
+	z, err := compress(d)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := encodeNode(r, mediaType)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(d)), "bytes")
+	b.ReportMetric(float64(len(z)), "gzip-bytes")
+}
+
+func BenchmarkEncodeJSON(b *testing.B) { benchmarkEncode(b, mediaTypeJSON) }
+
+func BenchmarkEncodeCBOR(b *testing.B) { benchmarkEncode(b, mediaTypeCBOR) }
+
+// benchmarkDecode decodes the demo tree.
+func benchmarkDecode(b *testing.B, mediaType string) {
+	d, err := encodeNode(newDemoTree(b, demoPublisher), mediaType)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := decodeNode(d, mediaType)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeJSON(b *testing.B) { benchmarkDecode(b, mediaTypeJSON) }
+
+func BenchmarkDecodeCBOR(b *testing.B) { benchmarkDecode(b, mediaTypeCBOR) }
@@ -23,15 +23,52 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"owid"
+	"strings"
 	"swan"
 	"sync"
+	"time"
 )
 
 var empty swan.Empty // Used for empty responses
 
+// Reason used in failed nodes for suppliers the user has stopped.
+const stoppedByUser = "stopped by user"
+
+// Reason used in failed nodes if a domain would take part in a transaction
+// twice or the chain of processors is too long.
+const loopDetected = "loop detected"
+
+// Reasons used in failed nodes if a domain has already been asked to take part
+// in the transaction through another supply path, if the offer has been seen
+// before outside of the same auction, if the offer is too old, or if the offer
+// was not signed by its creator.
+const (
+	duplicateOffer = "duplicate"
+	replayedOffer  = "replay detected"
+	expiredOffer   = "offer expired"
+	invalidOffer   = "offer signature invalid"
+)
+
+// Reason used in failed nodes for suppliers that did not respond in time.
+const timedOut = "timed out"
+
+// bidTimeout is the time the publisher gives its suppliers to respond. Each
+// processor further down the chain gives its suppliers a shorter time so it
+// can still respond to its caller when one of them is too slow.
+var bidTimeout = time.Second
+
+// duplicateWindow is the time after an offer is first seen that further
+// requests are treated as duplicates from other supply paths rather than
+// replays.
+var duplicateWindow = 30 * time.Second
+
+// maxChainDepth is the most processors from the root to any processor.
+const maxChainDepth = 8
+
 const openRTBPath = "/demo/api/v1/bid" // The path for this handler
 
 // Handler is responsible for a real time transaction for advertising.
@@ -39,7 +76,11 @@ const openRTBPath = "/demo/api/v1/bid" // The path for this handler
 // contain the signature of the last entry in the list of Processors.
 func Handler(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 
-	if r.URL.Path == openRTBPath && r.Method == "POST" {
+	if strings.HasPrefix(r.URL.Path, noticePath) {
+		handlerNotice(d, w, r)
+	} else if r.URL.Path == statsPath {
+		handlerStats(d, w, r)
+	} else if r.URL.Path == openRTBPath && r.Method == "POST" {
 
 		// Unpack the body of the request to form the bid data structure.
 		o, err := getOffer(d, r)
@@ -48,30 +89,35 @@ func Handler(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// If this domain is a bad actor then change the publisher's domain to
-		// one that would generate more money from advertising.
-		if d.Bad {
-			err = changePubDomain(o, "high-value-pub.com")
-			if err != nil {
-				common.ReturnStatusCodeError(
-					d.Config,
-					w,
-					err,
-					http.StatusInternalServerError)
-				return
-			}
+		// Get the OpenRTB fields from the request and check the consent fields
+		// agree with the preferences in the offer.
+		q, err := requestFromHTTP(r)
+		if err != nil {
+			common.ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+			return
+		}
+		f, err := getSWANOffer(o)
+		if err != nil {
+			common.ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+			return
+		}
+		err = q.verify(f)
+		if err != nil {
+			common.ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+			return
 		}
 
 		// Handle the bid and return if the URL was found.
-		t, err := HandleTransaction(d, o)
+		t, _, err := handleTransaction(d, o, q, false)
 		if err != nil {
 			common.ReturnServerError(d.Config, w, err)
 			return
 		}
 
 		// The caller already knows about the rest of the tree. Only return this
-		// Processor OWID and the children.
-		b, err := t.AsJSON()
+		// Processor OWID and the children in the encoding the caller accepts.
+		m := acceptedType(r)
+		b, err := encodeNode(t, m)
 		if err != nil {
 			common.ReturnServerError(d.Config, w, err)
 			return
@@ -80,7 +126,8 @@ func Handler(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 		g := gzip.NewWriter(w)
 		defer g.Close()
 		w.Header().Set("Content-Encoding", "gzip")
-		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Type", m)
+		w.Header().Set("Vary", "Accept")
 		w.Header().Set("Cache-Control", "no-cache")
 		_, err = g.Write(b)
 		if err != nil {
@@ -92,6 +139,20 @@ func Handler(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// storeTransaction adds the OWID tree to the domain's transactions using the
+// Offer ID of the root.
+func storeTransaction(d *common.Domain, n *owid.Node) error {
+	r, err := n.GetOWID()
+	if err != nil {
+		return err
+	}
+	b, err := n.AsJSON()
+	if err != nil {
+		return err
+	}
+	return d.Transactions().Add(r.AsString(), b)
+}
+
 func getSWANOffer(r *owid.Node) (*swan.Offer, error) {
 	f, err := r.GetOWID()
 	if err != nil {
@@ -104,7 +165,13 @@ func getSWANOffer(r *owid.Node) (*swan.Offer, error) {
 	return o, nil
 }
 
+// changePubDomain replaces the publisher domain in the Offer.
 func changePubDomain(r *owid.Node, newPubDomain string) error {
+	return updateOffer(r, func(o *swan.Offer) { o.PubDomain = newPubDomain })
+}
+
+// updateOffer changes the Offer in the root OWID without signing it again.
+func updateOffer(r *owid.Node, fn func(o *swan.Offer)) error {
 	f, err := r.GetOWID()
 	if err != nil {
 		return err
@@ -113,7 +180,7 @@ func changePubDomain(r *owid.Node, newPubDomain string) error {
 	if err != nil {
 		return err
 	}
-	o.PubDomain = newPubDomain
+	fn(o)
 	f.Payload, err = o.AsByteArray()
 	if err != nil {
 		return err
@@ -125,143 +192,576 @@ func changePubDomain(r *owid.Node, newPubDomain string) error {
 	return nil
 }
 
-// HandleTransaction processes an OpenRTB transaction.
-func HandleTransaction(d *common.Domain, n *owid.Node) (*owid.Node, error) {
+// HandleTransaction processes an OpenRTB transaction. The OpenRTB fields are
+// used for targeting and passed to suppliers if provided. The caller makes
+// the final decision so the winning supplier is sent win and billing notices.
+func HandleTransaction(
+	d *common.Domain,
+	n *owid.Node,
+	q *Request) (*owid.Node, error) {
+	t, _, err := handleTransaction(d, n, q, true)
+	return t, err
+}
+
+// handleTransaction processes an OpenRTB transaction returning the node for
+// this processor and the bid fields offered to the caller in the node's signed
+// payload, or nil if there is no bid. Suppliers that lose the auction are sent
+// loss notices. If final is true then the winning supplier is sent win and
+// billing notices. Otherwise the notices for the winning supplier are sent
+// when the caller sends its notices for the bid returned.
+func handleTransaction(
+	d *common.Domain,
+	n *owid.Node,
+	q *Request,
+	final bool) (*owid.Node, *notice, error) {
 
 	// Verify that this domain can create OWIDs. Failure to register a domain
 	// as an OWID creator is a common setup mistake.
 	_, err := d.GetOWIDCreator()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// The single leaf is the parent Processor OWID. If there isn't a single
 	// leaf then too much information has been sent from the caller.
 	parent, err := n.GetLeaf()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// Refuse to take part if this domain is already one of the processors in
+	// the path from the root or the chain is too long. The caller adds the
+	// failed node instead of this processor.
+	chain, err := pathDomains(parent)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isLoop(chain, d.Host) {
+		f, err := createFailed(d, n, d.Host, loopDetected)
+		return f, nil, err
 	}
 
 	// Create an OWID for this processor.
 	t := d.OWID.CreateOWID(nil)
 	if t == nil {
-		return nil, fmt.Errorf("Could not create new OWID")
+		return nil, nil, fmt.Errorf("Could not create new OWID")
 	}
 
-	// If this domain has adverts then choose one at random. Get a random
-	// byte array to use as the payload from the Processor OWID.
-	if len(d.Adverts) > 0 {
+	// The root node must be the Offer.
+	offer, err := swan.OfferFromNode(n.GetRoot())
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// The root node must be the Offer.
-		offer, err := swan.OfferFromNode(n.GetRoot())
-		if err != nil {
-			return nil, err
+	// The notices for the bid offered are sent by the processor that sent the
+	// offer, which is the last in the chain, or the publisher if the offer
+	// came directly from the publisher.
+	caller := offer.PubDomain
+	if len(chain) > 0 {
+		caller = chain[len(chain)-1]
+	}
+
+	// Refuse offers that were not signed by their creator before using the
+	// date or the ID, so a forged offer can't get around the offer window or
+	// be remembered in place of a real one. Then refuse offers created longer
+	// ago than the offer window so captured offers can't be used later.
+	r, err := n.GetOWID()
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := d.Config.VerifyOWID(r, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v == false {
+		f, err := createFailed(d, n, d.Host, invalidOffer)
+		return f, nil, err
+	}
+	if time.Since(r.Date) > d.Config.OfferWindow() {
+		f, err := createFailed(d, n, d.Host, expiredOffer)
+		return f, nil, err
+	}
+
+	// Only take part once in each offer. Requests for the same offer through
+	// other supply paths are refused so the domain can't bid against itself.
+	// Requests after the auction must have finished are replays.
+	if s, ok := d.Seen().Add(auctionID(offer), r.Date); ok == false {
+		m := duplicateOffer
+		if time.Since(s) > duplicateWindow {
+			m = replayedOffer
 		}
+		f, err := createFailed(d, n, d.Host, m)
+		return f, nil, err
+	}
 
-		// Get a random advert checking that it is not on the stopped list.
-		var b swan.Bid
-		i := 10
-		for i > 0 {
-			w := d.Adverts[rand.Intn(len(d.Adverts))]
-			if offer.IsStopped(w.AdvertiserURL) == false {
-				b.AdvertiserURL = w.AdvertiserURL
-				b.MediaURL = w.MediaURL
-				t.Payload, err = b.AsByteArray()
-				break
+	// Apply any bad actor behaviours to the tree received now that the offer
+	// has been checked. The root is read again as the behaviours can change
+	// it.
+	err = onReceived(d, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err = n.GetOWID()
+	if err != nil {
+		return nil, nil, err
+	}
+	offer, err = swan.OfferFromNode(n.GetRoot())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// If this domain has adverts or campaigns then choose one at random. Get
+	// a random byte array to use as the payload from the Processor OWID. The
+	// price of the bid is the CPM of the campaign or advert. The price and
+	// notice URLs are part of the signed payload.
+	var own *notice
+	var bid *candidate
+	var held *common.Reservation
+	if len(d.Adverts) > 0 || len(d.Advertisers) > 0 {
+
+		// Get a random advert from those that are not on the stopped list,
+		// that the user has allowed the purposes for, and whose campaign has
+		// budget. The campaign's spend is held until the outcome of the
+		// auction is known so concurrent bids can't take the campaign over
+		// budget.
+		a := eligibleAdverts(d, offer, q)
+		if len(a) > 0 {
+			bid = &a[rand.Intn(len(a))]
+			if bid.campaign != nil {
+				held = bid.campaign.Reserve()
+				if held == nil {
+					bid = nil
+				}
 			}
-			i--
 		}
-		if i == 0 {
+		if bid != nil {
+			var b common.Bid
+			b.AdvertiserURL = bid.advert.AdvertiserURL
+			b.MediaURL = bid.advert.MediaURL
+			if bid.advert.FormatOrDefault() != common.FormatBanner {
+				b.Format = bid.advert.FormatOrDefault()
+			}
+			own = newNotice(d, bid.price())
+			own.setBid(&b)
+			t.Payload, err = b.AsByteArray()
+			d.Auctions().RecordBid()
+		} else {
 			t.Payload, err = empty.AsByteArray()
 		}
 	} else {
 		t.Payload, err = empty.AsByteArray()
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Sign the Processor OWID with the root OWID now that it's part of the
 	// tree. This can be used by down stream suppliers to verify that this
 	// processor was involved in the transaction.
-	r, err := n.GetOWID()
-	if err != nil {
-		return nil, err
-	}
 	err = d.OWID.Sign(t, r)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Add this signed Processor OWID to the children of the parent.
 	n, err = parent.AddOWID(t)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Call all the suppliers adding them to this Processor OWID's child
-	// transactions.
+	// transactions. Suppliers the user has stopped are not called and a
+	// failed node is added instead so the audit shows the stop was respected.
+	// Suppliers already in the chain, or beyond the maximum chain depth, are
+	// also not called.
 	var wg sync.WaitGroup
 	wg.Add(len(d.Suppliers))
-	c := make([]*owid.Node, len(d.Suppliers))
+	h := make([]*owid.Node, len(d.Suppliers))
+	x := make([]*notice, len(d.Suppliers))
 	e := make([]error, len(d.Suppliers))
+	l := stopList(d, offer)
+	chain = append(chain, d.Host)
+	rn, rq, err := replayOffer(d, n, q)
+	if err != nil {
+		return nil, nil, err
+	}
 	for i, s := range d.Suppliers {
 		go func(i int, s string) {
 			defer wg.Done()
-			c[i], e[i] = sendToSupplier(d, s, n)
+			if l.IsSupplierStopped(s) {
+				h[i], e[i] = createFailed(d, n, s, stoppedByUser)
+			} else if isLoop(chain, s) {
+				h[i], e[i] = createFailed(d, n, s, loopDetected)
+			} else {
+				h[i], x[i], e[i] = sendToSupplier(d, s, rn, rq)
+			}
 		}(i, s)
 	}
 	wg.Wait()
 
-	// Merge the results from the suppliers.
+	// Merge the results from the suppliers keeping the bid fields in the same
+	// order as the children. Bad actor behaviours can then change them.
+	var b []*notice
 	i := 0
 	for i < len(d.Suppliers) {
 		if e[i] != nil {
-			return nil, e[i]
+			return nil, nil, e[i]
 		}
-		if c[i] != nil {
-			n.AddChild(c[i])
+		if h[i] != nil {
+			n.AddChild(h[i])
+			b = append(b, x[i])
 		}
 		i++
 	}
+	b, err = onMerged(d, n, b)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// If there are children then pick one at random for the payload of
-	// this processor. Used to determine the winner when the transaction
-	// is complete. This also demonstrates how the payload can be changed
-	// after the response has been received.
+	// If there are children then pick the highest priced bid, including this
+	// processor's own bid, for the payload of this processor. Used to
+	// determine the winner when the transaction is complete. This also
+	// demonstrates how the payload can be changed after the response has been
+	// received.
+	w := -1
 	if len(n.Children) > 0 {
-		n.Value, err = chooseWinner(n)
+		var f float64
+		if p := q.Placement(); p != nil {
+			f = p.Floor
+		}
+		var o map[int]int
+		w, o, err = chooseWinner(n, b, own, f, l)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		n.Value = w
+
+		// The losing suppliers are told now as the outcome can't change. The
+		// own bid only loses if a supplier offered a higher price.
+		var c float64
+		if w >= 0 {
+			c = b[w].price()
+		} else {
+			c = own.price()
+		}
+		notifyLosers(d, auctionID(offer), b, o, c)
+		if w >= 0 && own != nil {
+			d.Auctions().RecordLoss(common.LossOutbid)
+			if held != nil {
+				held.Release()
+			}
+			own = nil
 		}
 	}
 
-	return n, nil
+	// If a supplier won then record the price received and the price passed
+	// on after this processor's fee in the payload, and sign it again.
+	var c *notice
+	var p *common.Hop
+	if w >= 0 {
+		c = b[w]
+		p = common.NewHop(c.price(), d.TakeRate)
+		err = setHop(d, n, w, t, r, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = onDecided(d, n, w, t, r, p)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Keep the tree this processor took part in for later audit. The final
+	// decider is the publisher which keeps the tree itself.
+	if final == false {
+		err = storeTransaction(d, n.GetRoot())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Work out the bid offered by this processor. If a supplier won then the
+	// notices for the supplier are sent when this processor receives its
+	// notices, or now if this is the final decision.
+	id := auctionID(offer)
+	var a *pendingAuction
+	if own != nil {
+		a = &pendingAuction{
+			parent:      caller,
+			own:         true,
+			advert:      bid.advert,
+			path:        chain[:len(chain)-1],
+			reservation: held}
+		if isPersonalized(offer) {
+			a.cbid = offer.CBIDAsString()
+		}
+	}
+	if final {
+		if c != nil {
+			notifyWinner(d, id, c)
+		}
+		if a != nil {
+			err = a.win(d, own.price())
+		}
+		return n, nil, err
+	}
+	if a != nil {
+		pending.add(d.Host, id, a)
+		return n, own, nil
+	}
+	if c != nil {
+		pending.add(d.Host, id, &pendingAuction{
+			parent: caller,
+			child:  c,
+			hop:    p})
+		x, err := noticeOf(n)
+		return n, x, err
+	}
+	return n, nil, nil
 }
 
-func chooseWinner(n *owid.Node) (int, error) {
+// setHop replaces the payload of the processor OWID with the winning bid and
+// the prices of the hop so that the fee taken is part of the signed audit
+// trail. The price offered is the price passed on and the notices for the bid
+// are sent to this processor. The processor OWID is signed again with the
+// root OWID.
+func setHop(
+	d *common.Domain,
+	n *owid.Node,
+	w int,
+	t *owid.OWID,
+	r *owid.OWID,
+	h *common.Hop) error {
+	a, err := bidOf(n.Children[w])
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return fmt.Errorf("Winning supplier bid not found")
+	}
+	b := *a
+	b.Hop = h
+	newNotice(d, h.Passed).setBid(&b)
+	t.Payload, err = b.AsByteArray()
+	if err != nil {
+		return err
+	}
+	err = d.OWID.Sign(t, r)
+	if err != nil {
+		return err
+	}
+	n.OWID, err = t.AsByteArray()
+	return err
+}
+
+// auctionID returns the ID used in notices for the auction of the offer.
+func auctionID(o *swan.Offer) string {
+	return fmt.Sprintf("%x", o.UUID)
+}
+
+// candidate is an advert that could be bid with and the campaign it belongs
+// to if any.
+type candidate struct {
+	advert   *common.Advert
+	campaign *common.Campaign // Nil if the advert is not part of a campaign
+}
+
+// price returns the CPM of the campaign if there is one, otherwise the CPM of
+// the advert.
+func (c *candidate) price() float64 {
+	if c.campaign != nil {
+		return c.campaign.CPM
+	}
+	return c.advert.CPM
+}
+
+// eligibleAdverts returns the adverts of the domain, and of the campaigns of
+// the advertisers it bids for, that can be used with the offer. Adverts for
+// stopped advertisers, or that need purposes the user has not allowed, are
+// excluded. If the user allows personalization then adverts that have reached
+// their frequency cap for the CBID are also excluded. Otherwise the CBID is
+// not used and the choice is contextual. Campaign adverts are only included if
+// the offer matches the targeting and the campaign can spend. If the request
+// describes the placement then only adverts of a compatible format and size,
+// priced at or above the floor and not in a blocked category, are included.
+// Otherwise only banners are included.
+func eligibleAdverts(
+	d *common.Domain,
+	o *swan.Offer,
+	q *Request) []candidate {
+	var a []candidate
+	c := common.ParseConsent(o.PreferencesAsString())
+	s := stopList(d, o)
+	p := isPersonalized(o)
+	l := q.Placement()
+	eligible := func(w *common.Advert, cpm float64) bool {
+		return s.IsAdvertStopped(w) == false &&
+			c.HasAll(w.Purposes) &&
+			(l == nil && w.FormatOrDefault() == common.FormatBanner ||
+				l != nil && l.Accepts(w, cpm)) &&
+			(p == false || d.Frequency().IsCapped(o.CBIDAsString(), w) == false)
+	}
+	for i := range d.Adverts {
+		if eligible(&d.Adverts[i], d.Adverts[i].CPM) {
+			a = append(a, candidate{&d.Adverts[i], nil})
+		}
+	}
+	for _, m := range d.AdvertiserCampaigns() {
+		if m.IsTargeted(o.PubDomain, o.Placement, q.DeviceType(), p) &&
+			m.CanSpend() {
+			for i := range m.Adverts {
+				if eligible(&m.Adverts[i], m.CPM) {
+					a = append(a, candidate{&m.Adverts[i], m})
+				}
+			}
+		}
+	}
+	return a
+}
+
+// isPersonalized returns true if the offer allows personalized adverts and
+// has a CBID to personalize them with.
+func isPersonalized(o *swan.Offer) bool {
+	return o.CBIDAsString() != "" &&
+		common.ParseConsent(o.PreferencesAsString()).Has(
+			common.PurposePersonalizedAds)
+}
+
+// chooseWinner returns the index of the child with the highest priced bid, or
+// -1 if the processor's own bid wins or there are no eligible bids, and the
+// OpenRTB loss reason for the other children that provided bid fields. The own
+// bid, if not nil, competes with the children on price. Bids below the floor,
+// for stopped advertisers, or supplied via a stopped processor are not
+// eligible. The stop list is checked at every hop so a supplier that ignores
+// it can't win. Ties are broken at random.
+func chooseWinner(
+	n *owid.Node,
+	b []*notice,
+	own *notice,
+	floor float64,
+	s common.StopList) (int, map[int]int, error) {
 	w := -1
-	e := 0
-	o := make([]bool, len(n.Children))
+	var e []int
+	if own != nil {
+		e = []int{-1}
+	}
+	price := func(i int) float64 {
+		if i < 0 {
+			return own.price()
+		}
+		return b[i].price()
+	}
+	l := make(map[int]int)
 	for i, c := range n.Children {
-		b, err := isBid(c)
+		ok, err := isBid(c)
+		if err != nil {
+			return -1, nil, err
+		}
+		if ok == false {
+			if b[i] != nil {
+				l[i] = common.LossInvalidBid
+			}
+			continue
+		}
+		t, err := isStopped(c, s)
 		if err != nil {
-			return -1, err
+			return -1, nil, err
 		}
-		if b {
-			o[i] = true
-			e++
+		if t {
+			l[i] = common.LossStopped
+		} else if b[i].price() < floor {
+			l[i] = common.LossBelowFloor
+		} else if len(e) == 0 || b[i].price() > price(e[0]) {
+			for _, j := range e {
+				if j >= 0 {
+					l[j] = common.LossOutbid
+				}
+			}
+			e = []int{i}
+		} else if b[i].price() == price(e[0]) {
+			e = append(e, i)
+		} else {
+			l[i] = common.LossOutbid
 		}
 	}
-	if e > 0 {
-		for w < 0 {
-			i := rand.Intn(len(o))
-			if o[i] {
-				w = i
+	if len(e) > 0 {
+		w = e[rand.Intn(len(e))]
+		for _, j := range e {
+			if j != w && j >= 0 {
+				l[j] = common.LossOutbid
 			}
 		}
 	}
-	return w, nil
+	return w, l, nil
+}
+
+// bidOf returns the bid the node leads to by following the winning child
+// indexes of the processors, or nil if there isn't one. A negative index means
+// the processor's own bid won.
+func bidOf(n *owid.Node) (*common.Bid, error) {
+	for n.Value != nil && len(n.Children) > 0 {
+		i, ok := valueIndex(n)
+		if ok == false || i >= len(n.Children) {
+			return nil, nil
+		}
+		if i < 0 {
+			break
+		}
+		n = n.Children[i]
+	}
+	b, err := common.PayloadFromNode(n)
+	if err != nil {
+		return nil, err
+	}
+	a, _ := b.(*common.Bid)
+	return a, nil
+}
+
+// isStopped returns true if the bid the node leads to is for an advertiser in
+// the stop list, or if any of the processors on the path to the bid are in
+// the stop list.
+func isStopped(n *owid.Node, s common.StopList) (bool, error) {
+	for {
+		o, err := n.GetOWID()
+		if err != nil {
+			return false, err
+		}
+		if s.IsSupplierStopped(o.Domain) {
+			return true, nil
+		}
+		if n.Value == nil || len(n.Children) == 0 {
+			break
+		}
+		i, ok := valueIndex(n)
+		if ok == false || i >= len(n.Children) {
+			return false, nil
+		}
+		if i < 0 {
+			break
+		}
+		n = n.Children[i]
+	}
+	a, err := bidOf(n)
+	if err != nil {
+		return false, err
+	}
+	return a != nil && s.IsStopped(a.AdvertiserURL), nil
+}
+
+// valueIndex returns the child index in the value of the node, and false if
+// the value is not a whole number. Values are int when set by this processor
+// and float64 when decoded.
+func valueIndex(n *owid.Node) (int, bool) {
+	switch v := n.Value.(type) {
+	case int:
+		return v, true
+	case float64:
+		if v != float64(int(v)) {
+			return 0, false
+		}
+		return int(v), true
+	}
+	return 0, false
 }
 
 // isBid returns true if the node is related to an eligible bid, otherwise
@@ -269,80 +769,208 @@ func chooseWinner(n *owid.Node) (int, error) {
 // or the Value of the node is a number that is greater than or equal to 0 and
 // there are children.
 func isBid(n *owid.Node) (bool, error) {
-	if n.Value != nil && n.Value.(float64) >= 0 && len(n.Children) > 0 {
+	if i, ok := valueIndex(n); ok && i >= 0 && len(n.Children) > 0 {
 		return true, nil
 	}
-	b, err := swan.FromNode(n)
+	b, err := common.PayloadFromNode(n)
 	if err != nil {
 		return false, err
 	}
-	_, ok := b.(*swan.Bid)
+	_, ok := b.(*common.Bid)
 	return ok, nil
 }
 
+// getOffer returns the tree from the body of the request in the encoding of
+// the content type. The tree is validated before it is returned.
 func getOffer(d *common.Domain, r *http.Request) (*owid.Node, error) {
-	b, err := ioutil.ReadAll(r.Body)
+	b, err := readBody(r)
 	if err != nil {
 		return nil, err
 	}
+	m := contentType(r.Header)
 	if d.Config.Debug {
 		fmt.Println(d.Host)
-		fmt.Println(string(b))
+		if m == mediaTypeJSON {
+			fmt.Println(string(b))
+		} else {
+			fmt.Printf("%d bytes of %s\n", len(b), m)
+		}
+	}
+	n, err := decodeNode(b, m)
+	if err != nil {
+		return nil, err
+	}
+	err = validateTree(n)
+	if err != nil {
+		return nil, err
 	}
-	return owid.NodeFromJSON(b)
+	return n, nil
 }
 
+// sendToSupplier returns the node from the supplier and the bid fields the
+// supplier returned, or nil if the supplier did not bid.
 func sendToSupplier(
 	d *common.Domain,
 	s string,
-	n *owid.Node) (*owid.Node, error) {
+	n *owid.Node,
+	q *Request) (*owid.Node, *notice, error) {
 
-	// Turn the node into a byte array.
-	j, err := n.GetRoot().AsJSON()
+	// Turn the path from the root to the node into a byte array. Other
+	// branches of the tree are not sent so the supplier can't see the bids of
+	// other suppliers.
+	m := mediaTypeJSON
+	if d.Config.BidEncoding == common.BidEncodingCBOR {
+		m = mediaTypeCBOR
+	}
+	j, err := encodeNode(pathFromRoot(n), m)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	j, err = compress(j)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// POST the bid to the supplier.
+	// POST the bid to the supplier with the OpenRTB fields.
 	var up url.URL
 	up.Scheme = d.Config.Scheme
 	up.Host = s
 	up.Path = openRTBPath
-	res, err := http.Post(
-		up.String(),
-		"application/json",
-		bytes.NewBuffer(j))
+	req, err := http.NewRequest("POST", up.String(), bytes.NewBuffer(j))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", m)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept", mediaTypeCBOR+", "+mediaTypeJSON)
+	err = q.setHeader(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := supplierTimeout(n)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := (&http.Client{Timeout: t}).Do(req)
+	if isTimeout(err) {
+		f, err := createFailed(d, n, up.Host, timedOut)
+		return f, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
 	}
 	if res.StatusCode != http.StatusOK {
-		return createFailed(d, n, &up, res)
+		f, err := createFailed(
+			d,
+			n,
+			up.Host,
+			fmt.Sprintf("%d", res.StatusCode))
+		return f, nil, err
 	}
 
 	// Read the response as a byte array.
+	defer res.Body.Close()
 	b, err := ioutil.ReadAll(res.Body)
+	if isTimeout(err) {
+		f, err := createFailed(d, n, up.Host, timedOut)
+		return f, nil, err
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Convert the byte array to a tree to append as a child to the current
 	// Processor's children
-	c, err := owid.NodeFromJSON(b)
+	c, err := decodeNode(b, contentType(res.Header))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return c, nil
+	// Get the price and notification URLs from the payload if the supplier
+	// bid.
+	x, err := noticeOf(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, x, nil
+}
+
+// supplierTimeout returns the time suppliers of the processor's node have to
+// respond. The time is shared out along the chain so the processor responds
+// before its caller gives up on it.
+func supplierTimeout(n *owid.Node) (time.Duration, error) {
+	c, err := pathDomains(n)
+	if err != nil {
+		return 0, err
+	}
+	if len(c) == 0 {
+		return bidTimeout, nil
+	}
+	return bidTimeout / time.Duration(len(c)), nil
+}
+
+// isTimeout returns true if the error is because the supplier did not respond
+// in time.
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+// pathDomains returns the domains of the processors from the root to the node
+// excluding the root.
+func pathDomains(n *owid.Node) ([]string, error) {
+	var d []string
+	for p := n; p != nil && p.GetParent() != nil; p = p.GetParent() {
+		o, err := p.GetOWID()
+		if err != nil {
+			return nil, err
+		}
+		d = append([]string{o.Domain}, d...)
+	}
+	return d, nil
 }
 
+// isLoop returns true if the host is already one of the processor domains or
+// adding it would make the chain longer than maxChainDepth.
+func isLoop(domains []string, host string) bool {
+	if len(domains) >= maxChainDepth {
+		return true
+	}
+	for _, d := range domains {
+		if strings.EqualFold(d, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathFromRoot returns a copy of the tree containing only the root, the
+// ancestors of the node, and the node. The size of the copy depends on the
+// depth of the node and not the width of the tree. The node's supplier
+// returns its own subtree which the caller adds to the full tree.
+func pathFromRoot(n *owid.Node) *owid.Node {
+	var c *owid.Node
+	for p := n; p != nil; p = p.GetParent() {
+		t := &owid.Node{OWID: p.OWID}
+		if c != nil {
+			t.Children = []*owid.Node{c}
+		}
+		c = t
+	}
+	return c
+}
+
+// createFailed returns a node signed by this domain recording that the host
+// could not take part in the transaction for the reason provided.
 func createFailed(
 	d *common.Domain,
 	n *owid.Node,
-	u *url.URL,
-	res *http.Response) (*owid.Node, error) {
+	host string,
+	reason string) (*owid.Node, error) {
 	var f swan.Failed
-	f.Host = u.Host
-	f.Error = fmt.Sprintf("%d", res.StatusCode)
+	f.Host = host
+	f.Error = reason
 	b, err := f.AsByteArray()
 	if err != nil {
 		return nil, err
@@ -357,6 +985,9 @@ func createFailed(
 	}
 	t := d.OWID.CreateOWID(b)
 	err = d.OWID.Sign(t, r)
+	if err != nil {
+		return nil, err
+	}
 	var c owid.Node
 	c.OWID, err = t.AsByteArray()
 	if err != nil {
@@ -0,0 +1,54 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"compress/gzip"
+	"net/http"
+)
+
+// statsPath is the path for the auction statistics page of the processor.
+const statsPath = "/stats"
+
+// statsModel is used with the auction statistics template.
+type statsModel struct {
+	Domain *common.Domain
+	Stats  *common.AuctionStats
+}
+
+// handlerStats displays the bids made by the processor and the outcomes from
+// the win, billing and loss notices received.
+func handlerStats(d *common.Domain, w http.ResponseWriter, r *http.Request) {
+	t, err := d.LookupSharedHTML("stats.html")
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+	var m statsModel
+	m.Domain = d
+	m.Stats = d.Auctions()
+	g := gzip.NewWriter(w)
+	defer g.Close()
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	err = t.Execute(g, &m)
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+	}
+}
@@ -0,0 +1,60 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"compress/gzip"
+	"demotest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerStats(t *testing.T) {
+	d := demotest.NewDomain(
+		t,
+		demotest.NewConfig(),
+		"dsp.com",
+		"",
+		"stats.html")
+	d.Auctions().RecordBid()
+	d.Auctions().RecordWin(2, []string{"pub.com", "ssp.com"})
+	w := httptest.NewRecorder()
+	handlerStats(d, w, httptest.NewRequest("GET", statsPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	g, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "<tr><th>Bids</th><td>1</td></tr>") == false {
+		t.Fatalf("statistics missing\n%s", b)
+	}
+	if strings.Contains(
+		string(b),
+		"<tr><td>pub.com &gt; ssp.com</td><td>1</td><td>0.0020</td>"+
+			"<td>2.00</td></tr>") == false {
+		t.Fatalf("supply path missing\n%s", b)
+	}
+}
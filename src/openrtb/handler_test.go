@@ -0,0 +1,394 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"demotest"
+	"net/http"
+	"owid"
+	"swan"
+	"testing"
+)
+
+// newNode returns a node for an unsigned OWID from the domain with the
+// payload.
+func newNode(t testing.TB, domain string, payload []byte) *owid.Node {
+	t.Helper()
+	o := owid.OWID{Version: 1, Domain: domain, Payload: payload}
+	b, err := o.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &owid.Node{OWID: b}
+}
+
+// newBidNode returns a node for a bid from the domain for the advertiser.
+func newBidNode(t *testing.T, domain string, advertiser string) *owid.Node {
+	t.Helper()
+	b := common.Bid{AdvertiserURL: advertiser}
+	p, err := b.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newNode(t, domain, p)
+}
+
+// newHopNode returns a node for a processor from the domain whose winning
+// child is the one provided.
+func newHopNode(t *testing.T, domain string, c *owid.Node) *owid.Node {
+	t.Helper()
+	n := newNode(t, domain, nil)
+	n.AddChild(c)
+	n.Value = 0
+	return n
+}
+
+func TestChooseWinnerStopped(t *testing.T) {
+	s := common.NewStopList([]string{
+		"stopped-advertiser.com",
+		"stopped-exchange.com|supplier"})
+	n := newNode(t, "exchange.com", nil)
+	n.AddChild(newBidNode(t, "dsp-a.com", "stopped-advertiser.com"))
+	n.AddChild(newHopNode(
+		t,
+		"stopped-exchange.com",
+		newBidNode(t, "dsp-b.com", "advertiser.com")))
+	n.AddChild(newHopNode(
+		t,
+		"other-exchange.com",
+		newBidNode(t, "dsp-c.com", "stopped-advertiser.com")))
+	n.AddChild(newBidNode(t, "dsp-d.com", "advertiser.com"))
+	b := []*notice{{Price: 4}, {Price: 3}, {Price: 2}, {Price: 1}}
+	w, l, err := chooseWinner(n, b, nil, 0, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 3 {
+		t.Fatalf("winner %d, expected 3", w)
+	}
+	for i := 0; i < 3; i++ {
+		if l[i] != common.LossStopped {
+			t.Errorf("child %d loss %d, expected stopped", i, l[i])
+		}
+	}
+}
+
+func TestChooseWinnerFloorAndPrice(t *testing.T) {
+	n := newNode(t, "exchange.com", nil)
+	n.AddChild(newBidNode(t, "dsp-a.com", "a.com"))
+	n.AddChild(newBidNode(t, "dsp-b.com", "b.com"))
+	n.AddChild(newBidNode(t, "dsp-c.com", "c.com"))
+	b := []*notice{{Price: 0.5}, {Price: 2}, {Price: 3}}
+	w, l, err := chooseWinner(n, b, nil, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 2 {
+		t.Fatalf("winner %d, expected 2", w)
+	}
+	if l[0] != common.LossBelowFloor || l[1] != common.LossOutbid {
+		t.Fatalf("losses %v", l)
+	}
+}
+
+// TestChooseWinnerOwnBid checks that the processor's own bid competes with
+// the children on price.
+func TestChooseWinnerOwnBid(t *testing.T) {
+	n := newNode(t, "dsp.com", nil)
+	n.AddChild(newBidNode(t, "dsp-a.com", "a.com"))
+	n.AddChild(newBidNode(t, "dsp-b.com", "b.com"))
+	b := []*notice{{Price: 2}, {Price: 4}}
+	w, l, err := chooseWinner(n, b, &notice{Price: 3}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 1 || l[0] != common.LossOutbid {
+		t.Fatalf("winner %d losses %v, expected 1 to outbid the own bid", w, l)
+	}
+	w, l, err = chooseWinner(n, b, &notice{Price: 5}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != -1 || l[0] != common.LossOutbid || l[1] != common.LossOutbid {
+		t.Fatalf("winner %d losses %v, expected the own bid to win", w, l)
+	}
+}
+
+func TestEligibleAdvertsPurposes(t *testing.T) {
+	c := demotest.NewConfig()
+	d := demotest.NewDomain(t, c, "dsp.com", `{
+		"adverts": [
+			{"advertiserURL": "contextual.com", "cpm": 1},
+			{"advertiserURL": "personal.com", "cpm": 1,
+				"purposes": ["personalized-ads"]},
+			{"advertiserURL": "stopped.com", "cpm": 1}]}`)
+	o := &swan.Offer{
+		Preferences: []byte(common.PurposeMeasurement),
+		Stopped:     []string{"stopped.com"}}
+	a := eligibleAdverts(d, o, nil)
+	if len(a) != 1 || a[0].advert.AdvertiserURL != "contextual.com" {
+		t.Fatalf("expected only the contextual advert, got %v", a)
+	}
+	o.Preferences = []byte(common.PurposePersonalizedAds)
+	a = eligibleAdverts(d, o, nil)
+	if len(a) != 2 {
+		t.Fatalf("expected 2 adverts, got %d", len(a))
+	}
+}
+
+// TestBidFormatAndHopSigned checks that the format of the advert and the
+// prices of each hop are fields of the signed bid payloads, and that the
+// media URL is passed on unchanged.
+func TestBidFormatAndHopSigned(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":      `{"suppliers": ["exchange.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp.com"], "takeRate": 0.1}`,
+		"dsp.com": `{"adverts": [{
+			"id": "v",
+			"advertiserURL": "adv.com",
+			"mediaURL": "adv.com/vast/v.xml?c=1",
+			"cpm": 2,
+			"format": "video",
+			"video": {"mediaFile": "adv.com/v.mp4", "duration": 15}}]}`})
+	q := NewRequest("", "", "")
+	q.AddPlacement(&common.Placement{
+		ID:      "player",
+		Sizes:   []common.Size{{Width: 640, Height: 360}},
+		Formats: []string{common.FormatVideo}})
+	p := c.FindDomain("pub.com")
+	n, err := HandleTransaction(p, newOffer(t, p, &swan.Offer{}), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := common.WinningBid(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil ||
+		b.FormatOrDefault() != common.FormatVideo ||
+		b.MediaURL != "adv.com/vast/v.xml?c=1" ||
+		b.Hop != nil {
+		t.Fatalf("unexpected DSP bid %+v", b)
+	}
+	e := childFrom(t, n, "exchange.com")
+	if e == nil {
+		t.Fatal("exchange missing")
+	}
+	o, err := e.GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := n.GetRoot().GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.VerifyOWID(o, r); err != nil || v == false {
+		t.Fatal("exchange bid not signed over the offer")
+	}
+	v, err := common.PayloadFromOWID(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ok := v.(*common.Bid)
+	if ok == false || h.Hop == nil || h.Hop.Received != 2 || h.Hop.Passed != 1.8 {
+		t.Fatalf("expected the hop prices in the exchange bid, got %+v", v)
+	}
+	if h.MediaURL != b.MediaURL || h.Format != b.Format {
+		t.Fatalf("exchange changed the bid to %+v", h)
+	}
+}
+
+// TestHopPricesAlongChain checks that each processor in a chain records the
+// price it received from its supplier and the price it passed on after its
+// take rate, and offers the price passed on to its caller.
+func TestHopPricesAlongChain(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":      `{"suppliers": ["ssp.com"]}`,
+		"ssp.com":      `{"suppliers": ["exchange.com"], "takeRate": 0.2}`,
+		"exchange.com": `{"suppliers": ["dsp.com"], "takeRate": 0.5}`,
+		"dsp.com":      `{"adverts": [{"advertiserURL": "adv.com", "cpm": 10}]}`})
+	n := transact(t, c, &swan.Offer{})
+	s := childFrom(t, n, "ssp.com")
+	if s == nil {
+		t.Fatal("ssp missing")
+	}
+	e := childFrom(t, s, "exchange.com")
+	if e == nil {
+		t.Fatal("exchange missing")
+	}
+	for _, i := range []struct {
+		n        *owid.Node
+		received float64
+		passed   float64
+	}{{s, 5, 4}, {e, 10, 5}} {
+		p, err := common.PayloadFromNode(i.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, ok := p.(*common.Bid)
+		if ok == false ||
+			b.Hop == nil ||
+			b.Hop.Received != i.received ||
+			b.Hop.Passed != i.passed ||
+			b.Price != i.passed {
+			t.Fatalf("expected %v received and %v passed, got %+v",
+				i.received,
+				i.passed,
+				p)
+		}
+	}
+	b, err := common.WinningBid(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil || b.Price != 10 || b.Hop != nil {
+		t.Fatalf("expected the DSP bid at 10 without a hop, got %+v", b)
+	}
+}
+
+// TestNoTakeRate checks that a processor without a take rate passes on the
+// price it received.
+func TestNoTakeRate(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["ssp.com"]}`,
+		"ssp.com": `{"suppliers": ["dsp.com"]}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "adv.com", "cpm": 3}]}`})
+	n := transact(t, c, &swan.Offer{})
+	s := childFrom(t, n, "ssp.com")
+	if s == nil {
+		t.Fatal("ssp missing")
+	}
+	p, err := common.PayloadFromNode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, ok := p.(*common.Bid)
+	if ok == false || b.Hop == nil || b.Hop.Passed != 3 || b.Hop.Fee() != 0 {
+		t.Fatalf("expected the price passed on unchanged, got %+v", p)
+	}
+}
+
+// TestPathFromRoot checks that the copy of the tree sent to a supplier only
+// contains the ancestors of the node and that the tree is not changed.
+func TestPathFromRoot(t *testing.T) {
+	r := newNode(t, "pub.com", nil)
+	e := newNode(t, "exchange.com", nil)
+	r.AddChild(e)
+	r.AddChild(newBidNode(t, "dsp-a.com", "a.com"))
+	s := newNode(t, "ssp.com", nil)
+	e.AddChild(newBidNode(t, "dsp-b.com", "b.com"))
+	e.AddChild(s)
+	p := pathFromRoot(s)
+	if d := treeDomains(t, p); len(d) != 3 ||
+		d[0] != "pub.com" ||
+		d[1] != "exchange.com" ||
+		d[2] != "ssp.com" {
+		t.Fatalf("expected the path to ssp.com, got %v", d)
+	}
+	if len(r.Children) != 2 || len(e.Children) != 2 || s.GetParent() != e {
+		t.Fatal("tree changed")
+	}
+}
+
+// TestSuppliersOnlySeePath checks that the tree a supplier receives contains
+// the offer and the processors that called it but not the bids of other
+// suppliers already in the tree. Both encodings are checked.
+func TestSuppliersOnlySeePath(t *testing.T) {
+	for _, m := range []string{"", "cbor"} {
+		var seen []string
+		c := newNetwork(t, map[string]string{
+			"exchange.com": `{}`,
+			"spy.com":      `{}`})
+		c.BidEncoding = m
+		c.FindDomain("spy.com").SetHandler(func(
+			d *common.Domain,
+			w http.ResponseWriter,
+			r *http.Request) {
+			b, err := readBody(r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n, err := decodeNode(b, contentType(r.Header))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			seen = treeDomains(t, n)
+			w.WriteHeader(http.StatusNoContent)
+		})
+		r := newNode(t, "pub.com", nil)
+		p := newNode(t, "pub.com", nil)
+		r.AddChild(p)
+		p.AddChild(newBidNode(t, "dsp-a.com", "a.com"))
+		e := newNode(t, "exchange.com", nil)
+		p.AddChild(e)
+		e.AddChild(newBidNode(t, "dsp-b.com", "b.com"))
+		_, _, err := sendToSupplier(
+			c.FindDomain("exchange.com"),
+			"spy.com",
+			e,
+			NewRequest("", "", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(seen) != 3 ||
+			seen[0] != "pub.com" ||
+			seen[1] != "pub.com" ||
+			seen[2] != "exchange.com" {
+			t.Fatalf("expected only the path to spy.com, got %v", seen)
+		}
+	}
+}
+
+// TestSubtreeOnlyHasSuppliers checks that the subtree a processor returns to
+// its caller only contains its own suppliers and not the other suppliers of
+// the caller.
+func TestSubtreeOnlyHasSuppliers(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":      `{"suppliers": ["dsp-a.com", "exchange.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp-b.com"]}`,
+		"dsp-a.com":    `{"adverts": [{"advertiserURL": "a.com", "cpm": 2}]}`,
+		"dsp-b.com":    `{"adverts": [{"advertiserURL": "b.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{})
+	if childFrom(t, n, "dsp-a.com") == nil {
+		t.Fatal("dsp-a.com missing")
+	}
+	e := childFrom(t, n, "exchange.com")
+	if e == nil {
+		t.Fatal("exchange missing")
+	}
+	if d := treeDomains(t, e); len(d) != 2 || d[1] != "dsp-b.com" {
+		t.Fatalf("expected exchange.com to return dsp-b.com, got %v", d)
+	}
+}
+
+// treeDomains returns the domains of the OWIDs in the tree in depth first
+// order.
+func treeDomains(t *testing.T, n *owid.Node) []string {
+	t.Helper()
+	o, err := n.GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := []string{o.Domain}
+	for _, c := range n.Children {
+		d = append(d, treeDomains(t, c)...)
+	}
+	return d
+}
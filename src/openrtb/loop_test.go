@@ -0,0 +1,140 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"errors"
+	"fmt"
+	"owid"
+	"swan"
+	"testing"
+)
+
+// TestSupplierLoop checks that an exchange whose supplier is already in the
+// chain doesn't call it and adds a failed node signed over the offer instead.
+func TestSupplierLoop(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":   `{"suppliers": ["ex-a.com"]}`,
+		"ex-a.com":  `{"suppliers": ["ex-b.com", "dsp-a.com"]}`,
+		"ex-b.com":  `{"suppliers": ["ex-a.com", "dsp-b.com"]}`,
+		"dsp-a.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`,
+		"dsp-b.com": `{"adverts": [{"advertiserURL": "b.com", "cpm": 2}]}`})
+	n := transact(t, c, &swan.Offer{})
+	a := childFrom(t, n, "ex-a.com")
+	if a == nil {
+		t.Fatal("ex-a.com missing")
+	}
+	b := childFrom(t, a, "ex-b.com")
+	if b == nil {
+		t.Fatal("ex-b.com missing")
+	}
+	f := failedFrom(t, c, b, "ex-b.com", "ex-a.com")
+	if f == nil || f.Error != loopDetected {
+		t.Fatal("expected ex-b.com to refuse to call ex-a.com again")
+	}
+	w, err := bidOf(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w == nil || w.AdvertiserURL != "b.com" {
+		t.Fatalf("expected the bid from dsp-b.com to win, got %+v", w)
+	}
+}
+
+// TestProcessorInChain checks that a processor sent a tree where it is
+// already one of the processors refuses with a failed node it signed.
+func TestProcessorInChain(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":  `{}`,
+		"ex-a.com": `{"suppliers": ["dsp.com"]}`,
+		"dsp.com":  `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	d := c.FindDomain("ex-a.com")
+	r := newOffer(t, c.FindDomain("pub.com"), &swan.Offer{})
+	o, err := r.GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := r
+	for _, h := range []string{"pub.com", "ex-a.com", "ssp.com"} {
+		n := newSignedNode(t, h, nil, o)
+		p.AddChild(n)
+		p = n
+	}
+	f, _, err := handleTransaction(d, r, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddChild(f)
+	v := failedFrom(t, c, p, "ex-a.com", "ex-a.com")
+	if v == nil || v.Error != loopDetected {
+		t.Fatal("expected ex-a.com to refuse to take part twice")
+	}
+	if c.FindDomain("dsp.com").Auctions().Bids() != 0 {
+		t.Fatal("supplier should not have been called")
+	}
+}
+
+// TestChainTooLong checks that a chain of processors longer than the maximum
+// depth is ended with a failed node signed over the offer.
+func TestChainTooLong(t *testing.T) {
+	m := map[string]string{"pub.com": `{"suppliers": ["ex-0.com"]}`}
+	for i := 0; i < maxChainDepth+2; i++ {
+		m[fmt.Sprintf("ex-%d.com", i)] = fmt.Sprintf(
+			`{"suppliers": ["ex-%d.com"]}`,
+			i+1)
+	}
+	c := newNetwork(t, m)
+	n := transact(t, c, &swan.Offer{})
+	var f *swan.Failed
+	h := 0
+	for p := childFrom(t, n, "ex-0.com"); p != nil; h++ {
+		e := fmt.Sprintf("ex-%d.com", h)
+		s := fmt.Sprintf("ex-%d.com", h+1)
+		f = failedFrom(t, c, p, e, s)
+		if f != nil {
+			break
+		}
+		p = childFrom(t, p, s)
+	}
+	if f == nil || f.Error != loopDetected {
+		t.Fatal("expected the chain to end with a failed node")
+	}
+	if h+2 != maxChainDepth {
+		t.Fatalf("expected %d processors, got %d", maxChainDepth, h+2)
+	}
+}
+
+// failingCreator is an OWID creator that can't sign.
+type failingCreator struct{ common.Creator }
+
+func (failingCreator) Sign(o *owid.OWID, others ...*owid.OWID) error {
+	return errors.New("sign failed")
+}
+
+// TestCreateFailedSignError checks that an error signing the failed node is
+// returned rather than an unsigned node.
+func TestCreateFailedSignError(t *testing.T) {
+	c := newNetwork(t, map[string]string{"ex-a.com": `{}`})
+	d := c.FindDomain("ex-a.com")
+	d.OWID = failingCreator{d.OWID}
+	r := newNode(t, "pub.com", nil)
+	n, err := createFailed(d, r, "dsp.com", loopDetected)
+	if err == nil || n != nil {
+		t.Fatal("expected the signing error")
+	}
+}
@@ -0,0 +1,139 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"crypto/rand"
+	"demotest"
+	"owid"
+	"swan"
+	"testing"
+)
+
+// newNetwork returns a configuration for the test whose domains are served by
+// a test server using the OpenRTB handler. Requests to any host are sent to
+// the server so processors call each other as they would in the demo.
+// Domains are created from the JSON configurations of the hosts. Notices
+// still being sent when the test finishes are waited for so they don't reach
+// the domains of the next test.
+func newNetwork(t *testing.T, domains map[string]string) *common.Configuration {
+	t.Helper()
+	c := demotest.NewConfig()
+	for h, j := range domains {
+		d := demotest.NewDomain(t, c, h, j)
+		d.SetHandler(Handler)
+	}
+	demotest.Serve(t, c)
+	t.Cleanup(notices.Wait)
+	return c
+}
+
+// newOffer returns the root node of a new tree for the offer signed by the
+// publisher.
+func newOffer(t *testing.T, pub *common.Domain, o *swan.Offer) *owid.Node {
+	t.Helper()
+	o.PubDomain = pub.Host
+	if o.UUID == nil {
+		o.UUID = make([]byte, 16)
+		_, err := rand.Read(o.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := o.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := pub.OWID.CreateOWID(b)
+	err = pub.OWID.Sign(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n owid.Node
+	n.OWID, err = r.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &n
+}
+
+// transact runs the transaction for the offer with the publisher making the
+// final decision and returns the publisher's node.
+func transact(
+	t *testing.T,
+	c *common.Configuration,
+	o *swan.Offer) *owid.Node {
+	t.Helper()
+	p := c.FindDomain("pub.com")
+	n, err := HandleTransaction(p, newOffer(t, p, o), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// childFrom returns the child of the node whose OWID is from the domain, or
+// nil if there isn't one.
+func childFrom(t *testing.T, n *owid.Node, domain string) *owid.Node {
+	t.Helper()
+	for _, c := range n.Children {
+		if o, err := c.GetOWID(); err == nil && o.Domain == domain {
+			return c
+		}
+	}
+	return nil
+}
+
+// failedFrom returns the failed payload of the child from the domain for
+// the host after checking the child was signed over the root, or nil if
+// there isn't one.
+func failedFrom(
+	t *testing.T,
+	c *common.Configuration,
+	n *owid.Node,
+	domain string,
+	host string) *swan.Failed {
+	t.Helper()
+	for _, i := range n.Children {
+		o, err := i.GetOWID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := common.PayloadFromOWID(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, ok := p.(*swan.Failed)
+		if o.Domain != domain || ok == false || f.Host != host {
+			continue
+		}
+		r, err := n.GetRoot().GetOWID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := c.VerifyOWID(o, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v == false {
+			t.Fatalf("failed node from '%s' not signed over the offer", domain)
+		}
+		return f
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"bytes"
+	"common"
+	"fmt"
+	"net/http"
+	"net/url"
+	"owid"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// noticePath is the path for win, billing and loss notices to processors.
+const noticePath = "/demo/api/v1/notice/"
+
+// Types of notice which are the last segment of the notice path.
+const (
+	noticeWin  = "win"
+	noticeBill = "bill"
+	noticeLoss = "loss"
+)
+
+// OpenRTB substitution macros used in the notification URLs.
+const (
+	macroAuctionID    = "${AUCTION_ID}"
+	macroAuctionPrice = "${AUCTION_PRICE}"
+	macroAuctionLoss  = "${AUCTION_LOSS}"
+)
+
+// noticeParameter is the query parameter of a notice that contains the OWID
+// of the processor that sent it. The OWID signs the fields of the notice so
+// the receiver can check they came from the processor it offered the bid to.
+const noticeParameter = "owid"
+
+// noticeTimeout is the time a processor has to respond to a notice.
+const noticeTimeout = 5 * time.Second
+
+// pendingLimit is the number of auctions each processor waits for notices
+// about from its parent.
+const pendingLimit = 10000
+
+// noticeClient sends the notices. Notices are sent in the background so a
+// processor that does not respond must not hold on to the connection.
+var noticeClient = &http.Client{Timeout: noticeTimeout}
+
+// notices counts the notices being sent in the background.
+var notices sync.WaitGroup
+
+// notice contains the OpenRTB 2.6 bid fields for the price of a bid and the
+// URLs to notify the bidder if the bid wins, is billed, or loses. The fields
+// are part of the bidder's signed Bid payload.
+type notice struct {
+	Price float64 // Price per thousand impressions
+	NURL  string  // Win notice URL
+	BURL  string  // Billing notice URL
+	LURL  string  // Loss notice URL
+}
+
+// newNotice returns the bid fields for a bid at the price from the domain.
+func newNotice(d *common.Domain, price float64) *notice {
+	u := func(t string, q string) string {
+		return d.Config.Scheme + "://" + d.Host + noticePath + t +
+			"?id=" + macroAuctionID + q
+	}
+	return &notice{
+		Price: price,
+		NURL:  u(noticeWin, "&price="+macroAuctionPrice),
+		BURL:  u(noticeBill, "&price="+macroAuctionPrice),
+		LURL: u(
+			noticeLoss,
+			"&price="+macroAuctionPrice+"&reason="+macroAuctionLoss)}
+}
+
+// noticeOf returns the bid fields from the payload of the node, or nil if the
+// node is not a bid.
+func noticeOf(n *owid.Node) (*notice, error) {
+	p, err := common.PayloadFromNode(n)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := p.(*common.Bid)
+	if ok == false {
+		return nil, nil
+	}
+	return &notice{b.Price, b.NURL, b.BURL, b.LURL}, nil
+}
+
+// price returns the price of the bid or zero if there is no notice.
+func (b *notice) price() float64 {
+	if b == nil {
+		return 0
+	}
+	return b.Price
+}
+
+// setBid sets the price and notice URLs of the bid payload.
+func (b *notice) setBid(p *common.Bid) {
+	p.Price = b.Price
+	p.NURL = b.NURL
+	p.BURL = b.BURL
+	p.LURL = b.LURL
+}
+
+// noticePayload returns the fields of a notice that are signed by the sender.
+// The host of the receiver is included so a notice can't be sent on to
+// another processor in the same auction.
+func noticePayload(
+	t string,
+	host string,
+	id string,
+	price string,
+	reason string) []byte {
+	return []byte(strings.Join([]string{t, host, id, price, reason}, " "))
+}
+
+// fire sends the notice in the background.
+func fire(
+	d *common.Domain,
+	t string,
+	u string,
+	id string,
+	price float64,
+	reason int) {
+	notices.Add(1)
+	go func() {
+		defer notices.Done()
+		send(d, t, u, id, price, reason)
+	}()
+}
+
+// send requests the notification URL of the type after substituting the
+// macros. The notice is signed by the domain. Failures are ignored as the
+// bidder can't change the outcome of the auction.
+func send(
+	d *common.Domain,
+	t string,
+	u string,
+	id string,
+	price float64,
+	reason int) {
+	if u == "" {
+		return
+	}
+	p := strconv.FormatFloat(price, 'f', -1, 64)
+	var c string
+	if t == noticeLoss {
+		c = strconv.Itoa(reason)
+	}
+	x, err := url.Parse(strings.NewReplacer(
+		macroAuctionID, url.QueryEscape(id),
+		macroAuctionPrice, p,
+		macroAuctionLoss, c).Replace(u))
+	if err != nil {
+		return
+	}
+	o := d.OWID.CreateOWID(noticePayload(t, x.Host, id, p, c))
+	if o == nil || d.OWID.Sign(o) != nil {
+		return
+	}
+	q := x.Query()
+	q.Set(noticeParameter, o.AsString())
+	x.RawQuery = q.Encode()
+	res, err := noticeClient.Get(x.String())
+	if err == nil {
+		res.Body.Close()
+	}
+}
+
+// pendingAuction is the outcome of an auction run by a processor that is
+// waiting for a notice from the processor's parent.
+type pendingAuction struct {
+	parent string      // Domain the bid was offered to that sends the notices
+	own    bool        // True if the domain's own bid was offered to the parent
+	child  *notice     // The bid fields of the winning supplier if not own bid
+	hop    *common.Hop // The prices received and passed on if not own bid
+	won    bool        // True once the win notice has been handled
+	path   []string    // Processors from the publisher if own bid
+	// The advert of the own bid and the CBID to count the impression against
+	// for frequency caps, or empty if the bid was not personalized
+	advert *common.Advert
+	cbid   string
+	// The campaign spend held for the own bid, or nil if the advert is not
+	// part of a campaign
+	reservation *common.Reservation
+}
+
+// win records the win of the domain's own bid at the clearing price. The
+// campaign is charged the clearing price from the spend held for the bid and
+// the impression counts towards the advert's frequency caps for the CBID.
+func (a *pendingAuction) win(d *common.Domain, price float64) error {
+	d.Auctions().RecordWin(price, a.path)
+	if a.reservation != nil {
+		a.reservation.Commit(price)
+	}
+	if a.cbid != "" {
+		return d.Frequency().Record(a.cbid, a.advert)
+	}
+	return nil
+}
+
+// lose records the loss of the domain's own bid for the reason and releases
+// the campaign spend held for the bid.
+func (a *pendingAuction) lose(d *common.Domain, reason int) {
+	d.Auctions().RecordLoss(reason)
+	if a.reservation != nil {
+		a.reservation.Release()
+	}
+}
+
+// supplierPrice returns the price to tell the supplier of the winning bid
+// when the parent's price is the price passed on. The fee taken by this
+// processor is added back.
+func (a *pendingAuction) supplierPrice(p float64) float64 {
+	if a.hop == nil || a.hop.Passed == 0 {
+		return p
+	}
+	return p * a.hop.Received / a.hop.Passed
+}
+
+// verifyNotice checks that the notice in the query was signed by the parent
+// the bid was offered to and that the signed fields match the query.
+func (a *pendingAuction) verifyNotice(
+	d *common.Domain,
+	t string,
+	q url.Values) error {
+	o, err := owid.FromBase64(q.Get(noticeParameter))
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(o.Domain, a.parent) == false {
+		return fmt.Errorf(
+			"Notice from '%s' but bid offered to '%s'",
+			o.Domain,
+			a.parent)
+	}
+	p := noticePayload(t, d.Host, q.Get("id"), q.Get("price"), q.Get("reason"))
+	if bytes.Equal(o.Payload, p) == false {
+		return fmt.Errorf("Notice does not match the fields signed")
+	}
+	v, err := d.Config.VerifyOWID(o, nil)
+	if err != nil {
+		return err
+	}
+	if v == false {
+		return fmt.Errorf("Notice signature from '%s' invalid", o.Domain)
+	}
+	return nil
+}
+
+// notice handles the first notice of the type for the auction. If the
+// domain's own bid was offered then the statistics are updated, otherwise the
+// notice is passed to the supplier of the winning bid. The notice is passed
+// on before returning so that the supplier receives the notices in the order
+// they were sent.
+func (a *pendingAuction) notice(
+	d *common.Domain,
+	t string,
+	id string,
+	price float64,
+	reason int) error {
+	switch t {
+	case noticeWin:
+		if a.own {
+			return a.win(d, price)
+		}
+		send(d, t, a.child.NURL, id, a.supplierPrice(price), 0)
+	case noticeBill:
+		if a.own {
+			d.Auctions().RecordBilled()
+		} else {
+			send(d, t, a.child.BURL, id, a.supplierPrice(price), 0)
+		}
+	case noticeLoss:
+		if a.own {
+			a.lose(d, reason)
+		} else {
+			send(d, t, a.child.LURL, id, price, reason)
+		}
+	}
+	return nil
+}
+
+// pendingAuctions holds the auctions waiting for notices for each domain, and
+// the auctions that have been completed by a billing or loss notice so that
+// repeated notices are ignored.
+type pendingAuctions struct {
+	mutex     sync.Mutex
+	auctions  map[string]*pendingAuction // Host and auction ID to auction
+	order     []string                   // Keys in the order added
+	completed map[string]bool            // Keys of completed auctions
+	done      []string                   // Completed keys in the order added
+}
+
+var pending = pendingAuctions{
+	auctions:  make(map[string]*pendingAuction),
+	completed: make(map[string]bool)}
+
+func (p *pendingAuctions) add(host string, id string, a *pendingAuction) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.order) >= pendingLimit {
+		delete(p.auctions, p.order[0])
+		p.order = p.order[1:]
+	}
+	k := host + " " + id
+	p.auctions[k] = a
+	p.order = append(p.order, k)
+}
+
+// get returns the pending auction, or nil if there isn't one. If the auction
+// is not pending then true is returned if it has already been completed.
+func (p *pendingAuctions) get(host string, id string) (*pendingAuction, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	k := host + " " + id
+	return p.auctions[k], p.completed[k]
+}
+
+// mark records the notice of the type for the pending auction and returns
+// true if the notice should be handled, or false if it repeats a notice
+// already handled. The auction remains pending after a win notice for the
+// billing notice. A billing or loss notice completes the auction. A loss
+// notice after a win is ignored.
+func (p *pendingAuctions) mark(
+	host string,
+	id string,
+	a *pendingAuction,
+	t string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	k := host + " " + id
+	if p.auctions[k] != a {
+		return false
+	}
+	if t == noticeWin {
+		if a.won {
+			return false
+		}
+		a.won = true
+		return true
+	}
+	delete(p.auctions, k)
+	if len(p.done) >= pendingLimit {
+		delete(p.completed, p.done[0])
+		p.done = p.done[1:]
+	}
+	p.completed[k] = true
+	p.done = append(p.done, k)
+	return t == noticeBill || a.won == false
+}
+
+// notifyWinner tells the supplier of the winning bid that it has won and then
+// that it is billable. Used by the processor that makes the final decision.
+func notifyWinner(d *common.Domain, id string, b *notice) {
+	notices.Add(1)
+	go func() {
+		defer notices.Done()
+		send(d, noticeWin, b.NURL, id, b.price(), 0)
+		send(d, noticeBill, b.BURL, id, b.price(), 0)
+	}()
+}
+
+// notifyLosers tells the suppliers of the losing bids the reason they lost.
+func notifyLosers(
+	d *common.Domain,
+	id string,
+	b []*notice,
+	losses map[int]int,
+	price float64) {
+	for i, r := range losses {
+		if b[i] != nil {
+			fire(d, noticeLoss, b[i].LURL, id, price, r)
+		}
+	}
+}
+
+// handlerNotice receives a notice for an auction the domain took part in. The
+// notice must be signed by the processor the bid was offered to. Each notice
+// is handled once for the auction and repeated notices are ignored.
+func handlerNotice(d *common.Domain, w http.ResponseWriter, r *http.Request) {
+	t := path.Base(r.URL.Path)
+	if t != noticeWin && t != noticeBill && t != noticeLoss {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	id := q.Get("id")
+	p, err := strconv.ParseFloat(q.Get("price"), 64)
+	if err != nil {
+		common.ReturnStatusCodeError(d.Config, w, err, http.StatusBadRequest)
+		return
+	}
+	var c int
+	if t == noticeLoss {
+		c, err = strconv.Atoi(q.Get("reason"))
+		if err != nil {
+			common.ReturnStatusCodeError(
+				d.Config,
+				w,
+				err,
+				http.StatusBadRequest)
+			return
+		}
+	}
+	a, done := pending.get(d.Host, id)
+	if a == nil {
+		if done {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		common.ReturnStatusCodeError(
+			d.Config,
+			w,
+			fmt.Errorf("Auction '%s' not pending", id),
+			http.StatusNotFound)
+		return
+	}
+	err = a.verifyNotice(d, t, q)
+	if err != nil {
+		common.ReturnStatusCodeError(d.Config, w, err, http.StatusForbidden)
+		return
+	}
+	if pending.mark(d.Host, id, a, t) {
+		err = a.notice(d, t, id, p, c)
+		if err != nil {
+			common.ReturnServerError(d.Config, w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,337 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"swan"
+	"testing"
+)
+
+// noticeURL returns the URL of the notice of the type for the auction to the
+// domain, signed by the sender.
+func noticeURL(
+	t *testing.T,
+	from *common.Domain,
+	to *common.Domain,
+	n string,
+	id string,
+	price float64,
+	reason string) string {
+	t.Helper()
+	p := strconv.FormatFloat(price, 'f', -1, 64)
+	o := from.OWID.CreateOWID(noticePayload(n, to.Host, id, p, reason))
+	err := from.OWID.Sign(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := url.Values{"id": {id}, "price": {p}, noticeParameter: {o.AsString()}}
+	if reason != "" {
+		q.Set("reason", reason)
+	}
+	return "http://" + to.Host + noticePath + n + "?" + q.Encode()
+}
+
+// serveNotice sends the notice URL to the domain and returns the status code.
+func serveNotice(d *common.Domain, u string) int {
+	w := httptest.NewRecorder()
+	handlerNotice(d, w, httptest.NewRequest("GET", u, nil))
+	return w.Code
+}
+
+// sendNotice sends the notice of the type for the auction to the domain
+// signed by the publisher and returns the status code of the response.
+func sendNotice(
+	t *testing.T,
+	d *common.Domain,
+	n string,
+	id string,
+	price float64) int {
+	t.Helper()
+	p := d.Config.FindDomain("pub.com")
+	return serveNotice(d, noticeURL(t, p, d, n, id, price, ""))
+}
+
+// bidForNotices returns the DSP of a network where the publisher's only
+// supplier is the DSP, and the offer the DSP has bid on.
+func bidForNotices(t *testing.T) (*common.Domain, *swan.Offer) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":   `{"suppliers": ["dsp.com"]}`,
+		"other.com": `{}`,
+		"dsp.com":   `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	d := c.FindDomain("dsp.com")
+	o := &swan.Offer{}
+	_, b, err := handleTransaction(
+		d,
+		newOffer(t, c.FindDomain("pub.com"), o),
+		nil,
+		false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatal("expected a bid")
+	}
+	return d, o
+}
+
+// TestNoticeWin checks that a win notice from the publisher is handled.
+func TestNoticeWin(t *testing.T) {
+	d, o := bidForNotices(t)
+	if s := sendNotice(t, d, noticeWin, auctionID(o), 1); s != http.StatusNoContent {
+		t.Fatalf("win notice returned %d", s)
+	}
+	if d.Auctions().Wins() != 1 {
+		t.Fatal("win not recorded")
+	}
+}
+
+// TestNoticeIdempotent checks that repeated notices for an auction are only
+// handled once, and that the auction is no longer pending once billed.
+func TestNoticeIdempotent(t *testing.T) {
+	d, o := bidForNotices(t)
+	id := auctionID(o)
+	for _, n := range []string{noticeWin, noticeWin, noticeBill, noticeBill, noticeWin} {
+		if s := sendNotice(t, d, n, id, 1); s != http.StatusNoContent {
+			t.Fatalf("%s notice returned %d", n, s)
+		}
+	}
+	if d.Auctions().Wins() != 1 || d.Auctions().Billed() != 1 {
+		t.Fatalf("expected 1 win and 1 bill, got %d and %d",
+			d.Auctions().Wins(),
+			d.Auctions().Billed())
+	}
+	if a, done := pending.get(d.Host, id); a != nil || done == false {
+		t.Fatal("billed auction still pending")
+	}
+}
+
+// TestNoticeLossAfterWin checks that a loss notice can't undo a win.
+func TestNoticeLossAfterWin(t *testing.T) {
+	d, o := bidForNotices(t)
+	id := auctionID(o)
+	p := d.Config.FindDomain("pub.com")
+	sendNotice(t, d, noticeWin, id, 1)
+	serveNotice(d, noticeURL(t, p, d, noticeLoss, id, 1, "102"))
+	if d.Auctions().Wins() != 1 || len(d.Auctions().Losses()) != 0 {
+		t.Fatal("loss after win should be ignored")
+	}
+}
+
+// TestNoticeAuthentication checks that notices not signed by the processor
+// the bid was offered to, or that don't match the fields signed, are refused.
+func TestNoticeAuthentication(t *testing.T) {
+	d, o := bidForNotices(t)
+	id := auctionID(o)
+	p := d.Config.FindDomain("pub.com")
+	u, err := url.Parse(noticeURL(t, p, d, noticeWin, id, 1, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("price", "0.01")
+	tampered := *u
+	tampered.RawQuery = q.Encode()
+	q = u.Query()
+	q.Del(noticeParameter)
+	unsigned := *u
+	unsigned.RawQuery = q.Encode()
+	for n, v := range map[string]string{
+		"unsigned":   unsigned.String(),
+		"tampered":   tampered.String(),
+		"other":      noticeURL(t, d.Config.FindDomain("other.com"), d, noticeWin, id, 1, ""),
+		"wrong type": strings.Replace(u.String(), "/win?", "/bill?", 1)} {
+		if s := serveNotice(d, v); s != http.StatusForbidden && s != http.StatusBadRequest {
+			t.Errorf("%s notice returned %d", n, s)
+		}
+	}
+	if d.Auctions().Wins() != 0 || d.Auctions().Billed() != 0 {
+		t.Fatal("refused notices changed the statistics")
+	}
+	if s := serveNotice(d, u.String()); s != http.StatusNoContent {
+		t.Fatalf("signed notice returned %d", s)
+	}
+}
+
+// TestNoticeChain checks that the notices from the publisher reach the DSP
+// through an exchange with the exchange's fee added back to the price, and
+// that the price and notice URLs are part of the signed bids.
+func TestNoticeChain(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":      `{"suppliers": ["exchange.com", "dsp-b.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp-a.com"], "takeRate": 0.5}`,
+		"dsp-a.com":    `{"adverts": [{"advertiserURL": "a.com", "cpm": 4}]}`,
+		"dsp-b.com":    `{"adverts": [{"advertiserURL": "b.com", "cpm": 1}]}`})
+	n := transact(t, c, &swan.Offer{})
+	notices.Wait()
+	e := childFrom(t, n, "exchange.com")
+	if e == nil {
+		t.Fatal("exchange missing")
+	}
+	x, err := noticeOf(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x == nil || x.Price != 2 ||
+		strings.HasPrefix(x.NURL, "http://exchange.com"+noticePath) == false {
+		t.Fatalf("expected the exchange's signed price and notices, got %+v", x)
+	}
+	a := c.FindDomain("dsp-a.com").Auctions()
+	if a.Wins() != 1 || a.Billed() != 1 || a.Spend() != 0.004 {
+		t.Fatalf("expected dsp-a.com to win and be billed 0.004, got %d %d %v",
+			a.Wins(),
+			a.Billed(),
+			a.Spend())
+	}
+	b := c.FindDomain("dsp-b.com").Auctions()
+	if b.Wins() != 0 || len(b.Losses()) != 1 {
+		t.Fatal("expected dsp-b.com to receive a loss notice")
+	}
+}
+
+// TestFrequencyRecordedOnWin checks that an impression only counts towards a
+// frequency cap when the bid wins, and not when the bid is made.
+func TestFrequencyRecordedOnWin(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["dsp.com"]}`,
+		"dsp.com": `{"adverts": [{
+			"id": "a",
+			"advertiserURL": "a.com",
+			"cpm": 1,
+			"caps": [{"count": 1, "period": "1h"}]}]}`})
+	d := c.FindDomain("dsp.com")
+	o := &swan.Offer{
+		CBID:        []byte("cbid"),
+		Preferences: []byte(common.PurposePersonalizedAds)}
+	_, b, err := handleTransaction(
+		d,
+		newOffer(t, c.FindDomain("pub.com"), o),
+		nil,
+		false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatal("expected a bid")
+	}
+	a := &d.Adverts[0]
+	if d.Frequency().IsCapped("cbid", a) {
+		t.Fatal("impression recorded before the win notice")
+	}
+	if s := sendNotice(t, d, noticeWin, auctionID(o), 1); s != http.StatusNoContent {
+		t.Fatalf("win notice returned %d", s)
+	}
+	if d.Frequency().IsCapped("cbid", a) == false {
+		t.Fatal("impression not recorded by the win notice")
+	}
+}
+
+// TestCampaignChargedOnWin checks that a campaign is charged the clearing
+// price when its bid wins and not when the bid is made, and that it stops
+// bidding once the budget is exhausted.
+func TestCampaignChargedOnWin(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["dsp.com"]}`,
+		"dsp.com": `{"advertisers": ["adv.com"]}`,
+		"adv.com": `{"campaigns": [{
+			"name": "Test",
+			"cpm": 2,
+			"totalBudget": 0.0025,
+			"adverts": [{"mediaURL": "adv.com/a.png"}]}]}`})
+	d := c.FindDomain("dsp.com")
+	m := &c.FindDomain("adv.com").Campaigns[0]
+	bid := func() (*swan.Offer, *notice) {
+		o := &swan.Offer{}
+		_, b, err := handleTransaction(
+			d,
+			newOffer(t, c.FindDomain("pub.com"), o),
+			nil,
+			false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o, b
+	}
+	o, b := bid()
+	if b == nil || b.Price != 2 {
+		t.Fatalf("expected a bid at the campaign CPM, got %+v", b)
+	}
+	if m.TotalSpend() != 0 {
+		t.Fatal("campaign charged before the win notice")
+	}
+	sendNotice(t, d, noticeWin, auctionID(o), 1.5)
+	if m.TotalSpend() != 0.0015 {
+		t.Fatalf("expected the clearing price to be charged, got %v",
+			m.TotalSpend())
+	}
+
+	// The remaining budget can't pay for another bid at the campaign's CPM.
+	if _, b = bid(); b != nil {
+		t.Fatal("exhausted campaign should not bid")
+	}
+}
+
+// TestOwnBidAgainstSuppliers checks that a processor's own bid only loses, and
+// releases the campaign spend held, when a supplier offers a higher price.
+func TestOwnBidAgainstSuppliers(t *testing.T) {
+	for _, s := range []float64{1, 5} {
+		c := newNetwork(t, map[string]string{
+			"pub.com": `{"suppliers": ["dsp.com"]}`,
+			"dsp.com": `{"advertisers": ["adv.com"], "suppliers": ["dsp-b.com"]}`,
+			"adv.com": `{"campaigns": [{
+				"name": "Test",
+				"cpm": 2,
+				"totalBudget": 0.002,
+				"adverts": [{"mediaURL": "adv.com/a.png"}]}]}`,
+			"dsp-b.com": fmt.Sprintf(
+				`{"adverts": [{"advertiserURL": "b.com", "cpm": %v}]}`,
+				s)})
+		d := c.FindDomain("dsp.com")
+		m := &c.FindDomain("adv.com").Campaigns[0]
+		_, b, err := handleTransaction(
+			d,
+			newOffer(t, c.FindDomain("pub.com"), &swan.Offer{}),
+			nil,
+			false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		notices.Wait()
+		l := c.FindDomain("dsp-b.com").Auctions().Losses()
+		if s < 2 {
+			if b.price() != 2 || len(d.Auctions().Losses()) != 0 {
+				t.Fatalf("own bid should win, got %+v", b)
+			}
+			if len(l) != 1 || m.CanSpend() {
+				t.Fatal("expected a supplier loss and the spend held")
+			}
+		} else {
+			if b.price() != s || len(d.Auctions().Losses()) != 1 {
+				t.Fatalf("supplier bid should win, got %+v", b)
+			}
+			if len(l) != 0 || m.CanSpend() == false {
+				t.Fatal("expected no supplier loss and the spend released")
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"owid"
+	"sync"
+)
+
+// categoryReplay is the category of demo domains that act as replay
+// attackers. They capture the first offer they receive and send it to their
+// suppliers in place of every later offer. Suppliers refuse the replayed
+// offer and the failed nodes appear in the audit.
+const categoryReplay = "Replay"
+
+// capturedOffer is the offer and OpenRTB fields captured by a replay attacker.
+type capturedOffer struct {
+	node    *owid.Node // The attacker's node in the captured tree
+	request *Request   // The OpenRTB fields sent with the captured offer
+}
+
+// captured holds the offer captured by each replay attacker.
+var captured = struct {
+	mutex  sync.Mutex
+	offers map[string]*capturedOffer // Host to the captured offer
+}{offers: make(map[string]*capturedOffer)}
+
+// replayOffer returns the node and OpenRTB fields to send to the suppliers.
+// For domains that are not replay attackers these are the ones provided.
+func replayOffer(
+	d *common.Domain,
+	n *owid.Node,
+	q *Request) (*owid.Node, *Request, error) {
+	if d.Category != categoryReplay {
+		return n, q, nil
+	}
+	captured.mutex.Lock()
+	defer captured.mutex.Unlock()
+	c := captured.offers[d.Host]
+	if c == nil {
+		p, err := copyPath(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		captured.offers[d.Host] = &capturedOffer{p, q}
+		return n, q, nil
+	}
+	return c.node, c.request, nil
+}
+
+// copyPath returns the copy of the node in a deep copy of the path from the
+// root to the node. The copy does not change when suppliers are added to the
+// node.
+func copyPath(n *owid.Node) (*owid.Node, error) {
+	j, err := pathFromRoot(n).AsJSON()
+	if err != nil {
+		return nil, err
+	}
+	r, err := owid.NodeFromJSON(j)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetLeaf()
+}
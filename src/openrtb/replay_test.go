@@ -0,0 +1,237 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"owid"
+	"swan"
+	"testing"
+	"time"
+)
+
+// newCallerTree returns a tree for the offer with the node of the caller
+// signed over the offer, and the caller's node.
+func newCallerTree(
+	t *testing.T,
+	c *common.Configuration,
+	r *owid.Node,
+	caller string) *owid.Node {
+	t.Helper()
+	o, err := r.GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newSignedNode(t, caller, nil, o)
+	r.AddChild(n)
+	return n
+}
+
+// TestExpiredOffer checks that a processor refuses an offer created longer
+// ago than the offer window with a failed node it signed.
+func TestExpiredOffer(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	p := c.FindDomain("pub.com")
+	f := swan.Offer{PubDomain: p.Host, UUID: []byte("expired")}
+	b, err := f.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := p.OWID.CreateOWID(b)
+	o.Date = time.Now().Add(-c.OfferWindow() - time.Minute)
+	err = p.OWID.Sign(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r owid.Node
+	r.OWID, err = o.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newCallerTree(t, c, &r, "pub.com")
+	x, _, err := handleTransaction(c.FindDomain("dsp.com"), &r, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.AddChild(x)
+	v := failedFrom(t, c, n, "dsp.com", "dsp.com")
+	if v == nil || v.Error != expiredOffer {
+		t.Fatal("expected the expired offer to be refused")
+	}
+	if a := c.FindDomain("dsp.com").Auctions(); a.Bids() != 0 {
+		t.Fatal("expected no bid for the expired offer")
+	}
+}
+
+// TestForgedOffer checks that an offer whose date was changed after it was
+// signed is refused before the date or the ID are used, so the offer can't get
+// around the offer window or stop the real offer with the same ID being used.
+func TestForgedOffer(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	p := c.FindDomain("pub.com")
+	d := c.FindDomain("dsp.com")
+	f := swan.Offer{PubDomain: p.Host, UUID: []byte("forged")}
+	b, err := f.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := p.OWID.CreateOWID(b)
+	o.Date = time.Now().Add(-c.OfferWindow() - time.Minute)
+	err = p.OWID.Sign(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Date = time.Now()
+	var r owid.Node
+	r.OWID, err = o.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newCallerTree(t, c, &r, "pub.com")
+	x, _, err := handleTransaction(d, &r, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.AddChild(x)
+	v := failedFrom(t, c, n, "dsp.com", "dsp.com")
+	if v == nil || v.Error != invalidOffer {
+		t.Fatal("expected the forged offer to be refused")
+	}
+	g := newOffer(t, p, &swan.Offer{UUID: f.UUID})
+	newCallerTree(t, c, g, "pub.com")
+	_, a, err := handleTransaction(d, g, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == nil {
+		t.Fatal("expected a bid for the real offer with the same ID")
+	}
+}
+
+// TestReplayedOffer checks that an offer sent again after the time for
+// duplicates from other supply paths is refused as a replay.
+func TestReplayedOffer(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{}`,
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	d := c.FindDomain("dsp.com")
+	r := newOffer(t, c.FindDomain("pub.com"), &swan.Offer{})
+	j, err := r.AsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCallerTree(t, c, r, "pub.com")
+	_, b, err := handleTransaction(d, r, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatal("expected a bid for the offer")
+	}
+	w := duplicateWindow
+	duplicateWindow = 0
+	defer func() { duplicateWindow = w }()
+	r, err = owid.NodeFromJSON(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newCallerTree(t, c, r, "pub.com")
+	x, _, err := handleTransaction(d, r, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.AddChild(x)
+	v := failedFrom(t, c, n, "dsp.com", "dsp.com")
+	if v == nil || v.Error != replayedOffer {
+		t.Fatal("expected the replayed offer to be refused")
+	}
+	if d.Auctions().Bids() != 1 {
+		t.Fatalf("expected one bid, got %d", d.Auctions().Bids())
+	}
+}
+
+// TestReplayAttacker checks that the offer a replay attacker captures is not
+// changed by the rest of the transaction, and that suppliers refuse the
+// captured offer when it is replayed in later transactions.
+func TestReplayAttacker(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":    `{"suppliers": ["replay.com"]}`,
+		"replay.com": `{"category": "Replay", "suppliers": ["dsp.com"]}`,
+		"dsp.com":    `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	defer func() {
+		captured.mutex.Lock()
+		delete(captured.offers, "replay.com")
+		captured.mutex.Unlock()
+	}()
+	w := duplicateWindow
+	duplicateWindow = 0
+	defer func() { duplicateWindow = w }()
+	b, err := bidOf(transact(t, c, &swan.Offer{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatal("expected a bid for the first offer")
+	}
+	a := captured.offers["replay.com"].node
+	if len(a.Children) != 0 || len(a.GetRoot().Children) != 1 {
+		t.Fatal("captured offer changed by the transaction")
+	}
+	n := transact(t, c, &swan.Offer{})
+	e := childFrom(t, n, "replay.com")
+	if e == nil {
+		t.Fatal("replay.com missing")
+	}
+	x := childFrom(t, e, "dsp.com")
+	if x == nil {
+		t.Fatal("dsp.com missing")
+	}
+	o, err := x.GetOWID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := common.PayloadFromOWID(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := p.(*swan.Failed); ok == false || f.Error != replayedOffer {
+		t.Fatalf("expected dsp.com to refuse the replayed offer, got %+v", p)
+	}
+
+	// The failed node is signed over the captured offer rather than the offer
+	// of the transaction which shows the attack in the audit.
+	for _, r := range []*owid.Node{a.GetRoot(), n.GetRoot()} {
+		f, err := r.GetOWID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := c.VerifyOWID(o, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != (r == a.GetRoot()) {
+			t.Fatal("expected the failed node to be signed over the captured offer")
+		}
+	}
+	if d := c.FindDomain("dsp.com").Auctions(); d.Bids() != 1 {
+		t.Fatalf("expected one bid, got %d", d.Bids())
+	}
+}
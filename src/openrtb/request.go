@@ -0,0 +1,250 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"swan"
+	"tcf"
+)
+
+// requestHeader is the HTTP header used to pass the OpenRTB fields between
+// processors alongside the OWID tree in the request body.
+const requestHeader = "X-OpenRTB-Request"
+
+// AdCOM device types used in the device object.
+const (
+	deviceTypeDesktop = 2
+	deviceTypePhone   = 4
+	deviceTypeTablet  = 5
+)
+
+// Request contains the OpenRTB 2.6 fields of a bid request that are not part
+// of the SWAN offer. The consent fields are used by TCF and GPP aware
+// processors to read the user's choices, the device fields are used for
+// campaign targeting, and the impression describes the placement.
+type Request struct {
+	Imp    []imp    `json:"imp,omitempty"`
+	BCat   []string `json:"bcat,omitempty"` // Blocked advertiser categories
+	Regs   regs     `json:"regs"`
+	User   user     `json:"user"`
+	Device device   `json:"device"`
+}
+
+type imp struct {
+	TagID    string  `json:"tagid"`              // The placement ID
+	Banner   *banner `json:"banner,omitempty"`   // Set if banners allowed
+	Video    *video  `json:"video,omitempty"`    // Set if video allowed
+	Native   *native `json:"native,omitempty"`   // Set if native allowed
+	BidFloor float64 `json:"bidfloor,omitempty"` // Minimum CPM
+}
+
+type banner struct {
+	Format []format `json:"format"`
+}
+
+type format struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type video struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type native struct {
+	Ver string `json:"ver"`
+}
+
+type regs struct {
+	Ext regsExt `json:"ext"`
+}
+
+type regsExt struct {
+	GDPR   int    `json:"gdpr"`              // 1 if GDPR applies
+	GPP    string `json:"gpp,omitempty"`     // The GPP string
+	GPPSID []int  `json:"gpp_sid,omitempty"` // Sections in the GPP string
+}
+
+type user struct {
+	Ext userExt `json:"ext"`
+}
+
+type userExt struct {
+	Consent string `json:"consent"` // The TCF v2 TC string
+}
+
+type device struct {
+	DeviceType int `json:"devicetype,omitempty"` // AdCOM device type
+}
+
+// NewRequest creates the request fields from the TC and GPP strings and the
+// type of device the advert will be displayed on.
+func NewRequest(tcString string, gpp string, deviceType string) *Request {
+	var c Request
+	c.Regs.Ext.GDPR = 1
+	c.User.Ext.Consent = tcString
+	if gpp != "" {
+		c.Regs.Ext.GPP = gpp
+		c.Regs.Ext.GPPSID = tcf.GPPSectionIDs()
+	}
+	switch deviceType {
+	case common.DeviceDesktop:
+		c.Device.DeviceType = deviceTypeDesktop
+	case common.DeviceMobile:
+		c.Device.DeviceType = deviceTypePhone
+	case common.DeviceTablet:
+		c.Device.DeviceType = deviceTypeTablet
+	}
+	return &c
+}
+
+// AddPlacement sets the impression and blocked categories from the placement.
+func (c *Request) AddPlacement(p *common.Placement) {
+	var i imp
+	i.TagID = p.ID
+	i.BidFloor = p.Floor
+	for _, f := range p.AllowedFormats() {
+		switch f {
+		case common.FormatBanner:
+			i.Banner = &banner{}
+			for _, s := range p.Sizes {
+				i.Banner.Format = append(
+					i.Banner.Format,
+					format{s.Width, s.Height})
+			}
+		case common.FormatVideo:
+			i.Video = &video{p.Size().Width, p.Size().Height}
+		case common.FormatNative:
+			i.Native = &native{"1.2"}
+		}
+	}
+	c.Imp = []imp{i}
+	c.BCat = p.BlockedCategories
+}
+
+// Placement returns the placement described by the impression, or nil if
+// there is no impression.
+func (c *Request) Placement() *common.Placement {
+	if c == nil || len(c.Imp) == 0 {
+		return nil
+	}
+	i := &c.Imp[0]
+	var p common.Placement
+	p.ID = i.TagID
+	p.Floor = i.BidFloor
+	p.BlockedCategories = c.BCat
+	if i.Banner != nil {
+		p.Formats = append(p.Formats, common.FormatBanner)
+		for _, f := range i.Banner.Format {
+			p.Sizes = append(
+				p.Sizes,
+				common.Size{Width: f.W, Height: f.H})
+		}
+	}
+	if i.Video != nil {
+		p.Formats = append(p.Formats, common.FormatVideo)
+		if len(p.Sizes) == 0 {
+			p.Sizes = append(
+				p.Sizes,
+				common.Size{Width: i.Video.W, Height: i.Video.H})
+		}
+	}
+	if i.Native != nil {
+		p.Formats = append(p.Formats, common.FormatNative)
+	}
+	return &p
+}
+
+// DeviceType returns the type of device as used in campaign targeting, or an
+// empty string if the device is not known.
+func (c *Request) DeviceType() string {
+	if c == nil {
+		return ""
+	}
+	switch c.Device.DeviceType {
+	case deviceTypeDesktop:
+		return common.DeviceDesktop
+	case deviceTypePhone:
+		return common.DeviceMobile
+	case deviceTypeTablet:
+		return common.DeviceTablet
+	}
+	return ""
+}
+
+// TCString returns the TC string or an empty string if there isn't one.
+func (c *Request) TCString() string {
+	if c == nil {
+		return ""
+	}
+	return c.User.Ext.Consent
+}
+
+// verify checks that the consent string agrees with the preferences in the
+// offer. Processors rely on the offer, so a consent string that grants more
+// than the offer indicates a tampered request.
+func (c *Request) verify(o *swan.Offer) error {
+	if c == nil || c.TCString() == "" {
+		return nil
+	}
+	t, err := tcf.DecodeTCString(c.TCString())
+	if err != nil {
+		return fmt.Errorf("'%s' invalid: %s", requestHeader, err.Error())
+	}
+	p := common.ParseConsent(o.PreferencesAsString())
+	for _, i := range t.Consent() {
+		if p.Has(i) == false {
+			return fmt.Errorf(
+				"TC string purpose '%s' not allowed in offer preferences",
+				i)
+		}
+	}
+	return nil
+}
+
+// setHeader adds the fields to the request to a supplier.
+func (c *Request) setHeader(r *http.Request) error {
+	if c == nil {
+		return nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(requestHeader, string(b))
+	return nil
+}
+
+// requestFromHTTP returns the OpenRTB fields from the HTTP request if present,
+// otherwise nil.
+func requestFromHTTP(r *http.Request) (*Request, error) {
+	h := r.Header.Get(requestHeader)
+	if h == "" {
+		return nil, nil
+	}
+	var c Request
+	err := json.Unmarshal([]byte(h), &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,88 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"net/http/httptest"
+	"reflect"
+	"swan"
+	"testing"
+)
+
+// TestRequestPlacement checks that a placement survives being passed to a
+// supplier in the request header.
+func TestRequestPlacement(t *testing.T) {
+	s := []common.Size{{Width: 728, Height: 90}, {Width: 320, Height: 50}}
+	p := common.Placement{
+		ID:                "top",
+		Sizes:             s,
+		Formats:           []string{common.FormatBanner, common.FormatVideo},
+		Floor:             1.5,
+		BlockedCategories: []string{"gambling"}}
+	q := NewRequest("", "", "")
+	q.AddPlacement(&p)
+	r := httptest.NewRequest("POST", "http://dsp.com"+openRTBPath, nil)
+	err := q.setHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := requestFromHTTP(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := c.Placement(); reflect.DeepEqual(*g, p) == false {
+		t.Fatalf("expected %+v, got %+v", p, *g)
+	}
+	var n *Request
+	if n.Placement() != nil {
+		t.Fatal("no request should have no placement")
+	}
+}
+
+// TestPlacementFiltersAdverts checks that suppliers only bid with adverts that
+// fit the placement, meet the floor and are not in a blocked category.
+func TestPlacementFiltersAdverts(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["dsp.com"]}`,
+		"dsp.com": `{"adverts": [
+			{"advertiserURL": "cheap.com", "cpm": 0.5},
+			{"advertiserURL": "casino.com", "cpm": 5, "category": "gambling"},
+			{"advertiserURL": "video.com", "cpm": 5, "format": "video"},
+			{"advertiserURL": "large.com", "cpm": 5, "width": 300, "height": 600},
+			{"advertiserURL": "cars.com", "cpm": 2, "width": 728, "height": 90}]}`})
+	p := c.FindDomain("pub.com")
+	q := NewRequest("", "", "")
+	q.AddPlacement(&common.Placement{
+		ID:                "top",
+		Sizes:             []common.Size{{Width: 728, Height: 90}},
+		Floor:             1,
+		BlockedCategories: []string{"gambling"}})
+	for i := 0; i < 10; i++ {
+		n, err := HandleTransaction(p, newOffer(t, p, &swan.Offer{}), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := bidOf(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b == nil || b.AdvertiserURL != "cars.com" {
+			t.Fatalf("expected the advert for cars.com, got %+v", b)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"common"
+	"net/http"
+	"swan"
+	"sync/atomic"
+	"testing"
+)
+
+// TestStoppedSupplier checks that a supplier stopped by the user is not called
+// and a signed failed node is added in its place.
+func TestStoppedSupplier(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com":      `{"suppliers": ["exchange.com"]}`,
+		"exchange.com": `{"suppliers": ["dsp-a.com", "dsp-b.com"]}`,
+		"dsp-a.com":    `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`,
+		"dsp-b.com":    `{"adverts": [{"advertiserURL": "b.com", "cpm": 2}]}`})
+	var called int32
+	c.FindDomain("dsp-b.com").SetHandler(func(
+		d *common.Domain,
+		w http.ResponseWriter,
+		r *http.Request) {
+		atomic.StoreInt32(&called, 1)
+		Handler(d, w, r)
+	})
+	o := &swan.Offer{Stopped: []string{"DSP-B.com|supplier"}}
+	n := transact(t, c, o)
+	e := childFrom(t, n, "exchange.com")
+	if e == nil {
+		t.Fatal("exchange missing")
+	}
+	f := failedFrom(t, c, e, "exchange.com", "dsp-b.com")
+	if f == nil || f.Error != stoppedByUser {
+		t.Fatal("stopped supplier should have a failed node")
+	}
+	if atomic.LoadInt32(&called) != 0 {
+		t.Fatal("stopped supplier should not have been called")
+	}
+	b, err := bidOf(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil || b.AdvertiserURL != "a.com" {
+		t.Fatalf("expected the bid from dsp-a.com to win, got %+v", b)
+	}
+}
+
+// TestStoppedCategory checks that adverts in a stopped category are not bid.
+func TestStoppedCategory(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"pub.com": `{"suppliers": ["dsp.com"]}`,
+		"dsp.com": `{"adverts": [
+			{"advertiserURL": "casino.com", "cpm": 5, "category": "gambling"},
+			{"advertiserURL": "cars.com", "cpm": 1}]}`})
+	for i := 0; i < 10; i++ {
+		b, err := bidOf(
+			transact(t, c, &swan.Offer{Stopped: []string{"gambling|category"}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b == nil || b.AdvertiserURL != "cars.com" {
+			t.Fatalf("expected the advert for cars.com, got %+v", b)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"fmt"
+	"owid"
+	"swan"
+)
+
+// Limits for transaction trees received from callers.
+const (
+	maxBodySize = 1 << 20 // Largest request body after decompression
+	maxDepth    = 32      // Most levels of nodes below the root
+	maxChildren = 64      // Most children of any node
+)
+
+// validateTree checks the tree received from a caller before it is used. The
+// root must be a SWAN Offer, every OWID must parse, the values must be the
+// index of a child or -1, and there must be a single leaf which is the
+// caller's Processor OWID.
+func validateTree(n *owid.Node) error {
+	_, err := swan.OfferFromNode(n)
+	if err != nil {
+		return fmt.Errorf("Root is not a valid SWAN Offer: %s", err.Error())
+	}
+	l, err := validateNode(n, 0)
+	if err != nil {
+		return err
+	}
+	if l != 1 {
+		return fmt.Errorf("Tree has %d leaves, expected 1", l)
+	}
+	return nil
+}
+
+// validateNode checks the node and its children returning the number of
+// leaves.
+func validateNode(n *owid.Node, depth int) (int, error) {
+	if depth > maxDepth {
+		return 0, fmt.Errorf("Tree deeper than %d", maxDepth)
+	}
+	if len(n.Children) > maxChildren {
+		return 0, fmt.Errorf(
+			"Node has %d children, limit is %d",
+			len(n.Children),
+			maxChildren)
+	}
+	_, err := n.GetOWID()
+	if err != nil {
+		return 0, fmt.Errorf("OWID at depth %d invalid: %s", depth, err.Error())
+	}
+	if n.Value != nil {
+		i, ok := valueIndex(n)
+		if ok == false || i < -1 || i >= len(n.Children) {
+			return 0, fmt.Errorf(
+				"Value '%v' at depth %d is not a child index",
+				n.Value,
+				depth)
+		}
+	}
+	if len(n.Children) == 0 {
+		return 1, nil
+	}
+	c := 0
+	for _, h := range n.Children {
+		if h == nil {
+			return 0, fmt.Errorf("Node at depth %d has a null child", depth)
+		}
+		l, err := validateNode(h, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		c += l
+	}
+	return c, nil
+}
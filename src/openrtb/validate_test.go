@@ -0,0 +1,188 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package openrtb
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"owid"
+	"strings"
+	"swan"
+	"testing"
+)
+
+// newOfferTree returns a root for an unsigned offer with a single child from
+// the caller.
+func newOfferTree(t testing.TB) (*owid.Node, *owid.Node) {
+	t.Helper()
+	o := swan.Offer{PubDomain: "pub.com", UUID: make([]byte, 16)}
+	b, err := o.AsByteArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newNode(t, "pub.com", nil)
+	r := newNode(t, "pub.com", b)
+	r.AddChild(c)
+	return r, c
+}
+
+// TestValidateTree checks that trees that are too deep or wide, that contain
+// invalid OWIDs or values, or that don't lead to the caller are refused with
+// the reason.
+func TestValidateTree(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		change func(r *owid.Node, c *owid.Node)
+		reason string
+	}{
+		{"valid", func(r, c *owid.Node) {}, ""},
+		{"winning value", func(r, c *owid.Node) { r.Value = float64(0) }, ""},
+		{"own bid", func(r, c *owid.Node) { r.Value = float64(-1) }, ""},
+		{"root", func(r, c *owid.Node) {
+			r.OWID = newNode(t, "pub.com", []byte("offer")).OWID
+		}, "Root is not a valid SWAN Offer"},
+		{"depth", func(r, c *owid.Node) {
+			for i := 0; i < maxDepth; i++ {
+				n := newNode(t, "ssp.com", nil)
+				c.AddChild(n)
+				c = n
+			}
+		}, "Tree deeper than"},
+		{"children", func(r, c *owid.Node) {
+			for i := 0; i <= maxChildren; i++ {
+				c.AddChild(newNode(t, "dsp.com", nil))
+			}
+		}, "children, limit is"},
+		{"OWID", func(r, c *owid.Node) {
+			c.OWID = []byte{0xff}
+		}, "OWID at depth 1 invalid"},
+		{"fraction", func(r, c *owid.Node) { r.Value = 0.5 }, "not a child index"},
+		{"range", func(r, c *owid.Node) { r.Value = float64(1) }, "not a child index"},
+		{"negative", func(r, c *owid.Node) { r.Value = float64(-2) }, "not a child index"},
+		{"string", func(r, c *owid.Node) { r.Value = "0" }, "not a child index"},
+		{"null child", func(r, c *owid.Node) {
+			c.Children = []*owid.Node{nil}
+		}, "null child"},
+		{"leaves", func(r, c *owid.Node) {
+			r.AddChild(newNode(t, "other.com", nil))
+		}, "Tree has 2 leaves"},
+	} {
+		r, c := newOfferTree(t)
+		v.change(r, c)
+		err := validateTree(r)
+		if v.reason == "" && err != nil {
+			t.Errorf("%s: %s", v.name, err)
+		}
+		if v.reason != "" &&
+			(err == nil || strings.Contains(err.Error(), v.reason) == false) {
+			t.Errorf("%s: expected '%s', got %v", v.name, v.reason, err)
+		}
+	}
+}
+
+// TestBidEndpointRefusesInvalid checks that the bid endpoint returns bad
+// request with the reason for bodies that are too large, can't be decoded or
+// contain an invalid tree.
+func TestBidEndpointRefusesInvalid(t *testing.T) {
+	c := newNetwork(t, map[string]string{
+		"dsp.com": `{"adverts": [{"advertiserURL": "a.com", "cpm": 1}]}`})
+	d := c.FindDomain("dsp.com")
+	r, _ := newOfferTree(t)
+	r.Value = "x"
+	j, err := r.AsJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := compress(bytes.Repeat([]byte(" "), maxBodySize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		name     string
+		body     []byte
+		encoding string
+		reason   string
+	}{
+		{"size", large, "gzip", "Body larger than"},
+		{"JSON", []byte("{"), "", "unexpected end of JSON input"},
+		{"CBOR", []byte{0x83}, "", "unexpected EOF"},
+		{"value", j, "", "not a child index"},
+	} {
+		q := httptest.NewRequest(
+			"POST",
+			"http://dsp.com"+openRTBPath,
+			bytes.NewReader(v.body))
+		if v.name == "CBOR" {
+			q.Header.Set("Content-Type", mediaTypeCBOR)
+		}
+		if v.encoding != "" {
+			q.Header.Set("Content-Encoding", v.encoding)
+		}
+		w := httptest.NewRecorder()
+		Handler(d, w, q)
+		if w.Code != http.StatusBadRequest ||
+			strings.Contains(w.Body.String(), v.reason) == false {
+			t.Errorf("%s: expected 400 '%s', got %d '%s'",
+				v.name,
+				v.reason,
+				w.Code,
+				w.Body.String())
+		}
+	}
+}
+
+// FuzzValidate checks that decoding and validating any body doesn't panic,
+// and that the functions that walk trees which pass validation don't panic.
+func FuzzValidate(f *testing.F) {
+	r, _ := newOfferTree(f)
+	for _, m := range []string{mediaTypeJSON, mediaTypeCBOR} {
+		b, err := encodeNode(r, m)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b, m == mediaTypeCBOR)
+		b, err = encodeNode(newDemoTree(f, demoPublisher), m)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b, m == mediaTypeCBOR)
+	}
+	f.Add([]byte(`{"owid":"","value":1e300,"children":[null]}`), false)
+	f.Add([]byte{0x83, 0x40, 0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0x80}, true)
+	f.Fuzz(func(t *testing.T, b []byte, cbor bool) {
+		m := mediaTypeJSON
+		if cbor {
+			m = mediaTypeCBOR
+		}
+		n, err := decodeNode(b, m)
+		if err != nil {
+			return
+		}
+		if validateTree(n) != nil {
+			return
+		}
+		bidOf(n)
+		isBid(n)
+		pathDomains(n)
+		_, err = encodeNode(n, mediaTypeCBOR)
+		if err != nil {
+			t.Fatalf("valid tree not encoded: %s", err)
+		}
+	})
+}
@@ -0,0 +1,283 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package publisher
+
+import (
+	"bytes"
+	"common"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// mediaTimeout is the time an advertiser has to return the VAST document or
+// native assets of an advert.
+const mediaTimeout = 5 * time.Second
+
+// mediaLimit is the most bytes of a VAST document or native assets read.
+const mediaLimit = 1 << 20
+
+// mediaClient fetches the media of video and native adverts while the page is
+// being rendered.
+var mediaClient = &http.Client{Timeout: mediaTimeout}
+
+// advertTemplates used to render the winning advert for each format. Each
+// advert is a FORM HTML element so that the transaction reference can be
+// POSTed as a hidden field when the advert is selected. The click is recorded
+// by the publisher and winning DSP before the browser reaches the advertiser.
+// The impression beacons are images and the viewable beacons are sent by the
+// events script.
+var advertTemplates = map[string]*template.Template{
+	common.FormatBanner: template.Must(template.New("banner").Parse(`
+<form method="POST" action="{{ .ClickURL }}" data-viewable="{{ .Viewable }}">
+<div class="form-group">
+<input type="hidden" id="reference" name="reference" value="{{ .Reference }}">
+{{ range .Impressions }}<img src="{{ . }}" width="1" height="1" alt="" style="position:absolute">{{ end }}
+<button type="submit" id="view" name="view" class="advert-button">
+<img src="//{{ .MediaURL }}" style="width:{{ .Size.Width }}px;height:{{ .Size.Height }}px;object-fit:cover">
+</button>
+<a href="{{ .InfoURL }}" class="advert-stop" title="Info about this advert">
+<img src="noun_Info_1582932.svg">
+</a>
+</div>
+</form>`)),
+	common.FormatVideo: template.Must(template.New("video").Parse(`
+<form method="POST" action="{{ .ClickURL }}" data-viewable="{{ .Viewable }}">
+<div class="form-group">
+<input type="hidden" id="reference" name="reference" value="{{ .Reference }}">
+{{ range .Impressions }}<img src="{{ . }}" width="1" height="1" alt="" style="position:absolute">{{ end }}
+<video class="advert-video" data-vast="//{{ .MediaURL }}" width="{{ .Size.Width }}" height="{{ .Size.Height }}" controls muted playsinline>
+<source src="{{ .VideoURL }}" type="{{ .VideoType }}">
+</video>
+<button type="submit" id="view" name="view" class="btn btn-secondary btn-sm">Visit advertiser</button>
+<a href="{{ .InfoURL }}" class="advert-stop" title="Info about this advert">
+<img src="noun_Info_1582932.svg">
+</a>
+</div>
+</form>`)),
+	common.FormatNative: template.Must(template.New("native").Parse(`
+<form method="POST" action="{{ .ClickURL }}" data-viewable="{{ .Viewable }}">
+<div class="form-group advert-native" style="width:{{ .Size.Width }}px">
+<input type="hidden" id="reference" name="reference" value="{{ .Reference }}">
+{{ range .Impressions }}<img src="{{ . }}" width="1" height="1" alt="" style="position:absolute">{{ end }}
+<img src="{{ .Native.Image }}" class="img-fluid">
+<h5>{{ .Native.Title }}</h5>
+<p>{{ .Native.Body }}</p>
+<button type="submit" id="view" name="view" class="btn btn-secondary btn-sm">{{ .Native.CTA }}</button>
+<small>Sponsored</small>
+<a href="{{ .InfoURL }}" class="advert-stop" title="Info about this advert">
+<img src="noun_Info_1582932.svg">
+</a>
+</div>
+</form>`)),
+}
+
+// advertModel is used with the advertTemplates.
+type advertModel struct {
+	Placement     string                 // ID of the placement
+	Format        string                 // Format of the advert in the bid
+	AdvertiserURL string                 // Host of the advertiser
+	MediaURL      string                 // The media URL from the bid
+	Reference     string                 // Signed transaction reference
+	InfoURL       string                 // URL of the CMP info page
+	Size          common.Size            // Size of the placement
+	VideoURL      string                 // Video file from the VAST document
+	VideoType     string                 // MIME type of the video file
+	Native        *common.NativeCreative // Assets for native adverts
+	OfferID       string                 // Base 64 Offer OWID
+	ClickURL      string                 // URL the advert form is POSTed to
+	Impressions   []string               // Impression beacon URLs
+	Viewable      string                 // Space separated viewable beacon URLs
+}
+
+// renderAdvert returns the HTML for the advert using the template for the
+// format. Video and native adverts fetch the VAST document or assets from the
+// media URL.
+func (m Model) renderAdvert(a *advertModel) (template.HTML, error) {
+	t := advertTemplates[a.Format]
+	if t == nil {
+		return "", fmt.Errorf("Advert format '%s' not supported", a.Format)
+	}
+	switch a.Format {
+	case common.FormatVideo:
+		b, err := m.getMedia(a.MediaURL)
+		if err != nil {
+			return "", err
+		}
+		v, err := common.ParseVAST(b)
+		if err != nil {
+			return "", err
+		}
+		a.VideoURL, a.VideoType = v.MediaFile()
+	case common.FormatNative:
+		b, err := m.getMedia(a.MediaURL)
+		if err != nil {
+			return "", err
+		}
+		err = json.Unmarshal(b, &a.Native)
+		if err != nil {
+			return "", err
+		}
+	}
+	var h bytes.Buffer
+	err := t.Execute(&h, a)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(h.String()), nil
+}
+
+// getMedia returns the document at the media URL. The media URL comes from the
+// bid so only media from the advertisers in the demo is fetched, and the size
+// of the document and the time taken are limited.
+func (m Model) getMedia(mediaURL string) ([]byte, error) {
+	u, err := url.Parse(m.Config().Scheme + "://" + mediaURL)
+	if err != nil {
+		return nil, err
+	}
+	d := m.Config().FindDomain(u.Host)
+	if u.User != nil || d == nil || d.Category != "Advertiser" {
+		return nil, fmt.Errorf("Media '%s' is not from an advertiser", mediaURL)
+	}
+	res, err := mediaClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"Media '%s' status '%d'",
+			mediaURL,
+			res.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(res.Body, mediaLimit))
+}
+
+// placeholderHTML returns the HTML for a placement that does not have an
+// advert with the message provided. The placeholder is the size of the
+// placement so the page layout does not change.
+func placeholderHTML(p *common.Placement, message string) template.HTML {
+	var s common.Size
+	if p != nil {
+		s = p.Size()
+	}
+	return template.HTML(fmt.Sprintf(
+		"<div class=\"advert-placeholder\" "+
+			"style=\"width:%dpx;height:%dpx\">"+
+			"<p>%s</p>"+
+			"</div>",
+		s.Width,
+		s.Height,
+		template.HTMLEscapeString(message)))
+}
+
+// serverSideAdsKey is the query string key and value used to render adverts
+// in the page rather than with the ad tag. Browsers without JavaScript are
+// redirected to the page with this query string.
+const (
+	serverSideAdsKey   = "ads"
+	serverSideAdsValue = "server"
+)
+
+// AdvertScript returns the script for the ad tag to include in the head of
+// the page. Browsers without JavaScript are redirected to the page with the
+// adverts rendered in the page.
+func (m Model) AdvertScript() template.HTML {
+	if m.isServerSideAds() {
+		return ""
+	}
+	u := *m.Request.URL
+	q := u.Query()
+	q.Set(serverSideAdsKey, serverSideAdsValue)
+	u.RawQuery = q.Encode()
+	return template.HTML(fmt.Sprintf(
+		"<script src=\"/adtag.js\" async></script>"+
+			"<noscript><meta http-equiv=\"refresh\" content=\"0;url=%s\">"+
+			"</noscript>",
+		template.HTMLEscapeString(u.RequestURI())))
+}
+
+// AdvertTag returns an element that the ad tag fills with the advert for the
+// placement after the page has loaded. If the adverts are rendered in the
+// page then the advert HTML is returned instead.
+func (m Model) AdvertTag(placement string) (template.HTML, error) {
+	if m.isServerSideAds() {
+		return m.NewAdvertHTML(placement)
+	}
+	p := m.Domain.Placement(placement)
+	if p == nil {
+		return "", fmt.Errorf(
+			"Placement '%s' not in '%s' config.json",
+			placement,
+			m.Domain.Host)
+	}
+	return template.HTML(fmt.Sprintf(
+		"<div class=\"advert-slot\" data-placement=\"%s\" "+
+			"style=\"width:%dpx;height:%dpx\"></div>",
+		template.HTMLEscapeString(placement),
+		p.Size().Width,
+		p.Size().Height)), nil
+}
+
+// isServerSideAds returns true if the adverts are rendered in the page.
+func (m Model) isServerSideAds() bool {
+	return m.Request.URL.Query().Get(serverSideAdsKey) == serverSideAdsValue
+}
+
+// EventScript returns the script that sends the viewable beacons for the
+// adverts on the page.
+func (m Model) EventScript() template.HTML {
+	return template.HTML("<script src=\"/events.js\" async></script>")
+}
+
+// setEvents sets the click URL and the beacon URLs for the events that the
+// publisher and the winning DSP record for the offer.
+func (m Model) setEvents(a *advertModel, dsp string) {
+	var c url.URL
+	c.Scheme = m.Config().Scheme
+	c.Host = m.Domain.Host
+	c.Path = "/event/" + common.EventClick
+	a.ClickURL = c.String()
+	var v []string
+	for _, h := range []string{m.Domain.Host, dsp} {
+		a.Impressions = append(
+			a.Impressions,
+			eventURL(m.Config().Scheme, h, common.EventImpression, a.OfferID))
+		v = append(
+			v,
+			eventURL(m.Config().Scheme, h, common.EventViewable, a.OfferID))
+	}
+	a.Viewable = strings.Join(v, " ")
+}
+
+// eventURL returns the URL of the beacon for the event at the host.
+func eventURL(scheme string, host string, event string, offerID string) string {
+	var u url.URL
+	u.Scheme = scheme
+	u.Host = host
+	u.Path = "/event/" + event
+	q := u.Query()
+	q.Set("offer", offerID)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
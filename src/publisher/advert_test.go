@@ -0,0 +1,47 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package publisher
+
+import (
+	"common"
+	"demotest"
+	"strings"
+	"testing"
+)
+
+// TestGetMediaAdvertisersOnly checks that media is only fetched from the
+// advertisers in the demo.
+func TestGetMediaAdvertisersOnly(t *testing.T) {
+	c := demotest.NewConfig()
+	p := demotest.NewDomain(t, c, "pub.com", `{"category": "Publisher"}`)
+	demotest.NewDomain(t, c, "dsp.com", `{"category": "DSP"}`)
+	if c.FindDomain("dsp.com") == nil {
+		t.Fatal("DSP not part of the demo")
+	}
+	m := Model{PageModel: common.PageModel{Domain: p}}
+	for _, u := range []string{
+		"dsp.com/vast.xml",
+		"169.254.169.254/latest/meta-data",
+		"adv.com@169.254.169.254/vast.xml",
+		"localhost:8080/vast.xml"} {
+		_, err := m.getMedia(u)
+		if err == nil ||
+			strings.Contains(err.Error(), "not from an advertiser") == false {
+			t.Fatalf("media '%s' fetched: %v", u, err)
+		}
+	}
+}
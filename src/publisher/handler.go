@@ -21,6 +21,7 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"fod"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
@@ -37,6 +38,21 @@ func Handler(d *common.Domain, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// If this is the stopped path then redirect to the CMP's stop list page.
+	if strings.EqualFold(r.URL.Path, "/stopped") {
+		u := getCleanURL(d.Config, r)
+		u.Path = "/"
+		redirectToCMP(d, w, r, "/stopped/", u)
+		return
+	}
+
+	// If this is the ad path then return the advert for the placement as
+	// JSON for the ad tag.
+	if r.URL.Path == "/ad" {
+		handlerAd(d, w, r)
+		return
+	}
+
 	// Try the URL path for the preference values.
 	p, ae := newSWANDataFromPath(d, r)
 	if ae != nil {
@@ -94,12 +110,23 @@ func handlerPublisherPage(
 	m.Domain = d
 	m.Request = r
 	m.results = p
+
+	// Execute the template to find the placements it needs and then run the
+	// auctions for them in parallel.
+	m.prefetch = &prefetch{}
+	err := t.Execute(ioutil.Discard, &m)
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+	m.prefetch.run(m, prefetchDeadline)
+
 	g := gzip.NewWriter(w)
 	defer g.Close()
 	w.Header().Set("Content-Encoding", "gzip")
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Header().Set("Cache-Control", "no-cache")
-	err := t.Execute(g, &m)
+	err = t.Execute(g, &m)
 	if err != nil {
 		common.ReturnServerError(d.Config, w, err)
 	}
@@ -170,15 +197,27 @@ func redirectToCMPDialog(
 	d *common.Domain,
 	w http.ResponseWriter,
 	r *http.Request) {
+	redirectToCMP(d, w, r, "/preferences/", getCleanURL(d.Config, r))
+}
+
+// redirectToCMP redirects to the page at the path on the publisher's CMP via
+// SWAN so that the page receives the user's SWAN data. The return URL is used
+// when the user has finished with the CMP page.
+func redirectToCMP(
+	d *common.Domain,
+	w http.ResponseWriter,
+	r *http.Request,
+	path string,
+	returnURL *url.URL) {
 	u, err := d.CreateSWANURL(
 		r,
-		getCleanURL(d.Config, r).String(),
+		returnURL.String(),
 		"dialog",
 		func(q *url.Values) {
 			var u url.URL
 			u.Scheme = d.Config.Scheme
 			u.Host = d.CMP
-			u.Path = "/preferences/"
+			u.Path = path
 			q.Set("dialogUrl", u.String())
 		})
 	if err != nil {
@@ -0,0 +1,110 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package publisher
+
+import (
+	"common"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"fod"
+	"html/template"
+	"net/http"
+)
+
+// adResponse is returned from the ad endpoint for the ad tag to fill a
+// placement after the page has loaded.
+type adResponse struct {
+	Placement     string `json:"placement"`               // ID of the placement
+	Bid           bool   `json:"bid"`                     // False if no advert
+	Format        string `json:"format,omitempty"`        // Format of the advert
+	HTML          string `json:"html,omitempty"`          // Rendered creative
+	AdvertiserURL string `json:"advertiserURL,omitempty"` // Advertiser host
+	MediaURL      string `json:"mediaURL,omitempty"`      // Media from the bid
+	Reference     string `json:"reference,omitempty"`     // Transaction reference
+	InfoURL       string `json:"infoURL,omitempty"`       // CMP info page URL
+	Width         int    `json:"width"`                   // Width of placement
+	Height        int    `json:"height"`                  // Height of placement
+}
+
+// handlerAd runs the auction for the placement in the query string and
+// returns the winning advert as JSON. The user's SWAN data is taken from the
+// cookies. If the data is not available, the request is from a crawler, or
+// there are no bids then the response indicates there is no bid.
+func handlerAd(d *common.Domain, w http.ResponseWriter, r *http.Request) {
+	var j adResponse
+	j.Placement = r.URL.Query().Get("placement")
+	p := d.Placement(j.Placement)
+	if p == nil {
+		common.ReturnStatusCodeError(
+			d.Config,
+			w,
+			fmt.Errorf("Placement '%s' not found", j.Placement),
+			http.StatusBadRequest)
+		return
+	}
+	j.Width = p.Size().Width
+	j.Height = p.Size().Height
+
+	c, err := fod.GetCrawlerFrom51Degrees(r)
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+	s := newSWANDataFromCookies(r)
+	if c == false && isSet(s) {
+		var m Model
+		m.Domain = d
+		m.Request = r
+		m.results = s
+		a, err := m.newAdvert(j.Placement)
+		if err != nil {
+			common.ReturnServerError(d.Config, w, err)
+			return
+		}
+		if a != nil {
+			var h template.HTML
+			h, err = m.renderAdvert(a)
+			if err != nil {
+				common.ReturnServerError(d.Config, w, err)
+				return
+			}
+			j.Bid = true
+			j.Format = a.Format
+			j.HTML = string(h)
+			j.AdvertiserURL = a.AdvertiserURL
+			j.MediaURL = a.MediaURL
+			j.Reference = a.Reference
+			j.InfoURL = a.InfoURL
+		}
+	}
+
+	b, err := json.Marshal(&j)
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+		return
+	}
+	g := gzip.NewWriter(w)
+	defer g.Close()
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, err = g.Write(b)
+	if err != nil {
+		common.ReturnServerError(d.Config, w, err)
+	}
+}
@@ -0,0 +1,162 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package publisher
+
+import (
+	"common"
+	"compress/gzip"
+	"demotest"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"openrtb"
+	"strings"
+	"swan"
+	"testing"
+)
+
+// pubConfig is the configuration of the publisher used in the tests.
+const pubConfig = `{
+	"SWANAccessNode": "swan.com",
+	"SWANAccessKey": "key",
+	"suppliers": ["dsp.com"],
+	"placements": [{"id": "top", "sizes": [{"width": 728, "height": 90}]}]}`
+
+// newPublisher returns the publisher domain for the tests along with a SWAN
+// access node that creates Offer IDs and a DSP that bids for every offer.
+func newPublisher(t *testing.T) *common.Domain {
+	t.Helper()
+	c := demotest.NewConfig()
+	p := demotest.NewDomain(t, c, "pub.com", pubConfig)
+	p.SetHandler(Handler)
+	demotest.NewDomain(t, c, "dsp.com", `{"adverts": [{
+		"advertiserURL": "adv.com",
+		"mediaURL": "adv.com/a.png",
+		"cpm": 1}]}`).SetHandler(openrtb.Handler)
+	demotest.NewDomain(t, c, "swan.com", "").SetHandler(handlerCreateOfferID)
+	demotest.Serve(t, c)
+	return p
+}
+
+// handlerCreateOfferID returns an Offer OWID for the query parameters in the
+// same way as the SWAN access node.
+func handlerCreateOfferID(d *common.Domain, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	o := swan.Offer{
+		Placement:   q.Get("placement"),
+		PubDomain:   q.Get("pubdomain"),
+		CBID:        []byte("cbid"),
+		Preferences: []byte("on"),
+		UUID:        []byte("uuid")}
+	b, err := o.AsByteArray()
+	if err == nil {
+		t := d.OWID.CreateOWID(b)
+		err = d.OWID.Sign(t)
+		if err == nil {
+			b, err = t.AsByteArray()
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
+// newSWANCookie returns a cookie for the SWAN value with an OWID created by the
+// SWAN access node.
+func newSWANCookie(t *testing.T, name string, value string) *http.Cookie {
+	t.Helper()
+	c := demotest.Creator{Domain: "swan.com"}
+	o := c.CreateOWID([]byte(value))
+	err := c.Sign(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := o.AsBase64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: name, Value: s}
+}
+
+// getAd requests the advert for the placement from the publisher with the
+// cookies and returns the status code and the response.
+func getAd(
+	t *testing.T,
+	d *common.Domain,
+	placement string,
+	cookies ...*http.Cookie) (int, *adResponse) {
+	t.Helper()
+	r := httptest.NewRequest("GET", "http://pub.com/ad?placement="+placement, nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	Handler(d, w, r)
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+	g, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j adResponse
+	err = json.NewDecoder(g).Decode(&j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, &j
+}
+
+func TestHandlerAdUnknownPlacement(t *testing.T) {
+	if s, _ := getAd(t, newPublisher(t), "missing"); s != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %d", s)
+	}
+}
+
+func TestHandlerAdNoSWANData(t *testing.T) {
+	_, j := getAd(t, newPublisher(t), "top")
+	if j.Bid || j.HTML != "" || j.Width != 728 || j.Height != 90 {
+		t.Fatalf("expected no bid at the placement size, got %+v", j)
+	}
+}
+
+func TestHandlerAdBid(t *testing.T) {
+	p := newPublisher(t)
+	_, j := getAd(t, p, "top",
+		newSWANCookie(t, "cbid", "cbid"),
+		newSWANCookie(t, "sid", "sid"),
+		newSWANCookie(t, "allow", "on"),
+		newSWANCookie(t, "stop", ""))
+	if j.Bid == false ||
+		j.AdvertiserURL != "adv.com" ||
+		j.MediaURL != "adv.com/a.png" ||
+		j.Format != common.FormatBanner {
+		t.Fatalf("expected the DSP's advert, got %+v", j)
+	}
+	f, err := common.ParseTransactionRef(p.Config, j.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.OWID.Domain != "pub.com" {
+		t.Fatalf("reference from '%s'", f.OWID.Domain)
+	}
+	if strings.Contains(j.HTML, "//adv.com/a.png") == false {
+		t.Fatalf("creative missing from '%s'", j.HTML)
+	}
+}
@@ -17,16 +17,13 @@
 package publisher
 
 import (
-	"bytes"
 	"common"
-	"encoding/base64"
 	"fmt"
 	"html/template"
 	"math/rand"
 	"net/url"
 	"openrtb"
 	"owid"
-	"strings"
 	"swan"
 	"time"
 )
@@ -34,11 +31,19 @@ import (
 // Model used with HTML templates.
 type Model struct {
 	common.PageModel
-	results []*swan.Pair // The SWAN data for display
+	results  []*swan.Pair // The SWAN data for display
+	prefetch *prefetch    // The adverts for the page if prefetched
 }
 
 // Allow returns a boolean to indicate if personalized marketing is enabled.
-func (m Model) Allow() bool { return m.AllowAsString() == "on" }
+func (m Model) Allow() bool {
+	return m.Consent().Has(common.PurposePersonalizedAds)
+}
+
+// Consent returns the purposes the user has allowed.
+func (m Model) Consent() common.Consent {
+	return common.ParseConsent(m.AllowAsString())
+}
 
 // CBIDAsString Common Browser IDentifier
 func (m Model) CBIDAsString() string { return common.AsString(m.cbid()) }
@@ -68,8 +73,8 @@ func (m Model) SIDDate() string { return common.OWIDDate(m.sid()) }
 func (m Model) AllowDate() string { return common.OWIDDate(m.allow()) }
 
 // Stopped returns a list of the domains that have been stopped for advertising.
-func (m Model) Stopped() []string {
-	return strings.Split(common.AsString(m.stopped()), "\r\n")
+func (m Model) Stopped() common.StopList {
+	return common.ParseStopList(common.AsString(m.stopped()))
 }
 
 // DomainsByCategory returns all the domains that match the category.
@@ -84,39 +89,99 @@ func (m Model) DomainsByCategory(category string) []*common.Domain {
 }
 
 // NewAdvertHTML provides the HTML for the advert that will be displayed on the
-// web page at the placement provided.
+// web page at the placement provided. The placement must be in the
+// publisher's config.json. If the adverts for the page are prefetched then the
+// prefetched advert is returned.
 func (m Model) NewAdvertHTML(placement string) (template.HTML, error) {
+	if m.prefetch != nil {
+		return m.prefetch.advert(placement), nil
+	}
+	return m.newAdvertHTML(placement)
+}
+
+// newAdvertHTML runs the auction for the placement and returns the HTML for
+// the winning advert.
+func (m Model) newAdvertHTML(placement string) (template.HTML, error) {
+	a, err := m.newAdvert(placement)
+	if err != nil {
+		return template.HTML("<p>" + err.Error() + "</p>"), nil
+	}
+	if a == nil {
+		return placeholderHTML(
+			m.Domain.Placement(placement),
+			"No advert available"), nil
+	}
+	h, err := m.renderAdvert(a)
+	if err != nil {
+		return template.HTML("<p>" + err.Error() + "</p>"), nil
+	}
+	return h, nil
+}
+
+// newAdvert runs the auction for the placement and returns the winning advert
+// ready to render, or nil if there were no bids.
+func (m Model) newAdvert(placement string) (*advertModel, error) {
 
 	rand.Seed(time.Now().UTC().UnixNano())
 
+	p := m.Domain.Placement(placement)
+	if p == nil {
+		return nil, fmt.Errorf(
+			"Placement '%s' not in '%s' config.json",
+			placement,
+			m.Domain.Host)
+	}
+
 	// Use the SWAN network to generate the Offer ID.
 	r, ae := m.newOfferID(placement)
 	if ae != nil {
-		return "", ae.Err
+		return nil, ae.Err
 	}
 
-	// Add the publishers signature and then process the supply chain.
-	_, err := openrtb.HandleTransaction(m.Domain, r)
+	// Add the publishers signature and then process the supply chain with
+	// the placement details.
+	q := m.openRTBRequest()
+	q.AddPlacement(p)
+	_, err := openrtb.HandleTransaction(m.Domain, r, q)
 	if err != nil {
-		return template.HTML("<p>" + err.Error() + "</p>"), nil
+		return nil, err
 	}
 
-	// Get the OWID tree as a base 64 string.
+	// Get the OWID tree as JSON and keep it so the transaction can be
+	// audited after the page has gone.
 	e, err := r.AsJSON()
 	if err != nil {
-		return template.HTML("<p>" + err.Error() + "</p>"), nil
+		return nil, err
+	}
+	o, err := r.GetOWID()
+	if err != nil {
+		return nil, err
+	}
+	err = m.Domain.Transactions().Add(o.AsString(), e)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get the winning bid node.
 	w, err := swan.WinningNode(r)
 	if err != nil {
-		return template.HTML("<p>" + err.Error() + "</p>"), nil
+		return nil, err
 	}
 
-	// Get the winning bid.
-	b, err := swan.WinningBid(r)
+	// Get the winning bid. If there isn't one then there is no advert.
+	b, err := common.WinningBid(r)
 	if err != nil {
-		return template.HTML("<p>" + err.Error() + "</p>"), nil
+		return nil, err
+	}
+	if w == nil || b == nil {
+		return nil, nil
+	}
+
+	// Create the signed reference to the transaction which the advertiser and
+	// CMP use to fetch the tree from this publisher.
+	f, err := common.NewTransactionRef(m.Domain, w)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get the URL for the info icon.
@@ -124,34 +189,40 @@ func (m Model) NewAdvertHTML(placement string) (template.HTML, error) {
 	i.Scheme = m.Config().Scheme
 	i.Host = m.Domain.CMP
 	i.Path = "/info"
-	q := i.Query()
-	n := w
-	for n != nil {
-		q.Add("owid", n.GetOWIDAsString())
-		n = n.GetParent()
-	}
-	i.RawQuery = q.Encode()
-
-	// Return a FORM HTML element with a button for the advert. The OWID tree
-	// is a base 64 string added as a hidden field to the form.
-	var html bytes.Buffer
-	html.WriteString(fmt.Sprintf("<form method=\"POST\" action=\"//%s\">"+
-		"<div class=\"form-group\">"+
-		"<input type=\"hidden\" id=\"transaction\" name=\"transaction\" value=\"%s\">"+
-		"<button type=\"submit\" id=\"view\" name=\"view\" class=\"advert-button\">"+
-		"<img src=\"//%s\">"+
-		"</button>"+
-		"<a href=\"%s\" class=\"advert-stop\" title=\"Info about this advert\">"+
-		"<img src=\"%s\">"+
-		"</a>"+
-		"</div>"+
-		"</form>",
-		b.AdvertiserURL,
-		base64.StdEncoding.EncodeToString(e),
-		b.MediaURL,
-		i.String(),
-		"noun_Info_1582932.svg"))
-	return template.HTML(html.String()), nil
+	v := i.Query()
+	v.Set("reference", f.String())
+
+	// Add the CBID so the CMP can verify complaints are from the user the
+	// advert was shown to.
+	if m.cbid() != nil {
+		c, err := m.cbid().AsBase64()
+		if err == nil {
+			v.Set("cbid", c)
+		}
+	}
+	i.RawQuery = v.Encode()
+
+	// The transaction reference is added as a hidden field to the form. The
+	// advert is rendered at the size of the placement with the format in the
+	// bid.
+	var a advertModel
+	a.Placement = placement
+	a.Format = b.FormatOrDefault()
+	a.MediaURL = b.MediaURL
+	a.AdvertiserURL = b.AdvertiserURL
+	a.Reference = f.String()
+	a.InfoURL = i.String()
+	a.Size = p.Size()
+
+	// Add the beacons and click URL for the events recorded by the publisher
+	// and winning DSP.
+	a.OfferID = o.AsString()
+	wo, err := w.GetOWID()
+	if err != nil {
+		return nil, err
+	}
+	m.setEvents(&a, wo.Domain)
+	return &a, nil
 }
 
 // CBID Common Browser IDentifier
@@ -0,0 +1,110 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package publisher
+
+import (
+	"html/template"
+	"sync"
+	"time"
+)
+
+// prefetchDeadline is the time allowed for the auctions of all the placements
+// on a page to complete before the page is rendered.
+const prefetchDeadline = 3 * time.Second
+
+// prefetch holds the adverts for the placements on a page. The template is
+// first executed to collect the placements it needs. The auctions for the
+// placements are then run in parallel before the template is executed again
+// to render the page with the adverts.
+type prefetch struct {
+	placements []string                 // Placements needed by the template
+	adverts    map[string]template.HTML // Nil until the auctions are run
+	mutex      sync.Mutex
+}
+
+// advert records the placement if the placements are being collected and
+// returns an empty string. Otherwise returns the advert for the placement.
+func (p *prefetch) advert(placement string) template.HTML {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.adverts == nil {
+		for _, i := range p.placements {
+			if i == placement {
+				return ""
+			}
+		}
+		p.placements = append(p.placements, placement)
+		return ""
+	}
+	return p.adverts[placement]
+}
+
+// run gets the adverts for all the placements in parallel. Placements that do
+// not complete before the deadline are given a placeholder.
+func (p *prefetch) run(m Model, deadline time.Duration) {
+	p.runWith(
+		func(i string) template.HTML {
+			h, err := m.newAdvertHTML(i)
+			if err != nil {
+				return placeholderHTML(m.Domain.Placement(i), err.Error())
+			}
+			return h
+		},
+		func(i string) template.HTML {
+			return placeholderHTML(
+				m.Domain.Placement(i),
+				"Advert not available in time")
+		},
+		deadline)
+}
+
+// runWith calls get for each placement in parallel and waits for the results
+// until the deadline. Placements that have not completed are given the HTML
+// from late.
+func (p *prefetch) runWith(
+	get func(placement string) template.HTML,
+	late func(placement string) template.HTML,
+	deadline time.Duration) {
+	type result struct {
+		placement string
+		html      template.HTML
+	}
+	c := make(chan result, len(p.placements))
+	for _, i := range p.placements {
+		go func(i string) {
+			c <- result{i, get(i)}
+		}(i)
+	}
+	a := make(map[string]template.HTML, len(p.placements))
+	t := time.NewTimer(deadline)
+	defer t.Stop()
+	for len(a) < len(p.placements) {
+		select {
+		case r := <-c:
+			a[r.placement] = r.html
+		case <-t.C:
+			for _, i := range p.placements {
+				if _, ok := a[i]; ok == false {
+					a[i] = late(i)
+				}
+			}
+		}
+	}
+	p.mutex.Lock()
+	p.adverts = a
+	p.mutex.Unlock()
+}
@@ -0,0 +1,103 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package publisher
+
+import (
+	"html/template"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPrefetch returns a prefetch with the placements collected by executing
+// the template.
+func newPrefetch(t *testing.T, page string) *prefetch {
+	t.Helper()
+	m := Model{prefetch: &prefetch{}}
+	p := template.Must(template.New("page").Parse(page))
+	err := p.Execute(ioutil.Discard, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.prefetch
+}
+
+func TestPrefetchCollectsPlacements(t *testing.T) {
+	p := newPrefetch(t,
+		`{{ .NewAdvertHTML "top" }}{{ .NewAdvertHTML "side" }}`+
+			`{{ .NewAdvertHTML "top" }}`)
+	if strings.Join(p.placements, ",") != "top,side" {
+		t.Fatalf("expected top and side once each, got %v", p.placements)
+	}
+}
+
+func TestPrefetchRunsInParallel(t *testing.T) {
+	p := newPrefetch(t,
+		`{{ .NewAdvertHTML "a" }}{{ .NewAdvertHTML "b" }}`+
+			`{{ .NewAdvertHTML "c" }}{{ .NewAdvertHTML "d" }}`)
+	s := time.Now()
+	p.runWith(
+		func(i string) template.HTML {
+			time.Sleep(100 * time.Millisecond)
+			return template.HTML(i)
+		},
+		func(i string) template.HTML { return "late" },
+		time.Second)
+	if d := time.Since(s); d >= 300*time.Millisecond {
+		t.Fatalf("auctions took %v so were not run in parallel", d)
+	}
+	m := Model{prefetch: p}
+	var b strings.Builder
+	err := template.Must(template.New("page").Parse(
+		`{{ .NewAdvertHTML "a" }}{{ .NewAdvertHTML "d" }}`)).Execute(&b, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "ad" {
+		t.Fatalf("expected the prefetched adverts, got '%s'", b.String())
+	}
+}
+
+func TestPrefetchDeadline(t *testing.T) {
+	p := newPrefetch(t, `{{ .NewAdvertHTML "fast" }}{{ .NewAdvertHTML "slow" }}`)
+	s := time.Now()
+	p.runWith(
+		func(i string) template.HTML {
+			if i == "slow" {
+				time.Sleep(time.Second)
+			}
+			return template.HTML(i)
+		},
+		func(i string) template.HTML { return "late" },
+		100*time.Millisecond)
+	if d := time.Since(s); d >= time.Second {
+		t.Fatalf("waited %v for the slow placement", d)
+	}
+	if p.advert("fast") != "fast" || p.advert("slow") != "late" {
+		t.Fatalf("unexpected adverts %v", p.adverts)
+	}
+}
+
+// TestPlaceholderEscaped checks that messages such as errors are escaped in
+// the placeholder.
+func TestPlaceholderEscaped(t *testing.T) {
+	h := placeholderHTML(nil, "<script>alert(1)</script>")
+	if strings.Contains(string(h), "<script>") {
+		t.Fatalf("message not escaped in '%s'", h)
+	}
+}
@@ -0,0 +1,171 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package publisher
+
+import (
+	"bytes"
+	"common"
+	"html/template"
+	"openrtb"
+	"tcf"
+	"time"
+)
+
+// tcfStubTemplate provides the __tcfapi and __gpp functions for the page so
+// that TCF and GPP aware scripts can read the user's SWAN choices.
+var tcfStubTemplate = template.Must(template.New("tcfapi").Parse(`
+<script>
+(function() {
+    var tcString = {{ .TCString }};
+    var gppString = {{ .GPPString }};
+    var cmpId = {{ .CmpID }};
+    var listeners = [];
+    function tcData(id) {
+        return {
+            tcString: tcString,
+            tcfPolicyVersion: 4,
+            cmpId: cmpId,
+            cmpStatus: "loaded",
+            eventStatus: "tcloaded",
+            gdprApplies: true,
+            listenerId: id
+        };
+    }
+    window.__tcfapi = function(command, version, callback, parameter) {
+        switch (command) {
+        case "ping":
+            callback({
+                gdprApplies: true,
+                cmpLoaded: true,
+                cmpStatus: "loaded",
+                displayStatus: "hidden",
+                apiVersion: "2.2",
+                cmpId: cmpId,
+                tcfPolicyVersion: 4
+            }, true);
+            break;
+        case "getTCData":
+            callback(tcData(), true);
+            break;
+        case "addEventListener":
+            listeners.push(callback);
+            callback(tcData(listeners.length - 1), true);
+            break;
+        case "removeEventListener":
+            if (parameter >= 0 && parameter < listeners.length) {
+                listeners[parameter] = null;
+            }
+            callback(true);
+            break;
+        default:
+            callback(null, false);
+        }
+    };
+    window.__gpp = function(command, callback) {
+        var data = {
+            gppVersion: "1.1",
+            cmpStatus: "loaded",
+            cmpDisplayStatus: "hidden",
+            signalStatus: "ready",
+            supportedAPIs: ["7:usnat"],
+            cmpId: cmpId,
+            sectionList: [7],
+            applicableSections: [7],
+            gppString: gppString
+        };
+        if (command === "ping" || command === "addEventListener") {
+            callback(data, true);
+        } else if (command === "getGPPData") {
+            callback(data, true);
+        } else {
+            callback(null, false);
+        }
+    };
+})();
+</script>`))
+
+// tcfModel is used with the tcfStubTemplate.
+type tcfModel struct {
+	TCString  string
+	GPPString string
+	CmpID     int
+}
+
+// TCString returns the IAB TCF v2.2 TC string that matches the user's SWAN
+// preferences. The CMP ID, version and vendor list version are taken from the
+// publisher's CMP, and the country code from the publisher.
+func (m Model) TCString() string {
+	var id, v, l int
+	c := m.Domain.Config.FindDomain(m.Domain.CMP)
+	if c != nil {
+		id = c.TCFCmpID
+		v = c.TCFCmpVersion
+		l = c.TCFVendorListVersion
+	}
+	return tcf.NewTCString(
+		m.Consent(),
+		m.allowCreated(),
+		id,
+		v,
+		l,
+		m.Domain.TCFPublisherCC,
+		m.Domain.Config.TCFVendorIDs()).String()
+}
+
+// GPPString returns a GPP string with a US national section that matches the
+// user's SWAN preferences.
+func (m Model) GPPString() string {
+	return tcf.NewUSNational(m.Consent()).GPPString()
+}
+
+// TCFStub returns a script element that provides the __tcfapi and __gpp
+// functions for the page.
+func (m Model) TCFStub() (template.HTML, error) {
+	var t tcfModel
+	var b bytes.Buffer
+	t.TCString = m.TCString()
+	t.GPPString = m.GPPString()
+	c := m.Domain.Config.FindDomain(m.Domain.CMP)
+	if c != nil {
+		t.CmpID = c.TCFCmpID
+	}
+	err := tcfStubTemplate.Execute(&b, &t)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(b.String()), nil
+}
+
+// openRTBRequest returns the consent and device fields to pass to suppliers.
+func (m Model) openRTBRequest() *openrtb.Request {
+	return openrtb.NewRequest(
+		m.TCString(),
+		m.GPPString(),
+		common.DeviceType(m.Request))
+}
+
+// allowCreated returns the time the preferences were created, or the current
+// time if this is not known.
+func (m Model) allowCreated() time.Time {
+	if m.allow() != nil {
+		o, err := m.allow().AsOWID()
+		if err == nil && o != nil {
+			return o.Date
+		}
+	}
+	return time.Now().UTC()
+}
@@ -0,0 +1,164 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package tcf
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// bitWriter packs values most significant bit first as required by the IAB
+// consent string formats.
+type bitWriter struct {
+	b []byte // Bytes written so far
+	n int    // Number of bits written
+}
+
+func (w *bitWriter) writeBool(v bool) {
+	if v {
+		w.writeInt(1, 1)
+	} else {
+		w.writeInt(0, 1)
+	}
+}
+
+func (w *bitWriter) writeInt(v uint64, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		if (v>>uint(i))&1 == 1 {
+			w.b[len(w.b)-1] |= 1 << uint(7-w.n%8)
+		}
+		w.n++
+	}
+}
+
+// writeLetters writes a two letter code where A is 0 and each letter uses
+// six bits.
+func (w *bitWriter) writeLetters(s string) {
+	for i := 0; i < 2; i++ {
+		var v uint64
+		if i < len(s) && s[i] >= 'A' && s[i] <= 'Z' {
+			v = uint64(s[i] - 'A')
+		}
+		w.writeInt(v, 6)
+	}
+}
+
+// writeFibonacci writes the positive integer using Fibonacci coding which
+// ends with two consecutive set bits.
+func (w *bitWriter) writeFibonacci(v uint64) {
+	f := []uint64{1, 2}
+	for f[len(f)-1] <= v {
+		f = append(f, f[len(f)-1]+f[len(f)-2])
+	}
+	b := make([]bool, len(f)-1)
+	for i := len(f) - 2; i >= 0; i-- {
+		if f[i] <= v {
+			b[i] = true
+			v -= f[i]
+		}
+	}
+	for _, i := range b {
+		w.writeBool(i)
+	}
+	w.writeBool(true)
+}
+
+// String returns the bits as URL safe base 64 without padding.
+func (w *bitWriter) String() string {
+	return base64.RawURLEncoding.EncodeToString(w.b)
+}
+
+// bitReader reads values written by bitWriter.
+type bitReader struct {
+	b []byte // Bytes to read from
+	n int    // Number of bits read
+}
+
+func newBitReader(s string) (*bitReader, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return &bitReader{b: b}, nil
+}
+
+func (r *bitReader) readBool() (bool, error) {
+	v, err := r.readInt(1)
+	return v == 1, err
+}
+
+func (r *bitReader) readInt(bits int) (uint64, error) {
+	var v uint64
+	for i := 0; i < bits; i++ {
+		if r.n/8 >= len(r.b) {
+			return 0, fmt.Errorf("String ended after %d bits", r.n)
+		}
+		v <<= 1
+		if r.b[r.n/8]&(1<<uint(7-r.n%8)) != 0 {
+			v |= 1
+		}
+		r.n++
+	}
+	return v, nil
+}
+
+func (r *bitReader) readLetters() (string, error) {
+	var s []byte
+	for i := 0; i < 2; i++ {
+		v, err := r.readInt(6)
+		if err != nil {
+			return "", err
+		}
+		s = append(s, byte('A'+v))
+	}
+	return string(s), nil
+}
+
+// maxFibonacciBits is the most bits of a Fibonacci code before the
+// terminating bit. Larger values are not used by GPP and would overflow when
+// added to other values.
+const maxFibonacciBits = 32
+
+func (r *bitReader) readFibonacci() (uint64, error) {
+	var v uint64
+	f := []uint64{1, 2}
+	p := false
+	for i := 0; ; i++ {
+		b, err := r.readBool()
+		if err != nil {
+			return 0, err
+		}
+		if b && p {
+			return v, nil
+		}
+		if i >= maxFibonacciBits {
+			return 0, fmt.Errorf(
+				"Fibonacci code longer than %d bits",
+				maxFibonacciBits)
+		}
+		for len(f) <= i {
+			f = append(f, f[len(f)-1]+f[len(f)-2])
+		}
+		if b {
+			v += f[i]
+		}
+		p = b
+	}
+}
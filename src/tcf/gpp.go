@@ -0,0 +1,185 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package tcf
+
+import (
+	"common"
+	"fmt"
+	"strings"
+)
+
+// Constants for the GPP strings produced by the demo.
+const (
+	gppHeaderType    = 3
+	gppVersion       = 1
+	usNatVersion     = 1
+	usNatSectionID   = 7 // Section ID of the US national section
+	usNatNotApply    = 0
+	usNatYes         = 1 // Notice provided or user opted out
+	usNatNo          = 2 // Notice not provided or user did not opt out
+	usNatSensitive   = 12
+	usNatChildFields = 2
+)
+
+// USNational contains the opt out fields of the GPP US national section.
+type USNational struct {
+	SaleOptOut                bool // True if the user opted out of sale
+	SharingOptOut             bool // True if the user opted out of sharing
+	TargetedAdvertisingOptOut bool // True if the user opted out of targeting
+}
+
+// NewUSNational returns the US national section that matches the consent. If
+// personalized adverts are not allowed then the user has opted out of sale,
+// sharing and targeted advertising.
+func NewUSNational(c common.Consent) *USNational {
+	o := c.Has(common.PurposePersonalizedAds) == false
+	return &USNational{o, o, o}
+}
+
+// GPPSectionIDs returns the section IDs present in strings from GPPString.
+func GPPSectionIDs() []int { return []int{usNatSectionID} }
+
+// GPPString returns a GPP string containing only the US national section.
+func (u *USNational) GPPString() string {
+	var h bitWriter
+	h.writeInt(gppHeaderType, 6)
+	h.writeInt(gppVersion, 6)
+	h.writeInt(1, 12)  // Number of section ID entries
+	h.writeBool(false) // Not a range
+	h.writeFibonacci(usNatSectionID)
+	return h.String() + "~" + u.String()
+}
+
+// String returns the core segment of the US national section.
+func (u *USNational) String() string {
+	var w bitWriter
+	w.writeInt(usNatVersion, 6)
+	for i := 0; i < 6; i++ {
+		w.writeInt(usNatYes, 2) // All notices are provided
+	}
+	w.writeInt(optOut(u.SaleOptOut), 2)
+	w.writeInt(optOut(u.SharingOptOut), 2)
+	w.writeInt(optOut(u.TargetedAdvertisingOptOut), 2)
+	for i := 0; i < usNatSensitive+usNatChildFields; i++ {
+		w.writeInt(usNatNotApply, 2)
+	}
+	w.writeInt(usNatNotApply, 2) // PersonalDataConsents
+	w.writeInt(usNatNo, 2)       // MspaCoveredTransaction
+	w.writeInt(usNatNotApply, 2) // MspaOptOutOptionMode
+	w.writeInt(usNatNotApply, 2) // MspaServiceProviderMode
+	return w.String()
+}
+
+// DecodeGPP returns the US national section from the GPP string. An error is
+// returned if the string does not contain the section. Ranges of section IDs
+// are not expanded so the cost of decoding does not depend on their size, and
+// the header can't list more sections than the string contains.
+func DecodeGPP(s string) (*USNational, error) {
+	p := strings.Split(s, "~")
+	r, err := newBitReader(p[0])
+	if err != nil {
+		return nil, err
+	}
+	t, err := r.readInt(6)
+	if err != nil {
+		return nil, err
+	}
+	if t != gppHeaderType {
+		return nil, fmt.Errorf("GPP header type '%d' invalid", t)
+	}
+	_, err = r.readInt(6)
+	if err != nil {
+		return nil, err
+	}
+	n, err := r.readInt(12)
+	if err != nil {
+		return nil, err
+	}
+	var c uint64 // Number of section IDs so far
+	var l uint64 // Last section ID
+	u := -1      // Position of the US national section
+	for i := 0; i < int(n); i++ {
+		g, err := r.readBool()
+		if err != nil {
+			return nil, err
+		}
+		s, err := r.readFibonacci()
+		if err != nil {
+			return nil, err
+		}
+		s += l
+		e := s
+		if g {
+			d, err := r.readFibonacci()
+			if err != nil {
+				return nil, err
+			}
+			e = s + d
+		}
+		if usNatSectionID >= s && usNatSectionID <= e && u < 0 {
+			u = int(c + usNatSectionID - s)
+		}
+		c += e - s + 1
+		if c > uint64(len(p)-1) {
+			return nil, fmt.Errorf(
+				"GPP header lists more than the %d sections present",
+				len(p)-1)
+		}
+		l = e
+	}
+	if u >= 0 {
+		return decodeUSNational(strings.Split(p[u+1], ".")[0])
+	}
+	return nil, fmt.Errorf("GPP string does not contain US national section")
+}
+
+func decodeUSNational(s string) (*USNational, error) {
+	var u USNational
+	r, err := newBitReader(s)
+	if err != nil {
+		return nil, err
+	}
+	v, err := r.readInt(6)
+	if err != nil {
+		return nil, err
+	}
+	if v != usNatVersion {
+		return nil, fmt.Errorf("US national version '%d' not supported", v)
+	}
+	_, err = r.readInt(12) // Notices
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []*bool{
+		&u.SaleOptOut,
+		&u.SharingOptOut,
+		&u.TargetedAdvertisingOptOut} {
+		v, err := r.readInt(2)
+		if err != nil {
+			return nil, err
+		}
+		*f = v == usNatYes
+	}
+	return &u, nil
+}
+
+func optOut(v bool) uint64 {
+	if v {
+		return usNatYes
+	}
+	return usNatNo
+}
@@ -0,0 +1,121 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package tcf
+
+import (
+	"common"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gppEntry is a section ID entry in a GPP header.
+type gppEntry struct {
+	start uint64 // Offset from the end of the previous entry
+	size  uint64 // Added to the start to get the end of a range, or 0
+}
+
+// gppHeader returns the header of a GPP string with the entries.
+func gppHeader(entries ...gppEntry) string {
+	var w bitWriter
+	w.writeInt(gppHeaderType, 6)
+	w.writeInt(gppVersion, 6)
+	w.writeInt(uint64(len(entries)), 12)
+	for _, e := range entries {
+		w.writeBool(e.size > 0)
+		w.writeFibonacci(e.start)
+		if e.size > 0 {
+			w.writeFibonacci(e.size)
+		}
+	}
+	return w.String()
+}
+
+// TestGPPRoundTrip checks that the opt outs of the US national section are
+// the same after the GPP string is decoded.
+func TestGPPRoundTrip(t *testing.T) {
+	for _, c := range []common.Consent{
+		nil,
+		common.Consent{common.PurposePersonalizedAds}} {
+		u := NewUSNational(c)
+		v, err := DecodeGPP(u.GPPString())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *u != *v {
+			t.Fatalf("expected %+v, got %+v", u, v)
+		}
+	}
+}
+
+// TestGPPSectionRange checks that the US national section is found when its
+// ID is part of a range.
+func TestGPPSectionRange(t *testing.T) {
+	u := NewUSNational(nil).String()
+	o := NewUSNational(common.Consent{common.PurposePersonalizedAds}).String()
+	s := gppHeader(gppEntry{2, 3}, gppEntry{1, 1}) +
+		"~" + strings.Join([]string{o, o, o, o, o, u, o}, "~")
+	v, err := DecodeGPP(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.SaleOptOut == false {
+		t.Fatal("expected the section at the position of ID 7")
+	}
+}
+
+// TestGPPInvalid checks that GPP strings which don't contain the section, or
+// whose header is not valid, are refused.
+func TestGPPInvalid(t *testing.T) {
+	u := NewUSNational(nil).String()
+	var w bitWriter
+	w.writeInt(gppHeaderType, 6)
+	w.writeInt(gppVersion, 6)
+	w.writeInt(1, 12)
+	w.writeBool(false)
+	w.writeInt(0, 40)
+	w.writeInt(3, 2)
+	for n, s := range map[string]string{
+		"empty":        "",
+		"other":        gppHeader(gppEntry{2, 0}) + "~" + u,
+		"missing":      gppHeader(gppEntry{usNatSectionID, 0}),
+		"long code":    w.String() + "~" + u,
+		"header count": gppHeader(gppEntry{1, 0}, gppEntry{6, 0}) + "~" + u,
+		"type":         strings.Replace(NewUSNational(nil).GPPString(), "D", "E", 1)} {
+		if _, err := DecodeGPP(s); err == nil {
+			t.Errorf("%s GPP string decoded", n)
+		}
+	}
+}
+
+// TestGPPLargeRange checks that a range covering a very large number of
+// section IDs is refused quickly rather than expanded.
+func TestGPPLargeRange(t *testing.T) {
+	u := NewUSNational(nil).String()
+	e := make([]gppEntry, 4095)
+	for i := range e {
+		e[i] = gppEntry{1, 3524577} // F(32) is the largest value allowed
+	}
+	n := time.Now()
+	_, err := DecodeGPP(gppHeader(e...) + "~" + u)
+	if err == nil {
+		t.Fatal("large range decoded")
+	}
+	if time.Since(n) > time.Second {
+		t.Errorf("decoding took %s", time.Since(n))
+	}
+}
@@ -0,0 +1,291 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package tcf
+
+import (
+	"common"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Fixed values for the TC strings produced by the demo.
+const (
+	tcfVersion       = 2
+	tcfPolicyVersion = 4 // TCF v2.2
+	purposeCount     = 24
+	specialFeatures  = 12
+)
+
+// purposeMap maps the demo's consent purposes to the IAB TCF purposes.
+var purposeMap = map[string][]int{
+	common.PurposePersonalizedAds:     {2, 3, 4},
+	common.PurposeMeasurement:         {7, 9},
+	common.PurposeContentPersonalized: {5, 6, 8},
+}
+
+// TCString contains the fields of the core segment of a TCF v2 TC string.
+type TCString struct {
+	Created           time.Time
+	LastUpdated       time.Time
+	CmpID             int
+	CmpVersion        int
+	ConsentScreen     int
+	ConsentLanguage   string
+	VendorListVersion int
+	PolicyVersion     int
+	PublisherCC       string
+	Purposes          []bool  // Index 0 is TCF purpose 1
+	VendorConsents    Vendors // The vendors with consent
+}
+
+// NewTCString returns a TC string that matches the consent the user provided
+// to the CMP. Purpose 1, storing information on the device, is consented to
+// if any other purpose is. The CMP ID, CMP version and vendor list version are
+// those of the CMP, and the country code is the publisher's.
+func NewTCString(
+	c common.Consent,
+	created time.Time,
+	cmpID int,
+	cmpVersion int,
+	vendorListVersion int,
+	publisherCC string,
+	vendors []int) *TCString {
+	t := TCString{
+		Created:           created,
+		LastUpdated:       created,
+		CmpID:             cmpID,
+		CmpVersion:        cmpVersion,
+		ConsentScreen:     1,
+		ConsentLanguage:   "EN",
+		VendorListVersion: vendorListVersion,
+		PolicyVersion:     tcfPolicyVersion,
+		PublisherCC:       strings.ToUpper(publisherCC),
+		Purposes:          make([]bool, purposeCount)}
+	for _, p := range c {
+		for _, i := range purposeMap[p] {
+			t.Purposes[i-1] = true
+			t.Purposes[0] = true
+		}
+	}
+	if t.Purposes[0] {
+		t.VendorConsents = NewVendors(vendors)
+	}
+	return &t
+}
+
+// Consent returns the demo purposes where all the matching TCF purposes have
+// been consented to.
+func (t *TCString) Consent() common.Consent {
+	var c []string
+	for p, m := range purposeMap {
+		a := true
+		for _, i := range m {
+			if i > len(t.Purposes) || t.Purposes[i-1] == false {
+				a = false
+			}
+		}
+		if a {
+			c = append(c, p)
+		}
+	}
+	return common.ParseConsent(strings.Join(c, ","))
+}
+
+// Purpose returns true if the TCF purpose, numbered from 1, has consent.
+func (t *TCString) Purpose(i int) bool {
+	return i > 0 && i <= len(t.Purposes) && t.Purposes[i-1]
+}
+
+// String encodes the core segment of the TC string.
+func (t *TCString) String() string {
+	var w bitWriter
+	w.writeInt(tcfVersion, 6)
+	w.writeInt(deciseconds(t.Created), 36)
+	w.writeInt(deciseconds(t.LastUpdated), 36)
+	w.writeInt(uint64(t.CmpID), 12)
+	w.writeInt(uint64(t.CmpVersion), 12)
+	w.writeInt(uint64(t.ConsentScreen), 6)
+	w.writeLetters(t.ConsentLanguage)
+	w.writeInt(uint64(t.VendorListVersion), 12)
+	w.writeInt(uint64(t.PolicyVersion), 6)
+	w.writeBool(false) // IsServiceSpecific
+	w.writeBool(false) // UseNonStandardTexts
+	w.writeInt(0, specialFeatures)
+	for i := 0; i < purposeCount; i++ {
+		w.writeBool(i < len(t.Purposes) && t.Purposes[i])
+	}
+	w.writeInt(0, purposeCount) // PurposesLITransparency
+	w.writeBool(false)          // PurposeOneTreatment
+	w.writeLetters(t.PublisherCC)
+	writeVendors(&w, t.VendorConsents)
+	writeVendors(&w, nil) // Vendor legitimate interests
+	w.writeInt(0, 12)     // NumPubRestrictions
+	return w.String()
+}
+
+// DecodeTCString parses the core segment of a TC string. Other segments
+// separated by a period are ignored.
+func DecodeTCString(s string) (*TCString, error) {
+	var t TCString
+	r, err := newBitReader(strings.Split(s, ".")[0])
+	if err != nil {
+		return nil, err
+	}
+	v, err := r.readInt(6)
+	if err != nil {
+		return nil, err
+	}
+	if v != tcfVersion {
+		return nil, fmt.Errorf("TC string version '%d' not supported", v)
+	}
+	c, err := r.readInt(36)
+	if err != nil {
+		return nil, err
+	}
+	t.Created = fromDeciseconds(c)
+	u, err := r.readInt(36)
+	if err != nil {
+		return nil, err
+	}
+	t.LastUpdated = fromDeciseconds(u)
+	ints := []*int{&t.CmpID, &t.CmpVersion, &t.ConsentScreen}
+	bits := []int{12, 12, 6}
+	for i, p := range ints {
+		v, err := r.readInt(bits[i])
+		if err != nil {
+			return nil, err
+		}
+		*p = int(v)
+	}
+	t.ConsentLanguage, err = r.readLetters()
+	if err != nil {
+		return nil, err
+	}
+	ints = []*int{&t.VendorListVersion, &t.PolicyVersion}
+	bits = []int{12, 6}
+	for i, p := range ints {
+		v, err := r.readInt(bits[i])
+		if err != nil {
+			return nil, err
+		}
+		*p = int(v)
+	}
+
+	// Skip IsServiceSpecific, UseNonStandardTexts and SpecialFeatureOptIns.
+	_, err = r.readInt(2 + specialFeatures)
+	if err != nil {
+		return nil, err
+	}
+	t.Purposes = make([]bool, purposeCount)
+	for i := range t.Purposes {
+		t.Purposes[i], err = r.readBool()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Skip PurposesLITransparency and PurposeOneTreatment.
+	_, err = r.readInt(purposeCount + 1)
+	if err != nil {
+		return nil, err
+	}
+	t.PublisherCC, err = r.readLetters()
+	if err != nil {
+		return nil, err
+	}
+	t.VendorConsents, err = readVendors(r)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// writeVendors writes a vendor section as a bit field.
+func writeVendors(w *bitWriter, vendors Vendors) {
+	m := vendors.Max()
+	w.writeInt(uint64(m), 16)
+	w.writeBool(false) // IsRangeEncoding
+	for i := 1; i <= m; i++ {
+		w.writeBool(vendors.Has(i))
+	}
+}
+
+// readVendors reads a vendor section encoded as either a bit field or ranges.
+// Ranges must be in order and end at or before the maximum vendor ID of the
+// section.
+func readVendors(r *bitReader) (Vendors, error) {
+	m, err := r.readInt(16)
+	if err != nil {
+		return nil, err
+	}
+	vendors := newVendors(int(m))
+	g, err := r.readBool()
+	if err != nil {
+		return nil, err
+	}
+	if g == false {
+		for i := 1; i <= int(m); i++ {
+			b, err := r.readBool()
+			if err != nil {
+				return nil, err
+			}
+			if b {
+				vendors.add(i, i)
+			}
+		}
+		return vendors, nil
+	}
+	n, err := r.readInt(12)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(n); i++ {
+		a, err := r.readBool()
+		if err != nil {
+			return nil, err
+		}
+		s, err := r.readInt(16)
+		if err != nil {
+			return nil, err
+		}
+		e := s
+		if a {
+			e, err = r.readInt(16)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if s == 0 || e < s || e > m {
+			return nil, fmt.Errorf(
+				"Vendor range %d-%d invalid for maximum vendor ID %d",
+				s,
+				e,
+				m)
+		}
+		vendors.add(int(s), int(e))
+	}
+	return vendors, nil
+}
+
+func deciseconds(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(100*time.Millisecond))
+}
+
+func fromDeciseconds(v uint64) time.Time {
+	return time.Unix(0, int64(v)*int64(100*time.Millisecond)).UTC()
+}
@@ -0,0 +1,242 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package tcf
+
+import (
+	"common"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// field returns the value as a string of bits of the width provided.
+func field(v uint64, width int) string {
+	return fmt.Sprintf("%0*b", width, v)
+}
+
+// letters returns the two letter code as a string of bits.
+func letters(s string) string {
+	return field(uint64(s[0]-'A'), 6) + field(uint64(s[1]-'A'), 6)
+}
+
+// encodeBits returns the string of bits as a URL safe base 64 string padded
+// with zero bits to a whole number of bytes.
+func encodeBits(s string) string {
+	b := make([]byte, (len(s)+7)/8)
+	for i, c := range s {
+		if c == '1' {
+			b[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// coreFields returns the bits of a core segment up to and including the
+// publisher country code using the field widths from the IAB TCF v2 table.
+func coreFields(
+	created uint64,
+	cmpID uint64,
+	vendorListVersion uint64,
+	purposes []int,
+	publisherCC string) string {
+	p := []byte(strings.Repeat("0", purposeCount))
+	for _, i := range purposes {
+		p[i-1] = '1'
+	}
+	return field(2, 6) + // Version
+		field(created, 36) + // Created
+		field(created, 36) + // LastUpdated
+		field(cmpID, 12) + // CmpId
+		field(1, 12) + // CmpVersion
+		field(1, 6) + // ConsentScreen
+		letters("EN") + // ConsentLanguage
+		field(vendorListVersion, 12) + // VendorListVersion
+		field(4, 6) + // TcfPolicyVersion
+		"0" + // IsServiceSpecific
+		"0" + // UseNonStandardTexts
+		field(0, 12) + // SpecialFeatureOptIns
+		string(p) + // PurposesConsent
+		field(0, 24) + // PurposesLITransparency
+		"0" + // PurposeOneTreatment
+		letters(publisherCC) // PublisherCC
+}
+
+// TestDecodeReference decodes a TC string from the IAB examples whose fields
+// were decoded by hand using the IAB field table.
+func TestDecodeReference(t *testing.T) {
+	s, err := DecodeTCString("COvFyGBOvFyGBAbAAAENAPCAAOAAAAAAAAAAAAAAAAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := fromDeciseconds(15822430593)
+	if s.Created.Equal(c) == false || s.LastUpdated.Equal(c) == false {
+		t.Errorf("created %s updated %s, expected %s",
+			s.Created,
+			s.LastUpdated,
+			c)
+	}
+	for _, v := range []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"CmpID", s.CmpID, 27},
+		{"CmpVersion", s.CmpVersion, 0},
+		{"ConsentScreen", s.ConsentScreen, 0},
+		{"ConsentLanguage", s.ConsentLanguage, "EN"},
+		{"VendorListVersion", s.VendorListVersion, 15},
+		{"PolicyVersion", s.PolicyVersion, 2},
+		{"PublisherCC", s.PublisherCC, "AA"},
+		{"VendorConsents", s.VendorConsents.IDs(), []int(nil)},
+	} {
+		if reflect.DeepEqual(v.value, v.expected) == false {
+			t.Errorf("%s is %v, expected %v", v.name, v.value, v.expected)
+		}
+	}
+	for i := 1; i <= purposeCount; i++ {
+		if s.Purpose(i) != (i <= 3) {
+			t.Errorf("purpose %d is %v", i, s.Purpose(i))
+		}
+	}
+}
+
+// TestDecodeRanges decodes a hand assembled TC string whose vendor consents
+// use range encoding with a single vendor and a range ending at the maximum
+// vendor ID.
+func TestDecodeRanges(t *testing.T) {
+	b := coreFields(16000000000, 10, 100, []int{1, 2, 3, 4}, "GB") +
+		field(200, 16) + // MaxVendorId
+		"1" + // IsRangeEncoding
+		field(2, 12) + // NumEntries
+		"0" + field(5, 16) + // Single vendor 5
+		"1" + field(100, 16) + field(200, 16) + // Vendors 100 to 200
+		field(0, 16) + "0" + // Vendor legitimate interests
+		field(0, 12) // NumPubRestrictions
+	s, err := DecodeTCString(encodeBits(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.CmpID != 10 || s.VendorListVersion != 100 || s.PublisherCC != "GB" {
+		t.Fatalf("fields decoded incorrectly %+v", s)
+	}
+	ids := s.VendorConsents.IDs()
+	if len(ids) != 102 || ids[0] != 5 || ids[1] != 100 || ids[101] != 200 {
+		t.Fatalf("vendors decoded incorrectly %v", ids)
+	}
+}
+
+// TestEncode checks the encoded TC string against one assembled by hand from
+// the IAB field table.
+func TestEncode(t *testing.T) {
+	c := fromDeciseconds(16000000000)
+	s := NewTCString(
+		common.Consent{common.PurposePersonalizedAds},
+		c,
+		10,
+		1,
+		100,
+		"gb",
+		[]int{1, 3})
+	b := coreFields(16000000000, 10, 100, []int{1, 2, 3, 4}, "GB") +
+		field(3, 16) + "0" + "101" + // Vendors 1 and 3 as a bit field
+		field(0, 16) + "0" + // Vendor legitimate interests
+		field(0, 12) // NumPubRestrictions
+	if s.String() != encodeBits(b) {
+		t.Fatalf("encoded '%s', expected '%s'", s.String(), encodeBits(b))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := NewTCString(
+		common.ParseConsent("on"),
+		c,
+		300,
+		2,
+		150,
+		"FR",
+		[]int{2, 64, 65, 755})
+	d, err := DecodeTCString(s.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(s, d) == false {
+		t.Fatalf("decoded %+v, expected %+v", d, s)
+	}
+	if reflect.DeepEqual(d.Consent(), s.Consent()) == false {
+		t.Fatalf("consent %v, expected %v", d.Consent(), s.Consent())
+	}
+}
+
+func TestDecodeInvalidRanges(t *testing.T) {
+	for _, v := range []struct {
+		name  string
+		entry string
+	}{
+		{"end before start", "1" + field(10, 16) + field(5, 16)},
+		{"end after maximum", "1" + field(10, 16) + field(201, 16)},
+		{"zero start", "0" + field(0, 16)},
+	} {
+		b := coreFields(16000000000, 10, 100, nil, "GB") +
+			field(200, 16) + "1" + field(1, 12) + v.entry +
+			field(0, 16) + "0" + field(0, 12)
+		_, err := DecodeTCString(encodeBits(b))
+		if err == nil {
+			t.Errorf("%s should not decode", v.name)
+		}
+	}
+}
+
+// TestDecodeLargeRanges checks that the maximum number of ranges covering
+// every vendor ID is decoded quickly into a set of bounded size.
+func TestDecodeLargeRanges(t *testing.T) {
+	e := "1" + field(1, 16) + field(65535, 16)
+	b := coreFields(16000000000, 10, 100, nil, "GB") +
+		field(65535, 16) + "1" + field(4095, 12) + strings.Repeat(e, 4095) +
+		field(0, 16) + "0" + field(0, 12)
+	n := time.Now()
+	s, err := DecodeTCString(encodeBits(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(n) > time.Second {
+		t.Errorf("decoding took %s", time.Since(n))
+	}
+	if len(s.VendorConsents) > 1024 || s.VendorConsents.Max() != 65535 {
+		t.Errorf("unexpected vendor set of %d words with maximum %d",
+			len(s.VendorConsents),
+			s.VendorConsents.Max())
+	}
+}
+
+func TestVendors(t *testing.T) {
+	v := NewVendors([]int{0, 1, 63, 64, 65, 128})
+	if reflect.DeepEqual(v.IDs(), []int{1, 63, 64, 65, 128}) == false {
+		t.Fatalf("IDs %v", v.IDs())
+	}
+	if v.Max() != 128 || v.Has(0) || v.Has(2) || v.Has(129) {
+		t.Fatal("set membership incorrect")
+	}
+	w := newVendors(200)
+	w.add(3, 190)
+	if len(w.IDs()) != 188 || w.Has(2) || w.Has(191) || w.Max() != 190 {
+		t.Fatalf("range added incorrectly %v", w.IDs())
+	}
+}
@@ -0,0 +1,91 @@
+/* ****************************************************************************
+ * Copyright 2020 51 Degrees Mobile Experts Limited (51degrees.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+ * License for the specific language governing permissions and limitations
+ * under the License.
+ * ***************************************************************************/
+
+package tcf
+
+import "math/bits"
+
+// Vendors is a set of IAB vendor IDs stored as a bit set where bit 0 of the
+// first word is vendor ID 1. Vendor IDs are at most 16 bits so the set is
+// never more than 8KB however the IDs are encoded in the TC string.
+type Vendors []uint64
+
+// NewVendors returns the set of vendor IDs provided. IDs less than 1 are
+// ignored.
+func NewVendors(ids []int) Vendors {
+	m := 0
+	for _, i := range ids {
+		if i > m {
+			m = i
+		}
+	}
+	v := newVendors(m)
+	for _, i := range ids {
+		if i > 0 {
+			v.add(i, i)
+		}
+	}
+	return v
+}
+
+// newVendors returns an empty set with room for vendor IDs up to the maximum.
+func newVendors(max int) Vendors {
+	return make(Vendors, (max+63)/64)
+}
+
+// add includes the vendor IDs from s to e inclusive. Whole words are set at a
+// time so large ranges are cheap.
+func (v Vendors) add(s int, e int) {
+	for i := s - 1; i < e; {
+		w, b := i/64, uint(i%64)
+		if b == 0 && i+64 <= e {
+			v[w] = ^uint64(0)
+			i += 64
+		} else {
+			v[w] |= 1 << b
+			i++
+		}
+	}
+}
+
+// Has returns true if the vendor ID is in the set.
+func (v Vendors) Has(id int) bool {
+	i := id - 1
+	return i >= 0 && i/64 < len(v) && v[i/64]&(1<<uint(i%64)) != 0
+}
+
+// Max returns the highest vendor ID in the set, or 0 if the set is empty.
+func (v Vendors) Max() int {
+	for w := len(v) - 1; w >= 0; w-- {
+		if v[w] != 0 {
+			return w*64 + 64 - bits.LeadingZeros64(v[w])
+		}
+	}
+	return 0
+}
+
+// IDs returns the vendor IDs in the set in ascending order.
+func (v Vendors) IDs() []int {
+	var ids []int
+	for w, b := range v {
+		for b != 0 {
+			i := bits.TrailingZeros64(b)
+			ids = append(ids, w*64+i+1)
+			b &= b - 1
+		}
+	}
+	return ids
+}
@@ -0,0 +1,69 @@
+// Fills the advert placements on the page after it has loaded. Each element
+// with a data-placement attribute is filled with the advert returned from the
+// publisher's /ad endpoint. Placements below the fold are only requested when
+// they are about to be scrolled into view.
+(function() {
+    "use strict";
+
+    // Replaces the content of the element with a message when there is no
+    // advert.
+    function noAdvert(e, m) {
+        var p = document.createElement("p");
+        p.innerText = m;
+        e.innerHTML = "";
+        e.appendChild(p);
+        e.classList.add("advert-placeholder");
+    }
+
+    // Requests the advert for the element's placement.
+    function fill(e) {
+        var u = "/ad?placement=" +
+            encodeURIComponent(e.getAttribute("data-placement"));
+        fetch(u, { credentials: "same-origin" })
+            .then(function(r) {
+                if (r.ok === false) {
+                    throw new Error(r.statusText);
+                }
+                return r.json();
+            })
+            .then(function(a) {
+                if (a.bid) {
+                    e.style.height = "";
+                    e.innerHTML = a.html;
+                    if (window.swanEvents) {
+                        swanEvents.observe(e);
+                    }
+                } else {
+                    noAdvert(e, "No advert available");
+                }
+            })
+            .catch(function() {
+                noAdvert(e, "Advert not available");
+            });
+    }
+
+    // Fills placements that are visible now and observes the rest so they
+    // are filled when they come close to the viewport.
+    function start() {
+        var s = document.querySelectorAll("[data-placement]");
+        if ("IntersectionObserver" in window) {
+            var o = new IntersectionObserver(function(entries) {
+                entries.forEach(function(n) {
+                    if (n.isIntersecting) {
+                        o.unobserve(n.target);
+                        fill(n.target);
+                    }
+                });
+            }, { rootMargin: "200px" });
+            s.forEach(function(e) { o.observe(e); });
+        } else {
+            s.forEach(fill);
+        }
+    }
+
+    if (document.readyState === "loading") {
+        document.addEventListener("DOMContentLoaded", start);
+    } else {
+        start();
+    }
+})();
@@ -0,0 +1,65 @@
+// Sends the viewable beacons for adverts on the page. An advert is viewable
+// when at least 50% of it has been in the viewport for one second. Adverts
+// are elements with a data-viewable attribute containing the space separated
+// beacon URLs. Adverts added after the page has loaded are passed to observe.
+swanEvents = function() {
+    "use strict";
+
+    var threshold = 0.5;
+    var duration = 1000;
+    var observer = null;
+    var timers = new Map();
+
+    // Requests each of the beacon URLs for the advert.
+    function send(e) {
+        e.getAttribute("data-viewable").split(" ").forEach(function(u) {
+            if (u !== "") {
+                new Image().src = u;
+            }
+        });
+    }
+
+    function changed(entries) {
+        entries.forEach(function(n) {
+            var e = n.target;
+            if (n.intersectionRatio >= threshold) {
+                if (timers.has(e) === false) {
+                    timers.set(e, setTimeout(function() {
+                        observer.unobserve(e);
+                        timers.delete(e);
+                        send(e);
+                    }, duration));
+                }
+            } else if (timers.has(e)) {
+                clearTimeout(timers.get(e));
+                timers.delete(e);
+            }
+        });
+    }
+
+    // Observes the advert elements within the element provided.
+    function observe(r) {
+        if (observer === null) {
+            return;
+        }
+        if (r.hasAttribute && r.hasAttribute("data-viewable")) {
+            observer.observe(r);
+        }
+        r.querySelectorAll("[data-viewable]").forEach(function(e) {
+            observer.observe(e);
+        });
+    }
+
+    if ("IntersectionObserver" in window) {
+        observer = new IntersectionObserver(changed, { threshold: threshold });
+    }
+    if (document.readyState === "loading") {
+        document.addEventListener("DOMContentLoaded", function() {
+            observe(document);
+        });
+    } else {
+        observe(document);
+    }
+
+    return { observe: observe };
+}();
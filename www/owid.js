@@ -114,9 +114,10 @@ owid = function() {
         }
     }
 
-    this.stop = function(s, d, r) {
+    this.stop = function(s, d, r, c) {
         fetch("/stop?" +
             "host=" + encodeURIComponent(d) + "&" +
+            (c ? "scope=" + encodeURIComponent(c) + "&" : "") +
             "returnUrl=" + encodeURIComponent(r))
             .then(r => r.text() )
             .then(m => {
@@ -128,26 +129,22 @@ owid = function() {
             });
     }
 
-    this.appendComplaintEmail = function(e, o, s, g) {
-        fetch("/complain?" +
+    this.appendComplaint = function(e, h, o, s, g, c) {
+        var a = document.createElement("a");
+        a.href = (h ? "//" + h : "") + "/complain?" +
             "offerid=" + encodeURIComponent(o) + "&" +
-            "swanowid=" + encodeURIComponent(s))
-            .then(r => r.text() )
-            .then(m => {
-                var a = document.createElement("a");
-                a.href = m;
-                if (g) {
-                    var i = document.createElement("img");
-                    i.src = g;
-                    i.style="width:32px"
-                    a.appendChild(i);
-                } else {
-                    a.innerText = "?";
-                }
-                e.appendChild(a);
-            }).catch(x => {
-                console.log(x);
-            });
+            "swanowid=" + encodeURIComponent(s) +
+            (c ? "&cbid=" + encodeURIComponent(c) : "");
+        a.title = "Complain about this organization";
+        if (g) {
+            var i = document.createElement("img");
+            i.src = g;
+            i.style="width:32px"
+            a.appendChild(i);
+        } else {
+            a.innerText = "?";
+        }
+        e.appendChild(a);
     }
 
     this.appendName = function(e, s) {